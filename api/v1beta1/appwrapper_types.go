@@ -28,10 +28,11 @@ type AppWrapperSpec struct {
 	// Priority
 	Priority int32 `json:"priority,omitempty"`
 
-	// Priority slope
-	NotImplemented_PrioritySlope resource.Quantity `json:"priorityslope,omitempty"`
+	// Priority slope, i.e., increase of the effective priority per second spent queued
+	PrioritySlope resource.Quantity `json:"priorityslope,omitempty"`
 
-	NotImplemented_Service AppWrapperService `json:"service,omitempty"`
+	// Service to create for the wrapped pods
+	Service *AppWrapperService `json:"service,omitempty"`
 
 	// Wrapped resources
 	Resources AppWrapperResources `json:"resources"`
@@ -44,7 +45,11 @@ type AppWrapperSpec struct {
 }
 
 type SchedulingSpec struct {
-	NotImplemented_NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Only dispatch if the resource requests fit on nodes matching these labels
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Only dispatch if all the pods fit on schedulable nodes sharing the same value of this node label, e.g., a rack or zone
+	TopologyKey string `json:"topologyKey,omitempty"`
 
 	// Minimum number of expected running and successful pods.
 	// Set to -1 to disable pod monitoring, cleanup on failure, and termination detection based on pod counts.
@@ -55,27 +60,40 @@ type SchedulingSpec struct {
 	// Requeuing specification
 	Requeuing RequeuingSpec `json:"requeuing,omitempty"`
 
-	NotImplemented_DispatchDuration NotImplemented_DispatchDurationSpec `json:"dispatchDuration,omitempty"`
+	// Dispatch duration specification
+	DispatchDuration DispatchDurationSpec `json:"dispatchDuration,omitempty"`
+
+	// Min time in seconds from dispatch before the AppWrapper may be preempted if preemption is enabled
+	PreemptionGracePeriodInSeconds int64 `json:"preemptionGracePeriodInSeconds,omitempty"`
 }
 
-type NotImplemented_DispatchDurationSpec struct {
+type DispatchDurationSpec struct {
+	// Expected time in seconds from dispatch to completion, used by the dispatcher as an estimate
 	Expected int32 `json:"expected,omitempty"`
-	Limit    int32 `json:"limit,omitempty"`
-	Overrun  bool  `json:"overrun,omitempty"`
+
+	// Max time in seconds from dispatch to completion (unbounded if zero)
+	Limit int32 `json:"limit,omitempty"`
+
+	// Requeue instead of failing the AppWrapper when exceeding the limit
+	Overrun bool `json:"overrun,omitempty"`
 }
 
 type RequeuingSpec struct {
-	NotImplemented_InitialTimeInSeconds int64 `json:"initialTimeInSeconds,omitempty"`
+	// Initial waiting time before requeuing conditions are checked, overrides timeInSeconds if greater than zero
+	InitialTimeInSeconds int64 `json:"initialTimeInSeconds,omitempty"`
 
 	// Initial waiting time before requeuing conditions are checked
 	// +kubebuilder:default=270
 	TimeInSeconds int64 `json:"timeInSeconds,omitempty"`
 
+	// Max waiting time before requeuing conditions are checked (unbounded if zero)
 	// +kubebuilder:default=0
-	NotImplemented_MaxTimeInSeconds int64 `json:"maxTimeInSeconds,omitempty"`
+	MaxTimeInSeconds int64 `json:"maxTimeInSeconds,omitempty"`
 
+	// Growth of the waiting time with the number of restarts
 	// +kubebuilder:default=exponential
-	NotImplemented_GrowthType string `json:"growthType,omitempty"`
+	// +kubebuilder:validation:Enum=exponential;linear;none
+	GrowthType string `json:"growthType,omitempty"`
 
 	// +kubebuilder:default=0
 	NotImplemented_NumRequeuings int32 `json:"numRequeuings,omitempty"`
@@ -110,6 +128,16 @@ type AppWrapperStatus struct {
 	// How many times restarted
 	Restarts int32 `json:"restarts"`
 
+	// Effective priority, i.e., priority plus priority slope times time spent queued
+	EffectivePriority int32 `json:"effectivePriority,omitempty"`
+
+	// Waiting time before requeuing conditions are checked for the current dispatch
+	RequeuingTimeInSeconds int64 `json:"requeuingTimeInSeconds,omitempty"`
+
+	// Number of pods of each generic item placed on nodes when the AppWrapper was dispatched, reset when idle
+	// Pods declared by the custom pod resources if per-node capacity is unknown
+	Allocated []int32 `json:"allocated,omitempty"`
+
 	// Transition log
 	Transitions []AppWrapperTransition `json:"transitions,omitempty"`
 
@@ -169,18 +197,47 @@ const (
 	// Queued because of insufficient available resources
 	QueuedInsufficientResources AppWrapperQueuedReason = "InsufficientResources"
 
+	// Queued because the available resources suffice in aggregate but the pods do not fit on the nodes
+	QueuedFragmented AppWrapperQueuedReason = "Fragmented"
+
 	// Queued because of insufficient available quota
 	QueuedInsufficientQuota AppWrapperQueuedReason = "InsufficientQuota"
 
 	// Queued because it was requeued
 	QueuedRequeue AppWrapperQueuedReason = "Requeued"
 
+	// Queued because it was preempted by a higher-priority AppWrapper
+	QueuedPreempted AppWrapperQueuedReason = "Preempted"
+
 	// Not Queued because it was dispatched
 	QueuedDispatch AppWrapperQueuedReason = "Dispatched"
 )
 
-// AppWrapperService
+const (
+	// Condition type flagging discrepancies between declared and inferred demand in strict demand mode
+	DemandDiscrepancy = "DemandDiscrepancy"
+
+	// Declared requests are lower than requests inferred from pod templates for some resources
+	DemandUnderstated = "RequestsUnderstated"
+
+	// Declared requests cover requests inferred from pod templates
+	DemandConsistent = "RequestsConsistent"
+)
+
+const (
+	// Waiting time doubles with every restart
+	ExponentialGrowth = "exponential"
+
+	// Waiting time increases by the initial waiting time with every restart
+	LinearGrowth = "linear"
+
+	// Waiting time does not change
+	NoGrowth = "none"
+)
+
+// AppWrapperService specifies a Service named after the AppWrapper and selecting the wrapped pods
 type AppWrapperService struct {
+	// Service spec, selector is extended to select the wrapped pods
 	Spec v1.ServiceSpec `json:"spec"`
 }
 
@@ -191,15 +248,19 @@ type AppWrapperResources struct {
 
 // AppWrapper resource
 type GenericItem struct {
-	NotImplemented_Replicas int32 `json:"replicas,omitempty"`
-
-	NotImplemented_MinAvailable *int32 `json:"minavailable,omitempty"`
+	// Expected number of pods created by this item
+	Replicas int32 `json:"replicas,omitempty"`
 
-	NotImplemented_Allocated int32 `json:"allocated,omitempty"`
+	// Min number of running or succeeded pods created by this item for the AppWrapper to be healthy, capped by Replicas if positive
+	// Pods are attributed to the item using the appwrapper.mcad.ibm.com/item label
+	// Not checked while some pods of the AppWrapper are missing this label, e.g., pods created before upgrading MCAD
+	MinAvailable *int32 `json:"minavailable,omitempty"`
 
-	NotImplemented_Priority int32 `json:"priority,omitempty"`
+	// Priority of this item, defaults to the AppWrapper priority
+	Priority *int32 `json:"priority,omitempty"`
 
-	NotImplemented_PrioritySlope resource.Quantity `json:"priorityslope,omitempty"`
+	// Priority slope of this item, defaults to the AppWrapper priority slope
+	PrioritySlope *resource.Quantity `json:"priorityslope,omitempty"`
 
 	// The template for the resource
 	// +kubebuilder:pruning:PreserveUnknownFields
@@ -245,6 +306,7 @@ type AppWrapperTransition struct {
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:storageversion
 //+kubebuilder:printcolumn:name="Status",type="string",JSONPath=`.status.state`
 //+kubebuilder:printcolumn:name="Restarts",type="integer",JSONPath=`.status.restarts`
 //+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
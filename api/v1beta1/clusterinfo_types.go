@@ -23,17 +23,96 @@ import (
 
 // ClusterInfoSpec defines the desired state of ClusterInfo
 type ClusterInfoSpec struct {
+	// Policy for computing the capacity available to AppWrappers
+	CapacityPolicy CapacityPolicy `json:"capacityPolicy,omitempty"`
+}
+
+// CapacityPolicy specifies how much of the cluster capacity is available to AppWrappers
+type CapacityPolicy struct {
+	// Resources held back from AppWrappers in aggregate
+	// Headroom only reduces the aggregate capacity, not the capacity of nodes, tainted nodes, or topology domains
+	Headroom v1.ResourceList `json:"headroom,omitempty"`
+
+	// Namespaces whose pods do not reduce the available capacity, e.g., system pods accounted for by node allocatable
+	IgnoredNamespaces []string `json:"ignoredNamespaces,omitempty"`
+
+	// Namespaces whose pods always reduce the available capacity, including pods not bound to a node yet
+	// Pods not bound to a node yet only reduce the aggregate capacity
+	ReservedNamespaces []string `json:"reservedNamespaces,omitempty"`
+
+	// Do not reduce the available capacity by the requests of DaemonSet pods
+	IgnoreDaemonSetPods bool `json:"ignoreDaemonSetPods,omitempty"`
+
+	// Percentage of the remaining capacity of each node held back from AppWrappers, e.g., for interactive notebooks
+	// The burst reserve reduces the capacity of nodes, tainted nodes, and topology domains as well as the aggregate capacity
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	BurstReservePercent int32 `json:"burstReservePercent,omitempty"`
 }
 
 // ClusterInfoStatus defines the observed state of ClusterInfo
 type ClusterInfoStatus struct {
-	// Capacity available on the cluster
+	// Capacity available on the cluster, excluding nodes with NoSchedule or NoExecute taints
 	Capacity v1.ResourceList `json:"capacity,omitempty"`
 
+	// Capacity available on the nodes with NoSchedule or NoExecute taints, grouped by taint set
+	TaintedCapacity []TaintedCapacityInfo `json:"taintedCapacity,omitempty"`
+
+	// Capacity available on each schedulable node, including tainted nodes
+	Nodes []NodeInfo `json:"nodes,omitempty"`
+
+	// Node label keys reported for each node, node selectors are only checked against these keys
+	NodeLabelKeys []string `json:"nodeLabelKeys,omitempty"`
+
+	// Capacity available in each topology domain, i.e., each set of untainted schedulable nodes with the same value of a topology label
+	Domains []TopologyDomainInfo `json:"domains,omitempty"`
+
 	// When last updated
 	Time metav1.Time `json:"time,omitempty"`
 }
 
+// NodeInfo describes the capacity available on a node
+type NodeInfo struct {
+	// Node name
+	Name string `json:"name"`
+
+	// Node labels restricted to the reported node label keys
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Capacity available on the node
+	Capacity v1.ResourceList `json:"capacity,omitempty"`
+
+	// NoSchedule and NoExecute taints of the node
+	Taints []v1.Taint `json:"taints,omitempty"`
+}
+
+// TaintedCapacityInfo describes the capacity available on the nodes with a given set of taints
+type TaintedCapacityInfo struct {
+	// NoSchedule and NoExecute taints shared by the nodes
+	Taints []v1.Taint `json:"taints"`
+
+	// Number of nodes with these taints
+	NodeCount int32 `json:"nodeCount"`
+
+	// Capacity available on the nodes with these taints
+	Capacity v1.ResourceList `json:"capacity,omitempty"`
+}
+
+// TopologyDomainInfo describes the capacity available in a topology domain
+type TopologyDomainInfo struct {
+	// Topology label key
+	Key string `json:"key"`
+
+	// Topology label value shared by the nodes of the domain
+	Value string `json:"value"`
+
+	// Number of schedulable nodes in the domain
+	NodeCount int32 `json:"nodeCount"`
+
+	// Capacity available in the domain
+	Capacity v1.ResourceList `json:"capacity,omitempty"`
+}
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 //+kubebuilder:resource:path=clusterinfo
@@ -0,0 +1,96 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// QuotaNodeSpec defines the desired state of QuotaNode
+type QuotaNodeSpec struct {
+	// Name of the parent quota node, empty for a root quota node
+	Parent string `json:"parent,omitempty"`
+
+	// Namespaces whose AppWrappers consume this quota, only permitted for leaf quota nodes
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// Resources guaranteed to this quota node
+	Guaranteed v1.ResourceList `json:"guaranteed,omitempty"`
+
+	// Max resources this quota node may borrow from the idle quota of its siblings in excess of its guaranteed resources
+	Borrowable v1.ResourceList `json:"borrowable,omitempty"`
+}
+
+// QuotaNodeStatus defines the observed state of QuotaNode
+type QuotaNodeStatus struct {
+	// Resources used by the dispatched AppWrappers of the quota node and its descendants
+	Used v1.ResourceList `json:"used,omitempty"`
+
+	// Resources borrowed from siblings, i.e., used in excess of the guaranteed resources
+	Borrowed v1.ResourceList `json:"borrowed,omitempty"`
+
+	// When last updated
+	Time metav1.Time `json:"time,omitempty"`
+
+	// Conditions
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+const (
+	// Condition type flagging quota nodes that do not form a valid quota tree
+	QuotaNodeMisconfigured = "Misconfigured"
+
+	// Parent quota node does not exist, the quota node is treated as a root quota node
+	QuotaNodeUnknownParent = "UnknownParent"
+
+	// Quota node is its own ancestor, paths to the root stop before repeating a quota node
+	QuotaNodeParentCycle = "ParentCycle"
+
+	// Quota node has both children and namespaces, namespaces are only permitted for leaf quota nodes
+	QuotaNodeInnerNamespaces = "NamespacesOnInnerNode"
+
+	// Quota node is part of a valid quota tree
+	QuotaNodeWellFormed = "WellFormed"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Parent",type="string",JSONPath=".spec.parent"
+
+// QuotaNode is the Schema for the quotanodes API
+type QuotaNode struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   QuotaNodeSpec   `json:"spec,omitempty"`
+	Status QuotaNodeStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// QuotaNodeList contains a list of QuotaNode
+type QuotaNodeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []QuotaNode `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&QuotaNode{}, &QuotaNodeList{})
+}
@@ -126,8 +126,12 @@ func (in *AppWrapperService) DeepCopy() *AppWrapperService {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AppWrapperSpec) DeepCopyInto(out *AppWrapperSpec) {
 	*out = *in
-	out.NotImplemented_PrioritySlope = in.NotImplemented_PrioritySlope.DeepCopy()
-	in.NotImplemented_Service.DeepCopyInto(&out.NotImplemented_Service)
+	out.PrioritySlope = in.PrioritySlope.DeepCopy()
+	if in.Service != nil {
+		in, out := &in.Service, &out.Service
+		*out = new(AppWrapperService)
+		(*in).DeepCopyInto(*out)
+	}
 	in.Resources.DeepCopyInto(&out.Resources)
 	if in.NotImplemented_Selector != nil {
 		in, out := &in.NotImplemented_Selector, &out.NotImplemented_Selector
@@ -152,6 +156,11 @@ func (in *AppWrapperStatus) DeepCopyInto(out *AppWrapperStatus) {
 	*out = *in
 	in.DispatchTimestamp.DeepCopyInto(&out.DispatchTimestamp)
 	in.RequeueTimestamp.DeepCopyInto(&out.RequeueTimestamp)
+	if in.Allocated != nil {
+		in, out := &in.Allocated, &out.Allocated
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
 	if in.Transitions != nil {
 		in, out := &in.Transitions, &out.Transitions
 		*out = make([]AppWrapperTransition, len(*in))
@@ -194,12 +203,44 @@ func (in *AppWrapperTransition) DeepCopy() *AppWrapperTransition {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CapacityPolicy) DeepCopyInto(out *CapacityPolicy) {
+	*out = *in
+	if in.Headroom != nil {
+		in, out := &in.Headroom, &out.Headroom
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.IgnoredNamespaces != nil {
+		in, out := &in.IgnoredNamespaces, &out.IgnoredNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ReservedNamespaces != nil {
+		in, out := &in.ReservedNamespaces, &out.ReservedNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CapacityPolicy.
+func (in *CapacityPolicy) DeepCopy() *CapacityPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(CapacityPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ClusterInfo) DeepCopyInto(out *ClusterInfo) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -256,6 +297,7 @@ func (in *ClusterInfoList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ClusterInfoSpec) DeepCopyInto(out *ClusterInfoSpec) {
 	*out = *in
+	in.CapacityPolicy.DeepCopyInto(&out.CapacityPolicy)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterInfoSpec.
@@ -278,6 +320,32 @@ func (in *ClusterInfoStatus) DeepCopyInto(out *ClusterInfoStatus) {
 			(*out)[key] = val.DeepCopy()
 		}
 	}
+	if in.TaintedCapacity != nil {
+		in, out := &in.TaintedCapacity, &out.TaintedCapacity
+		*out = make([]TaintedCapacityInfo, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]NodeInfo, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.NodeLabelKeys != nil {
+		in, out := &in.NodeLabelKeys, &out.NodeLabelKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Domains != nil {
+		in, out := &in.Domains, &out.Domains
+		*out = make([]TopologyDomainInfo, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	in.Time.DeepCopyInto(&out.Time)
 }
 
@@ -320,15 +388,39 @@ func (in *CustomPodResource) DeepCopy() *CustomPodResource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DispatchDurationSpec) DeepCopyInto(out *DispatchDurationSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DispatchDurationSpec.
+func (in *DispatchDurationSpec) DeepCopy() *DispatchDurationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DispatchDurationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GenericItem) DeepCopyInto(out *GenericItem) {
 	*out = *in
-	if in.NotImplemented_MinAvailable != nil {
-		in, out := &in.NotImplemented_MinAvailable, &out.NotImplemented_MinAvailable
+	if in.MinAvailable != nil {
+		in, out := &in.MinAvailable, &out.MinAvailable
 		*out = new(int32)
 		**out = **in
 	}
-	out.NotImplemented_PrioritySlope = in.NotImplemented_PrioritySlope.DeepCopy()
+	if in.Priority != nil {
+		in, out := &in.Priority, &out.Priority
+		*out = new(int32)
+		**out = **in
+	}
+	if in.PrioritySlope != nil {
+		in, out := &in.PrioritySlope, &out.PrioritySlope
+		x := (*in).DeepCopy()
+		*out = &x
+	}
 	in.GenericTemplate.DeepCopyInto(&out.GenericTemplate)
 	if in.CustomPodResources != nil {
 		in, out := &in.CustomPodResources, &out.CustomPodResources
@@ -350,16 +442,167 @@ func (in *GenericItem) DeepCopy() *GenericItem {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *NotImplemented_DispatchDurationSpec) DeepCopyInto(out *NotImplemented_DispatchDurationSpec) {
+func (in *NodeInfo) DeepCopyInto(out *NodeInfo) {
 	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Capacity != nil {
+		in, out := &in.Capacity, &out.Capacity
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.Taints != nil {
+		in, out := &in.Taints, &out.Taints
+		*out = make([]corev1.Taint, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NotImplemented_DispatchDurationSpec.
-func (in *NotImplemented_DispatchDurationSpec) DeepCopy() *NotImplemented_DispatchDurationSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeInfo.
+func (in *NodeInfo) DeepCopy() *NodeInfo {
 	if in == nil {
 		return nil
 	}
-	out := new(NotImplemented_DispatchDurationSpec)
+	out := new(NodeInfo)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QuotaNode) DeepCopyInto(out *QuotaNode) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuotaNode.
+func (in *QuotaNode) DeepCopy() *QuotaNode {
+	if in == nil {
+		return nil
+	}
+	out := new(QuotaNode)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *QuotaNode) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QuotaNodeList) DeepCopyInto(out *QuotaNodeList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]QuotaNode, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuotaNodeList.
+func (in *QuotaNodeList) DeepCopy() *QuotaNodeList {
+	if in == nil {
+		return nil
+	}
+	out := new(QuotaNodeList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *QuotaNodeList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QuotaNodeSpec) DeepCopyInto(out *QuotaNodeSpec) {
+	*out = *in
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Guaranteed != nil {
+		in, out := &in.Guaranteed, &out.Guaranteed
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.Borrowable != nil {
+		in, out := &in.Borrowable, &out.Borrowable
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuotaNodeSpec.
+func (in *QuotaNodeSpec) DeepCopy() *QuotaNodeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(QuotaNodeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QuotaNodeStatus) DeepCopyInto(out *QuotaNodeStatus) {
+	*out = *in
+	if in.Used != nil {
+		in, out := &in.Used, &out.Used
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.Borrowed != nil {
+		in, out := &in.Borrowed, &out.Borrowed
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	in.Time.DeepCopyInto(&out.Time)
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuotaNodeStatus.
+func (in *QuotaNodeStatus) DeepCopy() *QuotaNodeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(QuotaNodeStatus)
 	in.DeepCopyInto(out)
 	return out
 }
@@ -382,15 +625,15 @@ func (in *RequeuingSpec) DeepCopy() *RequeuingSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SchedulingSpec) DeepCopyInto(out *SchedulingSpec) {
 	*out = *in
-	if in.NotImplemented_NodeSelector != nil {
-		in, out := &in.NotImplemented_NodeSelector, &out.NotImplemented_NodeSelector
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
 		*out = make(map[string]string, len(*in))
 		for key, val := range *in {
 			(*out)[key] = val
 		}
 	}
 	out.Requeuing = in.Requeuing
-	out.NotImplemented_DispatchDuration = in.NotImplemented_DispatchDuration
+	out.DispatchDuration = in.DispatchDuration
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SchedulingSpec.
@@ -402,3 +645,54 @@ func (in *SchedulingSpec) DeepCopy() *SchedulingSpec {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TaintedCapacityInfo) DeepCopyInto(out *TaintedCapacityInfo) {
+	*out = *in
+	if in.Taints != nil {
+		in, out := &in.Taints, &out.Taints
+		*out = make([]corev1.Taint, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Capacity != nil {
+		in, out := &in.Capacity, &out.Capacity
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TaintedCapacityInfo.
+func (in *TaintedCapacityInfo) DeepCopy() *TaintedCapacityInfo {
+	if in == nil {
+		return nil
+	}
+	out := new(TaintedCapacityInfo)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TopologyDomainInfo) DeepCopyInto(out *TopologyDomainInfo) {
+	*out = *in
+	if in.Capacity != nil {
+		in, out := &in.Capacity, &out.Capacity
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TopologyDomainInfo.
+func (in *TopologyDomainInfo) DeepCopy() *TopologyDomainInfo {
+	if in == nil {
+		return nil
+	}
+	out := new(TopologyDomainInfo)
+	in.DeepCopyInto(out)
+	return out
+}
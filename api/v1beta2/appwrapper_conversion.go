@@ -0,0 +1,253 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	"encoding/json"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+// Annotation preserving the v1beta1 fields without v1beta2 counterpart so that conversions round trip
+const v1beta1FieldsAnnotation = "workload.codeflare.dev/v1beta1-fields"
+
+// Fields of a v1beta1 AppWrapper without v1beta2 counterpart
+type v1beta1Fields struct {
+	Selector      *metav1.LabelSelector `json:"selector,omitempty"`
+	NumRequeuings int32                 `json:"numRequeuings,omitempty"`
+	Items         []v1beta1ItemFields   `json:"items,omitempty"`
+}
+
+// Fields of a v1beta1 generic item without v1beta2 counterpart
+type v1beta1ItemFields struct {
+	// Replicas if different from the total number of replicas of the custom pod resources
+	Replicas *int32 `json:"replicas,omitempty"`
+}
+
+// Copy annotations omitting the v1beta1 fields annotation
+func copyAnnotations(annotations map[string]string) map[string]string {
+	var copied map[string]string
+	for k, v := range annotations {
+		if k == v1beta1FieldsAnnotation {
+			continue
+		}
+		if copied == nil {
+			copied = map[string]string{}
+		}
+		copied[k] = v
+	}
+	return copied
+}
+
+// ConvertTo converts this AppWrapper to the hub version
+// The expected number of pods of each generic item is the total number of replicas of its pod sets
+// unless preserved in the v1beta1 fields annotation like the other v1beta1 fields without v1beta2 counterpart
+func (src *AppWrapper) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.AppWrapper)
+	dst.ObjectMeta = src.ObjectMeta
+	dst.Annotations = copyAnnotations(src.Annotations)
+	fields := v1beta1Fields{}
+	if annotation, ok := src.Annotations[v1beta1FieldsAnnotation]; ok {
+		if err := json.Unmarshal([]byte(annotation), &fields); err != nil {
+			return err
+		}
+	}
+	dst.Spec.NotImplemented_Selector = fields.Selector
+
+	dst.Spec.Priority = src.Spec.Priority
+	dst.Spec.PrioritySlope = src.Spec.PrioritySlope
+	dst.Spec.Service = nil
+	if src.Spec.Service != nil {
+		dst.Spec.Service = &v1beta1.AppWrapperService{Spec: src.Spec.Service.Spec}
+	}
+	dst.Spec.Resources.GenericItems = nil
+	for i, component := range src.Spec.Components {
+		item := v1beta1.GenericItem{
+			MinAvailable:     component.MinAvailable,
+			Priority:         component.Priority,
+			PrioritySlope:    component.PrioritySlope,
+			GenericTemplate:  component.Template,
+			CompletionStatus: strings.Join(component.CompletionStatus, ","),
+		}
+		for _, podSet := range component.PodSets {
+			item.Replicas += podSet.Replicas
+			item.CustomPodResources = append(item.CustomPodResources, v1beta1.CustomPodResource{
+				Replicas: podSet.Replicas,
+				Requests: podSet.Requests,
+				Limits:   podSet.Limits,
+			})
+		}
+		if i < len(fields.Items) && fields.Items[i].Replicas != nil {
+			item.Replicas = *fields.Items[i].Replicas
+		}
+		dst.Spec.Resources.GenericItems = append(dst.Spec.Resources.GenericItems, item)
+	}
+	dst.Spec.Scheduling = v1beta1.SchedulingSpec{
+		NodeSelector: src.Spec.Scheduling.NodeSelector,
+		TopologyKey:  src.Spec.Scheduling.TopologyKey,
+		MinAvailable: src.Spec.Scheduling.MinAvailable,
+		Requeuing: v1beta1.RequeuingSpec{
+			InitialTimeInSeconds:         src.Spec.Scheduling.Requeuing.InitialTimeInSeconds,
+			TimeInSeconds:                src.Spec.Scheduling.Requeuing.TimeInSeconds,
+			MaxTimeInSeconds:             src.Spec.Scheduling.Requeuing.MaxTimeInSeconds,
+			GrowthType:                   src.Spec.Scheduling.Requeuing.GrowthType,
+			NotImplemented_NumRequeuings: fields.NumRequeuings,
+			MaxNumRequeuings:             src.Spec.Scheduling.Requeuing.MaxNumRequeuings,
+			ForceDeletionTimeInSeconds:   src.Spec.Scheduling.Requeuing.ForceDeletionTimeInSeconds,
+			PauseTimeInSeconds:           src.Spec.Scheduling.Requeuing.PauseTimeInSeconds,
+		},
+		DispatchDuration: v1beta1.DispatchDurationSpec{
+			Expected: src.Spec.Scheduling.DispatchDuration.Expected,
+			Limit:    src.Spec.Scheduling.DispatchDuration.Limit,
+			Overrun:  src.Spec.Scheduling.DispatchDuration.Overrun,
+		},
+		PreemptionGracePeriodInSeconds: src.Spec.Scheduling.PreemptionGracePeriodInSeconds,
+	}
+
+	dst.Status = v1beta1.AppWrapperStatus{
+		State:                  v1beta1.AppWrapperState(src.Status.State),
+		Step:                   v1beta1.AppWrapperStep(src.Status.Step),
+		DispatchTimestamp:      src.Status.DispatchTimestamp,
+		RequeueTimestamp:       src.Status.RequeueTimestamp,
+		Restarts:               src.Status.Restarts,
+		EffectivePriority:      src.Status.EffectivePriority,
+		RequeuingTimeInSeconds: src.Status.RequeuingTimeInSeconds,
+		Allocated:              src.Status.Allocated,
+		TransitionCount:        src.Status.TransitionCount,
+		Conditions:             src.Status.Conditions,
+	}
+	for _, transition := range src.Status.Transitions {
+		dst.Status.Transitions = append(dst.Status.Transitions, v1beta1.AppWrapperTransition{
+			Time:       transition.Time,
+			Reason:     transition.Reason,
+			Controller: transition.Controller,
+			State:      v1beta1.AppWrapperState(transition.State),
+			Step:       v1beta1.AppWrapperStep(transition.Step),
+		})
+	}
+	return nil
+}
+
+// ConvertFrom converts from the hub version to this version
+// Fields not implemented in the hub version and the expected number of pods of each generic item
+// are preserved in the v1beta1 fields annotation if set
+func (dst *AppWrapper) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1beta1.AppWrapper)
+	dst.ObjectMeta = src.ObjectMeta
+	dst.Annotations = copyAnnotations(src.Annotations)
+	fields := v1beta1Fields{
+		Selector:      src.Spec.NotImplemented_Selector,
+		NumRequeuings: src.Spec.Scheduling.Requeuing.NotImplemented_NumRequeuings,
+	}
+	preserve := fields.Selector != nil || fields.NumRequeuings != 0
+
+	dst.Spec.Priority = src.Spec.Priority
+	dst.Spec.PrioritySlope = src.Spec.PrioritySlope
+	dst.Spec.Service = nil
+	if src.Spec.Service != nil {
+		dst.Spec.Service = &AppWrapperService{Spec: src.Spec.Service.Spec}
+	}
+	dst.Spec.Components = []AppWrapperComponent{}
+	for _, item := range src.Spec.Resources.GenericItems {
+		component := AppWrapperComponent{
+			Template:      item.GenericTemplate,
+			MinAvailable:  item.MinAvailable,
+			Priority:      item.Priority,
+			PrioritySlope: item.PrioritySlope,
+		}
+		if item.CompletionStatus != "" {
+			component.CompletionStatus = strings.Split(item.CompletionStatus, ",")
+		}
+		itemFields := v1beta1ItemFields{}
+		var replicas int32
+		for _, cpr := range item.CustomPodResources {
+			replicas += cpr.Replicas
+			component.PodSets = append(component.PodSets, PodSet{
+				Replicas: cpr.Replicas,
+				Requests: cpr.Requests,
+				Limits:   cpr.Limits,
+			})
+		}
+		if item.Replicas != replicas {
+			itemReplicas := item.Replicas
+			itemFields.Replicas = &itemReplicas
+		}
+		if itemFields.Replicas != nil {
+			preserve = true
+		}
+		fields.Items = append(fields.Items, itemFields)
+		dst.Spec.Components = append(dst.Spec.Components, component)
+	}
+	dst.Spec.Scheduling = SchedulingSpec{
+		NodeSelector: src.Spec.Scheduling.NodeSelector,
+		TopologyKey:  src.Spec.Scheduling.TopologyKey,
+		MinAvailable: src.Spec.Scheduling.MinAvailable,
+		Requeuing: RequeuingSpec{
+			InitialTimeInSeconds:       src.Spec.Scheduling.Requeuing.InitialTimeInSeconds,
+			TimeInSeconds:              src.Spec.Scheduling.Requeuing.TimeInSeconds,
+			MaxTimeInSeconds:           src.Spec.Scheduling.Requeuing.MaxTimeInSeconds,
+			GrowthType:                 src.Spec.Scheduling.Requeuing.GrowthType,
+			MaxNumRequeuings:           src.Spec.Scheduling.Requeuing.MaxNumRequeuings,
+			ForceDeletionTimeInSeconds: src.Spec.Scheduling.Requeuing.ForceDeletionTimeInSeconds,
+			PauseTimeInSeconds:         src.Spec.Scheduling.Requeuing.PauseTimeInSeconds,
+		},
+		DispatchDuration: DispatchDurationSpec{
+			Expected: src.Spec.Scheduling.DispatchDuration.Expected,
+			Limit:    src.Spec.Scheduling.DispatchDuration.Limit,
+			Overrun:  src.Spec.Scheduling.DispatchDuration.Overrun,
+		},
+		PreemptionGracePeriodInSeconds: src.Spec.Scheduling.PreemptionGracePeriodInSeconds,
+	}
+
+	dst.Status = AppWrapperStatus{
+		State:                  AppWrapperState(src.Status.State),
+		Step:                   AppWrapperStep(src.Status.Step),
+		DispatchTimestamp:      src.Status.DispatchTimestamp,
+		RequeueTimestamp:       src.Status.RequeueTimestamp,
+		Restarts:               src.Status.Restarts,
+		EffectivePriority:      src.Status.EffectivePriority,
+		RequeuingTimeInSeconds: src.Status.RequeuingTimeInSeconds,
+		Allocated:              src.Status.Allocated,
+		TransitionCount:        src.Status.TransitionCount,
+		Conditions:             src.Status.Conditions,
+	}
+	for _, transition := range src.Status.Transitions {
+		dst.Status.Transitions = append(dst.Status.Transitions, AppWrapperTransition{
+			Time:       transition.Time,
+			Reason:     transition.Reason,
+			Controller: transition.Controller,
+			State:      AppWrapperState(transition.State),
+			Step:       AppWrapperStep(transition.Step),
+		})
+	}
+
+	if preserve {
+		annotation, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		if dst.Annotations == nil {
+			dst.Annotations = map[string]string{}
+		}
+		dst.Annotations[v1beta1FieldsAnnotation] = string(annotation)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+const conversionTestTemplate = `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "pod"},
+	"spec": {"containers": [{"name": "busybox", "image": "busybox"}]}}`
+
+// Build a v1beta1 AppWrapper using the fields shared with v1beta2
+func conversionTestHub() *v1beta1.AppWrapper {
+	minAvailable := int32(1)
+	priority := int32(3)
+	slope := resource.MustParse("0.5")
+	return &v1beta1.AppWrapper{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "aw",
+			Labels:      map[string]string{"team": "a"},
+			Annotations: map[string]string{"note": "kept"},
+		},
+		Spec: v1beta1.AppWrapperSpec{
+			Priority:      5,
+			PrioritySlope: resource.MustParse("1"),
+			Service:       &v1beta1.AppWrapperService{Spec: v1.ServiceSpec{Type: v1.ServiceTypeClusterIP}},
+			Resources: v1beta1.AppWrapperResources{
+				GenericItems: []v1beta1.GenericItem{{
+					Replicas:         3,
+					MinAvailable:     &minAvailable,
+					Priority:         &priority,
+					PrioritySlope:    &slope,
+					GenericTemplate:  runtime.RawExtension{Raw: []byte(conversionTestTemplate)},
+					CompletionStatus: "Complete,Succeeded",
+					CustomPodResources: []v1beta1.CustomPodResource{
+						{Replicas: 1, Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+						{Replicas: 2, Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")},
+							Limits: v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Gi")}},
+					},
+				}},
+			},
+			Scheduling: v1beta1.SchedulingSpec{
+				NodeSelector: map[string]string{"zone": "a"},
+				TopologyKey:  "kubernetes.io/hostname",
+				MinAvailable: 3,
+				Requeuing: v1beta1.RequeuingSpec{
+					InitialTimeInSeconds:       270,
+					TimeInSeconds:              300,
+					MaxTimeInSeconds:           600,
+					GrowthType:                 "linear",
+					MaxNumRequeuings:           4,
+					ForceDeletionTimeInSeconds: 570,
+					PauseTimeInSeconds:         90,
+				},
+				DispatchDuration:               v1beta1.DispatchDurationSpec{Expected: 60, Limit: 120, Overrun: true},
+				PreemptionGracePeriodInSeconds: 30,
+			},
+		},
+		Status: v1beta1.AppWrapperStatus{
+			State:           v1beta1.Running,
+			Step:            v1beta1.Created,
+			Restarts:        2,
+			Allocated:       []int32{3},
+			TransitionCount: 1,
+			Transitions: []v1beta1.AppWrapperTransition{
+				{Reason: "dispatched", Controller: "Dispatcher", State: v1beta1.Running, Step: v1beta1.Dispatching},
+			},
+		},
+	}
+}
+
+// Convert a v1beta1 AppWrapper to v1beta2 and back
+func roundTripHub(t *testing.T, hub *v1beta1.AppWrapper) (*AppWrapper, *v1beta1.AppWrapper) {
+	spoke := &AppWrapper{}
+	if err := spoke.ConvertFrom(hub.DeepCopy()); err != nil {
+		t.Fatalf("ConvertFrom() = %v", err)
+	}
+	result := &v1beta1.AppWrapper{}
+	if err := spoke.DeepCopy().ConvertTo(result); err != nil {
+		t.Fatalf("ConvertTo() = %v", err)
+	}
+	return spoke, result
+}
+
+func TestConvertHubRoundTrip(t *testing.T) {
+	hub := conversionTestHub()
+	spoke, result := roundTripHub(t, hub)
+	if !equality.Semantic.DeepEqual(hub, result) {
+		t.Errorf("round trip changed AppWrapper:\n got %+v\nwant %+v", result, hub)
+	}
+	if _, ok := spoke.Annotations[v1beta1FieldsAnnotation]; ok {
+		t.Errorf("annotation %s set without v1beta1 specific fields", v1beta1FieldsAnnotation)
+	}
+	if len(spoke.Spec.Components) != 1 || len(spoke.Spec.Components[0].PodSets) != 2 {
+		t.Fatalf("components = %+v, want one component with two pod sets", spoke.Spec.Components)
+	}
+	if status := spoke.Spec.Components[0].CompletionStatus; len(status) != 2 || status[0] != "Complete" || status[1] != "Succeeded" {
+		t.Errorf("completion status = %v, want [Complete Succeeded]", status)
+	}
+}
+
+func TestConvertHubRoundTripPreservesV1beta1Fields(t *testing.T) {
+	hub := conversionTestHub()
+	hub.Spec.NotImplemented_Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "aw"}}
+	hub.Spec.Scheduling.Requeuing.NotImplemented_NumRequeuings = 2
+	hub.Spec.Resources.GenericItems[0].Replicas = 5 // differs from the total replicas of the custom pod resources
+	hub.Spec.Resources.GenericItems = append(hub.Spec.Resources.GenericItems, v1beta1.GenericItem{
+		GenericTemplate: runtime.RawExtension{Raw: []byte(`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "config"}}`)},
+	})
+
+	spoke, result := roundTripHub(t, hub)
+	if !equality.Semantic.DeepEqual(hub, result) {
+		t.Errorf("round trip changed AppWrapper:\n got %+v\nwant %+v", result, hub)
+	}
+	if _, ok := spoke.Annotations[v1beta1FieldsAnnotation]; !ok {
+		t.Errorf("annotation %s not set", v1beta1FieldsAnnotation)
+	}
+	if spoke.Annotations["note"] != "kept" {
+		t.Errorf("annotations = %v, want other annotations kept", spoke.Annotations)
+	}
+	if err := (&AppWrapper{}).ConvertFrom(hub); err != nil {
+		t.Fatalf("ConvertFrom() = %v", err)
+	}
+	if _, ok := hub.Annotations[v1beta1FieldsAnnotation]; ok {
+		t.Error("ConvertFrom() modified the annotations of the source AppWrapper")
+	}
+}
+
+func TestConvertSpokeRoundTrip(t *testing.T) {
+	spoke := &AppWrapper{}
+	if err := spoke.ConvertFrom(conversionTestHub()); err != nil {
+		t.Fatalf("ConvertFrom() = %v", err)
+	}
+	hub := &v1beta1.AppWrapper{}
+	if err := spoke.DeepCopy().ConvertTo(hub); err != nil {
+		t.Fatalf("ConvertTo() = %v", err)
+	}
+	if replicas := hub.Spec.Resources.GenericItems[0].Replicas; replicas != 3 {
+		t.Errorf("replicas = %d, want total replicas of the pod sets", replicas)
+	}
+	result := &AppWrapper{}
+	if err := result.ConvertFrom(hub); err != nil {
+		t.Fatalf("ConvertFrom() = %v", err)
+	}
+	if !equality.Semantic.DeepEqual(spoke, result) {
+		t.Errorf("round trip changed AppWrapper:\n got %+v\nwant %+v", result, spoke)
+	}
+}
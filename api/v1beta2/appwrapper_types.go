@@ -0,0 +1,239 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// AppWrapperSpec defines the desired state of AppWrapper
+type AppWrapperSpec struct {
+	// Priority
+	Priority int32 `json:"priority,omitempty"`
+
+	// Priority slope, i.e., increase of the effective priority per second spent queued
+	PrioritySlope resource.Quantity `json:"prioritySlope,omitempty"`
+
+	// Service to create for the wrapped pods
+	Service *AppWrapperService `json:"service,omitempty"`
+
+	// Wrapped resources
+	Components []AppWrapperComponent `json:"components"`
+
+	// Scheduling specifies the parameters used for scheduling the wrapped resources.
+	Scheduling SchedulingSpec `json:"scheduling,omitempty"`
+}
+
+// AppWrapperService specifies a Service named after the AppWrapper and selecting the wrapped pods
+type AppWrapperService struct {
+	// Service spec, selector is extended to select the wrapped pods
+	Spec v1.ServiceSpec `json:"spec"`
+}
+
+// AppWrapper component, i.e., a wrapped resource
+type AppWrapperComponent struct {
+	// The template for the resource
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:EmbeddedResource
+	Template runtime.RawExtension `json:"template"`
+
+	// Sets of identical pods created by this component
+	PodSets []PodSet `json:"podSets,omitempty"`
+
+	// Min number of running or succeeded pods created by this component for the AppWrapper to be healthy
+	// Not checked while some pods of the AppWrapper are not labeled with their component, e.g., pods created before upgrading MCAD
+	MinAvailable *int32 `json:"minAvailable,omitempty"`
+
+	// Priority of this component, defaults to the AppWrapper priority
+	Priority *int32 `json:"priority,omitempty"`
+
+	// Priority slope of this component, defaults to the AppWrapper priority slope
+	PrioritySlope *resource.Quantity `json:"prioritySlope,omitempty"`
+
+	// Keywords to match against condition types of the resource to detect completion
+	CompletionStatus []string `json:"completionStatus,omitempty"`
+}
+
+// Set of identical pods
+type PodSet struct {
+	// Number of pods
+	// +kubebuilder:validation:Minimum=0
+	Replicas int32 `json:"replicas"`
+
+	// Resource requests per pod
+	Requests v1.ResourceList `json:"requests,omitempty"`
+
+	// Resource limits per pod
+	Limits v1.ResourceList `json:"limits,omitempty"`
+}
+
+type SchedulingSpec struct {
+	// Only dispatch if the resource requests fit on nodes matching these labels
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Only dispatch if all the pods fit on schedulable nodes sharing the same value of this node label, e.g., a rack or zone
+	TopologyKey string `json:"topologyKey,omitempty"`
+
+	// Minimum number of expected running and successful pods.
+	// Set to -1 to disable pod monitoring, cleanup on failure, and termination detection based on pod counts.
+	// Set to 0 to enable pod monitoring (at least 1 pod), cleanup on failure, and disable termination detection.
+	// Set to n>=1 to enable pod monitoring (at least n pods), cleanup on failure, and termination detection.
+	MinAvailable int32 `json:"minAvailable,omitempty"`
+
+	// Requeuing specification
+	Requeuing RequeuingSpec `json:"requeuing,omitempty"`
+
+	// Dispatch duration specification
+	DispatchDuration DispatchDurationSpec `json:"dispatchDuration,omitempty"`
+
+	// Min time in seconds from dispatch before the AppWrapper may be preempted if preemption is enabled
+	PreemptionGracePeriodInSeconds int64 `json:"preemptionGracePeriodInSeconds,omitempty"`
+}
+
+type DispatchDurationSpec struct {
+	// Expected time in seconds from dispatch to completion, used by the dispatcher as an estimate
+	Expected int32 `json:"expected,omitempty"`
+
+	// Max time in seconds from dispatch to completion (unbounded if zero)
+	Limit int32 `json:"limit,omitempty"`
+
+	// Requeue instead of failing the AppWrapper when exceeding the limit
+	Overrun bool `json:"overrun,omitempty"`
+}
+
+type RequeuingSpec struct {
+	// Initial waiting time before requeuing conditions are checked, overrides timeInSeconds if greater than zero
+	InitialTimeInSeconds int64 `json:"initialTimeInSeconds,omitempty"`
+
+	// Initial waiting time before requeuing conditions are checked
+	// +kubebuilder:default=270
+	TimeInSeconds int64 `json:"timeInSeconds,omitempty"`
+
+	// Max waiting time before requeuing conditions are checked (unbounded if zero)
+	// +kubebuilder:default=0
+	MaxTimeInSeconds int64 `json:"maxTimeInSeconds,omitempty"`
+
+	// Growth of the waiting time with the number of restarts
+	// +kubebuilder:default=exponential
+	// +kubebuilder:validation:Enum=exponential;linear;none
+	GrowthType string `json:"growthType,omitempty"`
+
+	// Max requeuings permitted (infinite if zero)
+	// +kubebuilder:default=0
+	MaxNumRequeuings int32 `json:"maxNumRequeuings,omitempty"`
+
+	// Enable forced deletion after delay if greater than zero
+	// +kubebuilder:default=570
+	ForceDeletionTimeInSeconds int64 `json:"forceDeletionTimeInSeconds,omitempty"`
+
+	// Waiting time before trying to dispatch again after requeuing
+	// +kubebuilder:default=90
+	PauseTimeInSeconds int64 `json:"pauseTimeInSeconds,omitempty"`
+}
+
+// AppWrapperStatus defines the observed state of AppWrapper
+type AppWrapperStatus struct {
+	// State
+	State AppWrapperState `json:"state,omitempty"`
+
+	// Status of wrapped resources
+	Step AppWrapperStep `json:"step,omitempty"`
+
+	// When last dispatched
+	DispatchTimestamp metav1.Time `json:"dispatchTimestamp,omitempty"`
+
+	// When last requeued
+	RequeueTimestamp metav1.Time `json:"requeueTimestamp,omitempty"`
+
+	// How many times restarted
+	Restarts int32 `json:"restarts"`
+
+	// Effective priority, i.e., priority plus priority slope times time spent queued
+	EffectivePriority int32 `json:"effectivePriority,omitempty"`
+
+	// Waiting time before requeuing conditions are checked for the current dispatch
+	RequeuingTimeInSeconds int64 `json:"requeuingTimeInSeconds,omitempty"`
+
+	// Number of pods of each component placed on nodes when the AppWrapper was dispatched, reset when idle
+	// Pods declared by the pod sets if per-node capacity is unknown
+	Allocated []int32 `json:"allocated,omitempty"`
+
+	// Transition log
+	Transitions []AppWrapperTransition `json:"transitions,omitempty"`
+
+	// Number of transitions
+	TransitionCount int32 `json:"transitionCount,omitempty"`
+
+	// Conditions
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// AppWrapperState is the label for the AppWrapper status
+type AppWrapperState string
+
+// AppWrapperState is the status of wrapped resources
+type AppWrapperStep string
+
+// State transition
+type AppWrapperTransition struct {
+	// Timestamp
+	Time metav1.Time `json:"time"`
+
+	// Reason
+	Reason string `json:"reason,omitempty"`
+
+	// Controller that made the transition
+	Controller string `json:"controller,omitempty"`
+
+	// State entered
+	State AppWrapperState `json:"state"`
+
+	// Status of wrapped resources
+	Step AppWrapperStep `json:"step,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:unservedversion
+//+kubebuilder:printcolumn:name="Status",type="string",JSONPath=`.status.state`
+//+kubebuilder:printcolumn:name="Restarts",type="integer",JSONPath=`.status.restarts`
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// AppWrapper is the Schema for the appwrappers API
+type AppWrapper struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AppWrapperSpec   `json:"spec,omitempty"`
+	Status AppWrapperStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// AppWrapperList contains a list of AppWrapper
+type AppWrapperList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AppWrapper `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&AppWrapper{}, &AppWrapperList{})
+}
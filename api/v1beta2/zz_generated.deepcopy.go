@@ -0,0 +1,309 @@
+//go:build !ignore_autogenerated
+
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package v1beta2
+
+import (
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppWrapper) DeepCopyInto(out *AppWrapper) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppWrapper.
+func (in *AppWrapper) DeepCopy() *AppWrapper {
+	if in == nil {
+		return nil
+	}
+	out := new(AppWrapper)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AppWrapper) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppWrapperComponent) DeepCopyInto(out *AppWrapperComponent) {
+	*out = *in
+	in.Template.DeepCopyInto(&out.Template)
+	if in.PodSets != nil {
+		in, out := &in.PodSets, &out.PodSets
+		*out = make([]PodSet, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.MinAvailable != nil {
+		in, out := &in.MinAvailable, &out.MinAvailable
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Priority != nil {
+		in, out := &in.Priority, &out.Priority
+		*out = new(int32)
+		**out = **in
+	}
+	if in.PrioritySlope != nil {
+		in, out := &in.PrioritySlope, &out.PrioritySlope
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.CompletionStatus != nil {
+		in, out := &in.CompletionStatus, &out.CompletionStatus
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppWrapperComponent.
+func (in *AppWrapperComponent) DeepCopy() *AppWrapperComponent {
+	if in == nil {
+		return nil
+	}
+	out := new(AppWrapperComponent)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppWrapperList) DeepCopyInto(out *AppWrapperList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]AppWrapper, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppWrapperList.
+func (in *AppWrapperList) DeepCopy() *AppWrapperList {
+	if in == nil {
+		return nil
+	}
+	out := new(AppWrapperList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AppWrapperList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppWrapperService) DeepCopyInto(out *AppWrapperService) {
+	*out = *in
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppWrapperService.
+func (in *AppWrapperService) DeepCopy() *AppWrapperService {
+	if in == nil {
+		return nil
+	}
+	out := new(AppWrapperService)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppWrapperSpec) DeepCopyInto(out *AppWrapperSpec) {
+	*out = *in
+	out.PrioritySlope = in.PrioritySlope.DeepCopy()
+	if in.Service != nil {
+		in, out := &in.Service, &out.Service
+		*out = new(AppWrapperService)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Components != nil {
+		in, out := &in.Components, &out.Components
+		*out = make([]AppWrapperComponent, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	in.Scheduling.DeepCopyInto(&out.Scheduling)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppWrapperSpec.
+func (in *AppWrapperSpec) DeepCopy() *AppWrapperSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AppWrapperSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppWrapperStatus) DeepCopyInto(out *AppWrapperStatus) {
+	*out = *in
+	in.DispatchTimestamp.DeepCopyInto(&out.DispatchTimestamp)
+	in.RequeueTimestamp.DeepCopyInto(&out.RequeueTimestamp)
+	if in.Allocated != nil {
+		in, out := &in.Allocated, &out.Allocated
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+	if in.Transitions != nil {
+		in, out := &in.Transitions, &out.Transitions
+		*out = make([]AppWrapperTransition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppWrapperStatus.
+func (in *AppWrapperStatus) DeepCopy() *AppWrapperStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(AppWrapperStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppWrapperTransition) DeepCopyInto(out *AppWrapperTransition) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppWrapperTransition.
+func (in *AppWrapperTransition) DeepCopy() *AppWrapperTransition {
+	if in == nil {
+		return nil
+	}
+	out := new(AppWrapperTransition)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DispatchDurationSpec) DeepCopyInto(out *DispatchDurationSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DispatchDurationSpec.
+func (in *DispatchDurationSpec) DeepCopy() *DispatchDurationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DispatchDurationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodSet) DeepCopyInto(out *PodSet) {
+	*out = *in
+	if in.Requests != nil {
+		in, out := &in.Requests, &out.Requests
+		*out = make(v1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.Limits != nil {
+		in, out := &in.Limits, &out.Limits
+		*out = make(v1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodSet.
+func (in *PodSet) DeepCopy() *PodSet {
+	if in == nil {
+		return nil
+	}
+	out := new(PodSet)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RequeuingSpec) DeepCopyInto(out *RequeuingSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RequeuingSpec.
+func (in *RequeuingSpec) DeepCopy() *RequeuingSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RequeuingSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SchedulingSpec) DeepCopyInto(out *SchedulingSpec) {
+	*out = *in
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	out.Requeuing = in.Requeuing
+	out.DispatchDuration = in.DispatchDuration
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SchedulingSpec.
+func (in *SchedulingSpec) DeepCopy() *SchedulingSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SchedulingSpec)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -20,6 +20,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -27,6 +29,7 @@ import (
 
 	ksv1alpha1 "github.com/kubestellar/kubestellar/api/control/v1alpha1"
 	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+	mcadv1beta2 "github.com/project-codeflare/mcad/api/v1beta2"
 	"github.com/project-codeflare/mcad/internal/controller"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -53,9 +56,13 @@ const (
 	RunnerMode     = "runner"
 )
 
+// Node label keys commonly used in node selectors
+const defaultNodeLabelKeys = "kubernetes.io/arch,node.kubernetes.io/instance-type,topology.kubernetes.io/region,topology.kubernetes.io/zone,nvidia.com/gpu.product"
+
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(mcadv1beta1.AddToScheme(scheme))
+	utilruntime.Must(mcadv1beta2.AddToScheme(scheme))
 	utilruntime.Must(ksv1alpha1.AddToScheme(scheme))
 
 	//+kubebuilder:scaffold:scheme
@@ -67,6 +74,16 @@ func main() {
 	var probeAddr string
 	var multicluster bool
 	var mode string
+	var preemption bool
+	var backfill bool
+	var queueOrder string
+	var fairShareHalfLife time.Duration
+	var enableWebhooks bool
+	var strictDemand bool
+	var importLegacy bool
+	var podGroup string
+	var topologyKeys string
+	var nodeLabelKeys string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
@@ -75,6 +92,22 @@ func main() {
 	flag.StringVar(&mode, "mode", UnifiedMode,
 		"One of "+UnifiedMode+", "+DispatcherMode+" or "+RunnerMode+".")
 	flag.BoolVar(&multicluster, "multicluster", false, "Enable multi-cluster operation")
+	flag.BoolVar(&preemption, "preemption", false, "Enable preemption of lower-priority AppWrappers (single-cluster operation only)")
+	flag.BoolVar(&backfill, "backfill", false, "Enable backfill scheduling with reservations for blocked AppWrappers")
+	flag.StringVar(&queueOrder, "queue-order", controller.FIFOQueueOrder,
+		"Order of queued AppWrappers with the same priority, one of "+controller.FIFOQueueOrder+", "+controller.SJFQueueOrder+", "+
+			controller.SmallestGPUQueueOrder+", or "+controller.FairShareQueueOrder+".")
+	flag.DurationVar(&fairShareHalfLife, "fair-share-half-life", time.Hour,
+		"Half-life of the usage history of each namespace used by the "+controller.FairShareQueueOrder+" queue order (current usage only if zero).")
+	flag.BoolVar(&strictDemand, "strict-demand", false, "Use the max of the declared requests and the requests of the pod templates of AppWrappers")
+	flag.StringVar(&podGroup, "pod-group", "",
+		"Create a PodGroup for each AppWrapper using the given API group, one of "+controller.PodGroupXK8s+" or "+controller.PodGroupSigsK8s+" (disabled if empty).")
+	flag.StringVar(&topologyKeys, "topology-keys", "",
+		"Comma-separated list of node label keys to group capacity by in the cluster info status, e.g., topology.kubernetes.io/zone")
+	flag.StringVar(&nodeLabelKeys, "node-label-keys", defaultNodeLabelKeys,
+		"Comma-separated list of node label keys to report for each node in the cluster info status and check node selectors against")
+	flag.BoolVar(&importLegacy, "import-legacy", false, "Import legacy mcad.ibm.com/v1beta1 AppWrappers (requires the legacy AppWrapper CRD)")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", false, "Enable the AppWrapper admission webhooks (requires a serving certificate)")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -89,6 +122,22 @@ func main() {
 		os.Exit(1)
 	}
 
+	if podGroup != "" && podGroup != controller.PodGroupXK8s && podGroup != controller.PodGroupSigsK8s {
+		setupLog.Error(nil, fmt.Sprintf("invalid pod group API group: %v", podGroup))
+		os.Exit(1)
+	}
+
+	queueOrderPolicy, err := controller.NewQueueOrderPolicy(queueOrder)
+	if err != nil {
+		setupLog.Error(err, "invalid queue order")
+		os.Exit(1)
+	}
+
+	var fairShare *controller.FairShareTracker // only needed to order queued AppWrappers by fair share
+	if queueOrder == controller.FairShareQueueOrder && fairShareHalfLife > 0 {
+		fairShare = controller.NewFairShareTracker(fairShareHalfLife)
+	}
+
 	var leaderElectionID string
 	if mode == UnifiedMode || mode == DispatcherMode {
 		leaderElectionID = "f933c2fb.codeflare.dev"
@@ -128,8 +177,13 @@ func main() {
 				MultiClusterMode: multicluster,
 				ControllerName:   "Dispatcher",
 			},
-			Decisions: map[types.UID]*controller.QueuingDecision{}, // cache of recent queuing decisions
-			Events:    make(chan event.GenericEvent, 1),            // channel to trigger dispatch,
+			Decisions:    map[types.UID]*controller.QueuingDecision{}, // cache of recent queuing decisions
+			Events:       make(chan event.GenericEvent, 1),            // channel to trigger dispatch,
+			Preemption:   preemption,
+			Backfill:     backfill,
+			QueueOrder:   queueOrderPolicy,
+			FairShare:    fairShare,
+			StrictDemand: strictDemand,
 		}).SetupWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create controller", "controller", "Dispatcher")
 			os.Exit(1)
@@ -144,6 +198,7 @@ func main() {
 				Cache:            map[types.UID]*controller.CachedAppWrapper{}, // AppWrapper cache
 				MultiClusterMode: multicluster,
 				ControllerName:   "Runner",
+				PodGroupAPIGroup: podGroup,
 			},
 		}).SetupWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create controller", "controller", "Runner")
@@ -151,10 +206,29 @@ func main() {
 		}
 
 		if err = (&controller.ClusterInfoReconciler{
+			Client:        mgr.GetClient(),
+			Scheme:        mgr.GetScheme(),
+			TopologyKeys:  splitLabelKeys(topologyKeys),
+			NodeLabelKeys: splitLabelKeys(nodeLabelKeys),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ClusterInfo")
+			os.Exit(1)
+		}
+	}
+
+	if importLegacy {
+		if err = (&controller.LegacyImporter{
 			Client: mgr.GetClient(),
 			Scheme: mgr.GetScheme(),
 		}).SetupWithManager(mgr); err != nil {
-			setupLog.Error(err, "unable to create controller", "controller", "ClusterInfo")
+			setupLog.Error(err, "unable to create controller", "controller", "LegacyImporter")
+			os.Exit(1)
+		}
+	}
+
+	if enableWebhooks {
+		if err = (&controller.AppWrapperWebhook{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "AppWrapper")
 			os.Exit(1)
 		}
 	}
@@ -175,3 +249,14 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// Split a comma-separated list of label keys dropping empty keys
+func splitLabelKeys(list string) []string {
+	keys := []string{}
+	for _, key := range strings.Split(list, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -373,6 +373,11 @@ function install_mcad {
     helm_args+=" --set configMap.quotaEnabled='"false"' --set coscheduler.rbac.apiGroup=scheduling.sigs.k8s.io"
     helm_args+=" --set coscheduler.rbac.resource=podgroups --set image.repository=$IMAGE_REPOSITORY_MCAD"
     helm_args+=" --set image.tag=$IMAGE_TAG_MCAD --set image.pullPolicy=$MCAD_IMAGE_PULL_POLICY"
+    if [[ -n "${MCAD_EXTRA_ARGS}" ]]
+    then
+      # comma-separated list of additional controller flags, e.g., --preemption,--backfill
+      helm_args+=" --set extraArgs={${MCAD_EXTRA_ARGS}}"
+    fi
 
     echo "helm upgrade $helm_args"
     helm upgrade $helm_args
@@ -35,6 +35,8 @@ import (
 //+kubebuilder:rbac:groups=workload.codeflare.dev,resources=appwrappers/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=workload.codeflare.dev,resources=appwrappers/finalizers,verbs=update
 //+kubebuilder:rbac:groups="",resources=resourcequotas,verbs=get;list;watch
+//+kubebuilder:rbac:groups=workload.codeflare.dev,resources=quotanodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=workload.codeflare.dev,resources=quotanodes/status,verbs=get;update;patch
 
 // AppWrapperReconciler is the super type of Dispatcher and Runner reconcilers
 type AppWrapperReconciler struct {
@@ -43,18 +45,25 @@ type AppWrapperReconciler struct {
 	Cache            map[types.UID]*CachedAppWrapper // cache AppWrapper updates for write/read consistency
 	MultiClusterMode bool                            // are we operating in multi-cluster mode
 	ControllerName   string                          // name of the controller
+	PodGroupAPIGroup string                          // API group of the PodGroups created for AppWrappers, disabled if empty
 }
 
 const (
 	nameLabel            = "appwrapper.mcad.ibm.com"                     // owner name label for wrapped resources
 	namespaceLabel       = "appwrapper.mcad.ibm.com/namespace"           // owner namespace label for wrapped resources
+	itemLabel            = "appwrapper.mcad.ibm.com/item"                // generic item index label for wrapped pods
 	assignedClusterLabel = "appwrapper.mcad.ibm.com/assignedCluster"     // cluster to which appwrapper has been assigned for execution
 	serializedStatusKey  = "appwrapper.mcad.ibm.com/serializedStatus"    // annotation key for serializing status from hub to spoke
 	legacyFinalizer      = "workload.codeflare.dev/finalizer"            // finalizer name used in 2.2.0 and earlier
 	dispatchFinalizer    = "workload.codeflare.dev/finalizer_dispatcher" // finalizer name for dispatcher
 	runnerFinalizer      = "workload.codeflare.dev/finalizer_runner"     // finalizer name for runner
 	nvidiaGpu            = "nvidia.com/gpu"                              // GPU resource name
-	specNodeName         = ".spec.nodeName"                              // key to index pods based on node placement
+)
+
+// API groups of the supported PodGroups
+const (
+	PodGroupXK8s    = "scheduling.x-k8s.io"    // scheduler-plugins PodGroups
+	PodGroupSigsK8s = "scheduling.sigs.k8s.io" // legacy scheduler-plugins PodGroups
 )
 
 // Structured logger
@@ -80,6 +89,9 @@ func (r *AppWrapperReconciler) updateStatus(ctx context.Context, appWrapper *mca
 	appWrapper.Status.TransitionCount++
 	appWrapper.Status.State = state
 	appWrapper.Status.Step = step
+	if step == mcadv1beta1.Idle {
+		appWrapper.Status.Allocated = nil // no resources held
+	}
 	// update AppWrapper status in etcd, requeue reconciliation on failure
 	if err := r.Status().Update(ctx, appWrapper); err != nil {
 		return ctrl.Result{}, err
@@ -0,0 +1,179 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+//+kubebuilder:webhook:path=/mutate-workload-codeflare-dev-v1beta1-appwrapper,mutating=true,failurePolicy=fail,sideEffects=None,groups=workload.codeflare.dev,resources=appwrappers,verbs=create,versions=v1beta1,name=mappwrapper.kb.io,admissionReviewVersions=v1
+//+kubebuilder:webhook:path=/validate-workload-codeflare-dev-v1beta1-appwrapper,mutating=false,failurePolicy=fail,sideEffects=None,groups=workload.codeflare.dev,resources=appwrappers,verbs=create;update,versions=v1beta1,name=vappwrapper.kb.io,admissionReviewVersions=v1
+
+// AppWrapperWebhook defaults and validates AppWrappers on admission
+type AppWrapperWebhook struct{}
+
+// Syntax of a completion status keyword
+var completionStatusKeyword = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9._-]*$`)
+
+// Register the webhook with the manager
+func (w *AppWrapperWebhook) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&mcadv1beta1.AppWrapper{}).
+		WithDefaulter(w).
+		WithValidator(w).
+		Complete()
+}
+
+// Infer missing custom pod resources of a new AppWrapper
+func (w *AppWrapperWebhook) Default(ctx context.Context, obj runtime.Object) error {
+	appWrapper, ok := obj.(*mcadv1beta1.AppWrapper)
+	if !ok {
+		return fmt.Errorf("expected an AppWrapper but got a %T", obj)
+	}
+	InferCustomPodResources(appWrapper)
+	return nil
+}
+
+// Validate a new AppWrapper
+func (w *AppWrapperWebhook) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	appWrapper, ok := obj.(*mcadv1beta1.AppWrapper)
+	if !ok {
+		return nil, fmt.Errorf("expected an AppWrapper but got a %T", obj)
+	}
+	return nil, validateAppWrapper(appWrapper)
+}
+
+// Validate an updated AppWrapper
+// Updates not changing the spec are always accepted
+// so that MCAD can manage AppWrappers admitted before the webhook was enabled
+func (w *AppWrapperWebhook) ValidateUpdate(ctx context.Context, oldObj runtime.Object, newObj runtime.Object) (admission.Warnings, error) {
+	oldAppWrapper, ok := oldObj.(*mcadv1beta1.AppWrapper)
+	if !ok {
+		return nil, fmt.Errorf("expected an AppWrapper but got a %T", oldObj)
+	}
+	newAppWrapper, ok := newObj.(*mcadv1beta1.AppWrapper)
+	if !ok {
+		return nil, fmt.Errorf("expected an AppWrapper but got a %T", newObj)
+	}
+	if !newAppWrapper.DeletionTimestamp.IsZero() || equality.Semantic.DeepEqual(oldAppWrapper.Spec, newAppWrapper.Spec) {
+		return nil, nil
+	}
+	return nil, validateAppWrapper(newAppWrapper)
+}
+
+// Accept AppWrapper deletion
+func (w *AppWrapperWebhook) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// Validate an AppWrapper reporting all problems at once
+func validateAppWrapper(appWrapper *mcadv1beta1.AppWrapper) error {
+	errs := field.ErrorList{}
+	itemsPath := field.NewPath("spec", "resources", "GenericItems")
+	for i, item := range appWrapper.Spec.Resources.GenericItems {
+		path := itemsPath.Index(i)
+		if item.Replicas < 0 {
+			errs = append(errs, field.Invalid(path.Child("replicas"), item.Replicas, "must be non-negative"))
+		}
+		for j, cpr := range item.CustomPodResources {
+			if cpr.Replicas < 0 {
+				errs = append(errs, field.Invalid(path.Child("custompodresources").Index(j).Child("replicas"), cpr.Replicas, "must be non-negative"))
+			}
+		}
+		if item.CompletionStatus != "" {
+			for _, keyword := range strings.Split(item.CompletionStatus, ",") {
+				if !completionStatusKeyword.MatchString(keyword) {
+					errs = append(errs, field.Invalid(path.Child("completionstatus"), item.CompletionStatus,
+						"must be a comma-separated list of condition type keywords"))
+					break
+				}
+			}
+		}
+		obj, err := parseResource(appWrapper, i, item.GenericTemplate.Raw)
+		if err != nil {
+			errs = append(errs, field.Invalid(path.Child("generictemplate"), "", err.Error()))
+			continue
+		}
+		specs := []*v1.PodSpec{}
+		collectPodSpecs(obj.UnstructuredContent(), &specs)
+		if len(specs) > 0 && len(item.CustomPodResources) == 0 {
+			errs = append(errs, field.Required(path.Child("custompodresources"), "must not be empty if the generic template contains pods"))
+		}
+		if len(item.CustomPodResources) > 0 && !hasLabeledPodTemplate(item.GenericTemplate.Raw) {
+			errs = append(errs, field.Invalid(path.Child("generictemplate"), "",
+				"must contain a pod template where the "+nameLabel+" labels can be injected if custompodresources is not empty"))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(mcadv1beta1.GroupVersion.WithKind("AppWrapper").GroupKind(), appWrapper.Name, errs)
+}
+
+// Check if a raw generic template has metadata where fixMap can inject the AppWrapper labels,
+// i.e., a pod template or metadata with an explicit placeholder label
+// The raw template is checked as parsing it with parseResource already injects the labels
+func hasLabeledPodTemplate(raw []byte) bool {
+	obj := &unstructured.Unstructured{}
+	if _, _, err := unstructured.UnstructuredJSONScheme.Decode(raw, nil, obj); err != nil {
+		return false
+	}
+	return hasPodTemplateOrPlaceholder(obj.UnstructuredContent())
+}
+
+// Check if a map or any of its submaps is a pod template or has labels with a placeholder key
+func hasPodTemplateOrPlaceholder(m map[string]interface{}) bool {
+	if spec, ok := m["spec"].(map[string]interface{}); ok {
+		if _, ok := spec["containers"]; ok {
+			return true
+		}
+	}
+	if labels, ok := m["labels"].(map[string]interface{}); ok {
+		if _, ok := labels[nameLabel]; ok {
+			return true
+		}
+	}
+	for _, v := range m {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if hasPodTemplateOrPlaceholder(v) {
+				return true
+			}
+		case []interface{}:
+			for _, w := range v {
+				if w, ok := w.(map[string]interface{}); ok && hasPodTemplateOrPlaceholder(w) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
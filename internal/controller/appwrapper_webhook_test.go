@@ -0,0 +1,194 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+const (
+	webhookTestDeployment = `{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "deployment"},
+		"spec": {"replicas": 2, "template": {"spec": {"containers": [{"name": "busybox", "image": "busybox",
+		"resources": {"requests": {"cpu": "500m"}, "limits": {"memory": "1Gi"}}}]}}}}`
+	webhookTestConfigMap   = `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "config"}}`
+	webhookTestPlaceholder = `{"apiVersion": "example.com/v1", "kind": "Job", "metadata": {"name": "job"},
+		"spec": {"workers": {"metadata": {"labels": {"appwrapper.mcad.ibm.com": "placeholder"}}}}}`
+	webhookTestForeign = `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "config", "namespace": "other"}}`
+)
+
+// Build an AppWrapper wrapping the given generic items
+func webhookTestAppWrapper(items ...mcadv1beta1.GenericItem) *mcadv1beta1.AppWrapper {
+	return &mcadv1beta1.AppWrapper{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "aw"},
+		Spec:       mcadv1beta1.AppWrapperSpec{Resources: mcadv1beta1.AppWrapperResources{GenericItems: items}},
+	}
+}
+
+// Build a generic item with the given raw template and custom pod resources
+func webhookTestItem(raw string, cprs ...mcadv1beta1.CustomPodResource) mcadv1beta1.GenericItem {
+	return mcadv1beta1.GenericItem{GenericTemplate: runtime.RawExtension{Raw: []byte(raw)}, CustomPodResources: cprs}
+}
+
+func TestValidateAppWrapper(t *testing.T) {
+	cpr := mcadv1beta1.CustomPodResource{Replicas: 1, Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}}
+
+	tests := []struct {
+		name   string
+		item   mcadv1beta1.GenericItem
+		errors []string // expected substrings of the error, none if valid
+	}{
+		{
+			name: "pod template with custom pod resources",
+			item: webhookTestItem(webhookTestDeployment, cpr),
+		},
+		{
+			name: "object without pods",
+			item: webhookTestItem(webhookTestConfigMap),
+		},
+		{
+			name: "placeholder label with custom pod resources",
+			item: webhookTestItem(webhookTestPlaceholder, cpr),
+		},
+		{
+			name:   "pod template without custom pod resources",
+			item:   webhookTestItem(webhookTestDeployment),
+			errors: []string{"custompodresources: Required value"},
+		},
+		{
+			name:   "custom pod resources without pod template",
+			item:   webhookTestItem(webhookTestConfigMap, cpr),
+			errors: []string{"must contain a pod template"},
+		},
+		{
+			name:   "undecodable template",
+			item:   webhookTestItem(`{"kind": "ConfigMap"`),
+			errors: []string{"generictemplate"},
+		},
+		{
+			name:   "template in another namespace",
+			item:   webhookTestItem(webhookTestForeign),
+			errors: []string{"is different from AppWrapper namespace"},
+		},
+		{
+			name: "negative replicas",
+			item: func() mcadv1beta1.GenericItem {
+				item := webhookTestItem(webhookTestDeployment, mcadv1beta1.CustomPodResource{Replicas: -1})
+				item.Replicas = -1
+				return item
+			}(),
+			errors: []string{"replicas: Invalid value: -1", "custompodresources[0].replicas: Invalid value: -1"},
+		},
+		{
+			name: "invalid completion status",
+			item: func() mcadv1beta1.GenericItem {
+				item := webhookTestItem(webhookTestConfigMap)
+				item.CompletionStatus = "Complete,,Failed"
+				return item
+			}(),
+			errors: []string{"completionstatus"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAppWrapper(webhookTestAppWrapper(tt.item))
+			if len(tt.errors) == 0 {
+				if err != nil {
+					t.Errorf("validateAppWrapper() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("validateAppWrapper() = nil, want errors %v", tt.errors)
+			}
+			for _, expected := range tt.errors {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("validateAppWrapper() = %v, want error containing %q", err, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestAppWrapperWebhookValidateUpdate(t *testing.T) {
+	webhook := &AppWrapperWebhook{}
+	invalid := webhookTestAppWrapper(webhookTestItem(webhookTestDeployment))
+
+	// status-only updates of AppWrappers admitted before the webhook was enabled are accepted
+	updated := invalid.DeepCopy()
+	updated.Status.State = mcadv1beta1.Running
+	if _, err := webhook.ValidateUpdate(context.Background(), invalid, updated); err != nil {
+		t.Errorf("ValidateUpdate() = %v for unchanged spec, want no error", err)
+	}
+
+	// spec updates are validated
+	updated = invalid.DeepCopy()
+	updated.Spec.Priority = 1
+	if _, err := webhook.ValidateUpdate(context.Background(), invalid, updated); err == nil {
+		t.Error("ValidateUpdate() = nil for invalid spec update, want error")
+	}
+}
+
+func TestAppWrapperWebhookDefault(t *testing.T) {
+	cpr := mcadv1beta1.CustomPodResource{Replicas: 3, Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}}
+	appWrapper := webhookTestAppWrapper(
+		webhookTestItem(webhookTestDeployment),
+		webhookTestItem(webhookTestDeployment, cpr),
+		webhookTestItem(webhookTestConfigMap))
+	if err := (&AppWrapperWebhook{}).Default(context.Background(), appWrapper); err != nil {
+		t.Fatalf("Default() = %v, want no error", err)
+	}
+	items := appWrapper.Spec.Resources.GenericItems
+
+	// inferred from the pod template of the deployment
+	if len(items[0].CustomPodResources) != 1 {
+		t.Fatalf("inferred %d custom pod resources, want 1", len(items[0].CustomPodResources))
+	}
+	inferred := items[0].CustomPodResources[0]
+	if inferred.Replicas != 2 {
+		t.Errorf("inferred replicas = %d, want 2", inferred.Replicas)
+	}
+	if cpu := inferred.Requests[v1.ResourceCPU]; cpu.Cmp(resource.MustParse("500m")) != 0 {
+		t.Errorf("inferred cpu request = %v, want 500m", cpu.String())
+	}
+	if memory := inferred.Limits[v1.ResourceMemory]; memory.Cmp(resource.MustParse("1Gi")) != 0 {
+		t.Errorf("inferred memory limit = %v, want 1Gi", memory.String())
+	}
+
+	// declared custom pod resources are kept
+	if len(items[1].CustomPodResources) != 1 || items[1].CustomPodResources[0].Replicas != 3 {
+		t.Errorf("declared custom pod resources = %v, want %v", items[1].CustomPodResources, []mcadv1beta1.CustomPodResource{cpr})
+	}
+
+	// nothing to infer without pod template
+	if len(items[2].CustomPodResources) != 0 {
+		t.Errorf("inferred custom pod resources %v for config map, want none", items[2].CustomPodResources)
+	}
+
+	// the defaulted AppWrapper is valid
+	if err := validateAppWrapper(appWrapper); err != nil {
+		t.Errorf("validateAppWrapper() = %v for defaulted AppWrapper, want no error", err)
+	}
+}
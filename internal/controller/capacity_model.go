@@ -0,0 +1,301 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"maps"
+	"reflect"
+	"sort"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+// An incremental model of the capacity available on the nodes of the cluster
+// The model is updated from node and pod watch events instead of listing all nodes and pods.
+// Only the requests of non-terminated non-AppWrapper pods are tracked.
+type capacityModel struct {
+	// protect concurrent access from event handlers and reconciler
+	lock sync.Mutex
+
+	// schedulable nodes by name
+	nodes map[string]*nodeState
+
+	// tracked pods by UID
+	pods map[types.UID]*podState
+
+	// requests of tracked pods per node, namespace, and kind, including nodes not known or not schedulable
+	used map[usageKey]Weights
+
+	// node label keys to retain, other node labels are dropped
+	labelKeys []string
+
+	// incremented on every change to the model
+	version uint64
+
+	// has the model been rebuilt from the synced informer cache at least once?
+	synced bool
+}
+
+// The requests of tracked pods are aggregated by node, namespace, and kind so that capacity policies can be applied
+type usageKey struct {
+	// node the pods are bound to, empty if not bound yet
+	node string
+
+	// namespace of the pods
+	namespace string
+
+	// are the pods controlled by a DaemonSet?
+	daemonSet bool
+}
+
+// The state of a schedulable node
+type nodeState struct {
+	// allocatable capacity
+	allocatable Weights
+
+	// node labels with retained keys
+	labels map[string]string
+
+	// NoSchedule and NoExecute taints
+	taints []v1.Taint
+}
+
+// The state of a tracked pod
+type podState struct {
+	// node, namespace, and kind of the pod
+	key usageKey
+
+	// pod requests
+	request Weights
+}
+
+// Create an empty capacity model retaining the given node label keys
+func newCapacityModel(labelKeys []string) *capacityModel {
+	return &capacityModel{
+		nodes:     map[string]*nodeState{},
+		pods:      map[types.UID]*podState{},
+		used:      map[usageKey]Weights{},
+		labelKeys: labelKeys,
+	}
+}
+
+// Record a new or updated node, return true if the model changed
+func (model *capacityModel) updateNode(node *v1.Node) bool {
+	model.lock.Lock()
+	defer model.lock.Unlock()
+	if node.Spec.Unschedulable {
+		return model.forgetNode(node.Name)
+	}
+	// copy retained labels as node may be shared with the informer cache
+	labels := map[string]string{}
+	for _, k := range model.labelKeys {
+		if v, ok := node.Labels[k]; ok {
+			labels[k] = v
+		}
+	}
+	state := &nodeState{
+		allocatable: NewWeights(node.Status.Allocatable),
+		labels:      labels,
+		taints:      schedulingTaints(node),
+	}
+	if old, ok := model.nodes[node.Name]; ok && old.allocatable.Equal(state.allocatable) &&
+		maps.Equal(old.labels, state.labels) && reflect.DeepEqual(old.taints, state.taints) {
+		return false // no change, e.g., heartbeat or condition update
+	}
+	model.nodes[node.Name] = state
+	model.version++
+	return true
+}
+
+// Forget a deleted node, return true if the model changed
+func (model *capacityModel) deleteNode(name string) bool {
+	model.lock.Lock()
+	defer model.lock.Unlock()
+	return model.forgetNode(name)
+}
+
+// Remove a node from the model if known, lock must be held
+// Pods bound to the node remain tracked until deleted
+func (model *capacityModel) forgetNode(name string) bool {
+	if _, ok := model.nodes[name]; !ok {
+		return false
+	}
+	delete(model.nodes, name)
+	model.version++
+	return true
+}
+
+// Record a new or updated pod, return true if the model changed
+func (model *capacityModel) updatePod(pod *v1.Pod) bool {
+	model.lock.Lock()
+	defer model.lock.Unlock()
+	if _, ok := pod.Labels[nameLabel]; ok || pod.Status.Phase == v1.PodFailed || pod.Status.Phase == v1.PodSucceeded {
+		return model.forgetPod(pod.UID) // not tracked
+	}
+	owner := metav1.GetControllerOf(pod)
+	key := usageKey{
+		node:      pod.Spec.NodeName,
+		namespace: pod.Namespace,
+		daemonSet: owner != nil && owner.Kind == "DaemonSet",
+	}
+	state := &podState{key: key, request: NewWeightsForPod(pod)}
+	if old, ok := model.pods[pod.UID]; ok && old.key == key && old.request.Equal(state.request) {
+		return false // no change, e.g., status update
+	}
+	model.forgetPod(pod.UID)
+	model.pods[pod.UID] = state
+	if model.used[key] == nil {
+		model.used[key] = Weights{}
+	}
+	model.used[key].Add(state.request)
+	model.version++
+	return true
+}
+
+// Forget a deleted pod, return true if the model changed
+func (model *capacityModel) deletePod(uid types.UID) bool {
+	model.lock.Lock()
+	defer model.lock.Unlock()
+	return model.forgetPod(uid)
+}
+
+// Remove a pod from the model if tracked, lock must be held
+func (model *capacityModel) forgetPod(uid types.UID) bool {
+	state, ok := model.pods[uid]
+	if !ok {
+		return false
+	}
+	delete(model.pods, uid)
+	model.used[state.key].Sub(state.request)
+	if model.used[state.key].Equal(nil) {
+		delete(model.used, state.key) // do not retain keys of departed namespaces and nodes
+	}
+	model.version++
+	return true
+}
+
+// Rebuild the model from the given nodes and pods, e.g., to recover from missed events, return true if the model changed
+// The lock must be held while listing the nodes and pods and resetting the model so that the events following
+// the listing are applied after the reset
+func (model *capacityModel) reset(nodes []v1.Node, pods []v1.Pod) bool {
+	rebuilt := newCapacityModel(model.labelKeys)
+	for i := range nodes {
+		rebuilt.updateNode(&nodes[i])
+	}
+	for i := range pods {
+		rebuilt.updatePod(&pods[i])
+	}
+	model.synced = true
+	if reflect.DeepEqual(model.nodes, rebuilt.nodes) && reflect.DeepEqual(model.pods, rebuilt.pods) {
+		return false
+	}
+	model.nodes = rebuilt.nodes
+	model.pods = rebuilt.pods
+	model.used = rebuilt.used
+	model.version++
+	return true
+}
+
+// Has the model been rebuilt from the synced informer cache at least once?
+func (model *capacityModel) isSynced() bool {
+	model.lock.Lock()
+	defer model.lock.Unlock()
+	return model.synced
+}
+
+// Compute available cluster capacity in aggregate over untainted nodes and per node according to a capacity policy
+// Nodes are listed in increasing name order
+// The burst reserve applies to each node, headroom and pods of reserved namespaces not bound to a node yet only
+// reduce the aggregate capacity as they cannot be attributed to nodes
+// Also return the version of the model the snapshot was taken from
+func (model *capacityModel) snapshot(policy *mcadv1beta1.CapacityPolicy) (Weights, []mcadv1beta1.NodeInfo, uint64) {
+	model.lock.Lock()
+	defer model.lock.Unlock()
+	ignored := map[string]bool{}
+	for _, namespace := range policy.IgnoredNamespaces {
+		ignored[namespace] = true
+	}
+	reserved := map[string]bool{}
+	for _, namespace := range policy.ReservedNamespaces {
+		reserved[namespace] = true
+	}
+	// aggregate requests of tracked pods per node according to policy
+	used := map[string]Weights{}
+	pending := Weights{} // requests of pods of reserved namespaces not bound to a node yet
+	for key, request := range model.used {
+		if !reserved[key.namespace] && (ignored[key.namespace] || key.daemonSet && policy.IgnoreDaemonSetPods) {
+			continue
+		}
+		if key.node == "" {
+			if reserved[key.namespace] {
+				pending.Add(request)
+			}
+			continue
+		}
+		if used[key.node] == nil {
+			used[key.node] = Weights{}
+		}
+		used[key.node].Add(request)
+	}
+	names := make([]string, 0, len(model.nodes))
+	for name := range model.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	capacity := Weights{}
+	nodeInfos := make([]mcadv1beta1.NodeInfo, 0, len(names))
+	for _, name := range names {
+		node := model.nodes[name]
+		// subtract requests from non-AppWrapper, non-terminated pods on this node
+		nodeCapacity := node.allocatable.Clone()
+		nodeCapacity.Sub(used[name])
+		// hold back burst reserve on this node
+		if policy.BurstReservePercent > 0 {
+			nodeCapacity.Scale(100-int64(policy.BurstReservePercent), 100)
+		}
+		// add allocatable capacity on the node unless tainted
+		if len(node.taints) == 0 {
+			capacity.Add(nodeCapacity)
+			updateCapacityMetrics(nodeCapacity, name)
+		}
+		// copy labels and taints as status must not share the model state
+		labels := make(map[string]string, len(node.labels))
+		for k, v := range node.labels {
+			labels[k] = v
+		}
+		var taints []v1.Taint
+		if len(node.taints) > 0 {
+			taints = append(taints, node.taints...)
+		}
+		nodeInfos = append(nodeInfos, mcadv1beta1.NodeInfo{Name: name, Labels: labels, Capacity: nodeCapacity.AsResources(), Taints: taints})
+	}
+	capacity.Sub(pending)
+	capacity.Sub(NewWeights(policy.Headroom))
+	return capacity, nodeInfos, model.version
+}
+
+// Return the current version of the model
+func (model *capacityModel) currentVersion() uint64 {
+	model.lock.Lock()
+	defer model.lock.Unlock()
+	return model.version
+}
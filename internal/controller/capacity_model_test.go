@@ -0,0 +1,323 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+// Build a node with the given CPU capacity
+func modelTestNode(name string, cpu string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     v1.NodeStatus{Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}},
+	}
+}
+
+// Build a pod with the given CPU request bound to the given node in the given phase
+func modelTestPod(uid string, node string, cpu string, phase v1.PodPhase) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: uid, UID: types.UID(uid)},
+		Spec: v1.PodSpec{NodeName: node, Containers: []v1.Container{{
+			Resources: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}},
+		}}},
+		Status: v1.PodStatus{Phase: phase},
+	}
+}
+
+// An event applied to the capacity model
+type modelTestEvent struct {
+	node       *v1.Node // node to update
+	deleteNode string   // node to delete
+	pod        *v1.Pod  // pod to update
+	deletePod  string   // uid of pod to delete
+	changed    bool     // expected result
+}
+
+// Apply an event to a capacity model
+func (e modelTestEvent) apply(model *capacityModel) bool {
+	switch {
+	case e.node != nil:
+		return model.updateNode(e.node)
+	case e.deleteNode != "":
+		return model.deleteNode(e.deleteNode)
+	case e.pod != nil:
+		return model.updatePod(e.pod)
+	default:
+		return model.deletePod(types.UID(e.deletePod))
+	}
+}
+
+func TestCapacityModelEvents(t *testing.T) {
+	tests := []struct {
+		name     string
+		events   []modelTestEvent
+		capacity string            // expected aggregate CPU capacity
+		nodes    map[string]string // expected CPU capacity per node
+		pods     int               // expected number of tracked pods
+	}{
+		{
+			name: "pending then running pod",
+			events: []modelTestEvent{
+				{node: modelTestNode("node-0", "4"), changed: true},
+				{pod: modelTestPod("pod", "", "1", v1.PodPending), changed: true},
+				{pod: modelTestPod("pod", "node-0", "1", v1.PodPending), changed: true},
+				{pod: modelTestPod("pod", "node-0", "1", v1.PodRunning), changed: false},
+			},
+			capacity: "3", nodes: map[string]string{"node-0": "3"}, pods: 1,
+		},
+		{
+			name: "succeeded and failed pods",
+			events: []modelTestEvent{
+				{node: modelTestNode("node-0", "4"), changed: true},
+				{pod: modelTestPod("succeeded", "node-0", "1", v1.PodRunning), changed: true},
+				{pod: modelTestPod("succeeded", "node-0", "1", v1.PodSucceeded), changed: true},
+				{pod: modelTestPod("failed", "node-0", "1", v1.PodFailed), changed: false},
+				{deletePod: "succeeded", changed: false},
+			},
+			capacity: "4", nodes: map[string]string{"node-0": "4"}, pods: 0,
+		},
+		{
+			name: "rebound pod",
+			events: []modelTestEvent{
+				{node: modelTestNode("node-0", "4"), changed: true},
+				{node: modelTestNode("node-1", "4"), changed: true},
+				{pod: modelTestPod("pod", "node-0", "2", v1.PodRunning), changed: true},
+				{pod: modelTestPod("pod", "node-1", "2", v1.PodRunning), changed: true},
+			},
+			capacity: "6", nodes: map[string]string{"node-0": "4", "node-1": "2"}, pods: 1,
+		},
+		{
+			name: "deleted pod",
+			events: []modelTestEvent{
+				{node: modelTestNode("node-0", "4"), changed: true},
+				{pod: modelTestPod("pod", "node-0", "2", v1.PodRunning), changed: true},
+				{deletePod: "pod", changed: true},
+				{deletePod: "pod", changed: false},
+			},
+			capacity: "4", nodes: map[string]string{"node-0": "4"}, pods: 0,
+		},
+		{
+			name: "AppWrapper pod",
+			events: []modelTestEvent{
+				{node: modelTestNode("node-0", "4"), changed: true},
+				{pod: func() *v1.Pod {
+					pod := modelTestPod("pod", "node-0", "2", v1.PodRunning)
+					pod.Labels = map[string]string{nameLabel: "aw"}
+					return pod
+				}(), changed: false},
+			},
+			capacity: "4", nodes: map[string]string{"node-0": "4"}, pods: 0,
+		},
+		{
+			name: "deleted node with remaining pods",
+			events: []modelTestEvent{
+				{node: modelTestNode("node-0", "4"), changed: true},
+				{node: modelTestNode("node-1", "4"), changed: true},
+				{pod: modelTestPod("pod", "node-0", "2", v1.PodRunning), changed: true},
+				{deleteNode: "node-0", changed: true},
+				{deleteNode: "node-0", changed: false},
+			},
+			capacity: "4", nodes: map[string]string{"node-1": "4"}, pods: 1,
+		},
+		{
+			name: "recreated node with remaining pods",
+			events: []modelTestEvent{
+				{node: modelTestNode("node-0", "4"), changed: true},
+				{pod: modelTestPod("pod", "node-0", "2", v1.PodRunning), changed: true},
+				{deleteNode: "node-0", changed: true},
+				{node: modelTestNode("node-0", "4"), changed: true},
+			},
+			capacity: "2", nodes: map[string]string{"node-0": "2"}, pods: 1,
+		},
+		{
+			name: "unchanged and cordoned node",
+			events: []modelTestEvent{
+				{node: modelTestNode("node-0", "4"), changed: true},
+				{node: modelTestNode("node-1", "4"), changed: true},
+				{node: modelTestNode("node-0", "4"), changed: false},
+				{node: modelTestNode("node-0", "8"), changed: true},
+				{node: func() *v1.Node {
+					node := modelTestNode("node-1", "4")
+					node.Spec.Unschedulable = true
+					return node
+				}(), changed: true},
+			},
+			capacity: "8", nodes: map[string]string{"node-0": "8"}, pods: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := newCapacityModel(nil)
+			for i, e := range tt.events {
+				version := model.currentVersion()
+				if changed := e.apply(model); changed != e.changed {
+					t.Errorf("event %d changed model = %v, want %v", i, changed, e.changed)
+				}
+				if changed := model.currentVersion() != version; changed != e.changed {
+					t.Errorf("event %d changed model version = %v, want %v", i, changed, e.changed)
+				}
+			}
+			if len(model.pods) != tt.pods {
+				t.Errorf("model tracks %d pods, want %d", len(model.pods), tt.pods)
+			}
+			capacity, nodes, version := model.snapshot(&mcadv1beta1.CapacityPolicy{})
+			if version != model.currentVersion() {
+				t.Errorf("snapshot version = %d, want %d", version, model.currentVersion())
+			}
+			if cpu := capacity.AsResources()[v1.ResourceCPU]; cpu.Cmp(resource.MustParse(tt.capacity)) != 0 {
+				t.Errorf("capacity = %s, want %s", cpu.String(), tt.capacity)
+			}
+			if len(nodes) != len(tt.nodes) {
+				t.Fatalf("snapshot has %d nodes, want %d", len(nodes), len(tt.nodes))
+			}
+			for _, node := range nodes {
+				if cpu := node.Capacity[v1.ResourceCPU]; cpu.Cmp(resource.MustParse(tt.nodes[node.Name])) != 0 {
+					t.Errorf("capacity of %s = %s, want %s", node.Name, cpu.String(), tt.nodes[node.Name])
+				}
+			}
+		})
+	}
+}
+
+func TestCapacityModelSnapshotPolicy(t *testing.T) {
+	daemonSetPod := modelTestPod("daemon", "node-0", "1", v1.PodRunning)
+	daemonSetPod.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "ds", Controller: &[]bool{true}[0]}}
+	ignoredPod := modelTestPod("ignored", "node-0", "1", v1.PodRunning)
+	ignoredPod.Namespace = "ignored"
+	reservedPod := modelTestPod("reserved", "", "1", v1.PodPending)
+	reservedPod.Namespace = "reserved"
+
+	tests := []struct {
+		name     string
+		policy   mcadv1beta1.CapacityPolicy
+		capacity string
+		node     string
+	}{
+		{name: "default policy", capacity: "5", node: "5"},
+		{name: "ignored DaemonSet pods", policy: mcadv1beta1.CapacityPolicy{IgnoreDaemonSetPods: true}, capacity: "6", node: "6"},
+		{name: "ignored namespace", policy: mcadv1beta1.CapacityPolicy{IgnoredNamespaces: []string{"ignored"}}, capacity: "6", node: "6"},
+		{name: "reserved namespace", policy: mcadv1beta1.CapacityPolicy{ReservedNamespaces: []string{"reserved"}}, capacity: "4", node: "5"},
+		{name: "headroom", policy: mcadv1beta1.CapacityPolicy{Headroom: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")}}, capacity: "3", node: "5"},
+		{name: "burst reserve", policy: mcadv1beta1.CapacityPolicy{BurstReservePercent: 50}, capacity: "2.5", node: "2.5"},
+		{
+			name: "burst reserve, headroom, and reserved namespace",
+			policy: mcadv1beta1.CapacityPolicy{
+				Headroom:            v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")},
+				ReservedNamespaces:  []string{"reserved"},
+				BurstReservePercent: 50,
+			},
+			capacity: "0.5", node: "2.5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := newCapacityModel(nil)
+			model.updateNode(modelTestNode("node-0", "7"))
+			model.updatePod(daemonSetPod)
+			model.updatePod(ignoredPod)
+			model.updatePod(reservedPod)
+			capacity, nodes, _ := model.snapshot(&tt.policy)
+			if cpu := capacity.AsResources()[v1.ResourceCPU]; cpu.Cmp(resource.MustParse(tt.capacity)) != 0 {
+				t.Errorf("capacity = %s, want %s", cpu.String(), tt.capacity)
+			}
+			if cpu := nodes[0].Capacity[v1.ResourceCPU]; cpu.Cmp(resource.MustParse(tt.node)) != 0 {
+				t.Errorf("node capacity = %s, want %s", cpu.String(), tt.node)
+			}
+		})
+	}
+}
+
+func TestCapacityModelSnapshotBurstReservePerNode(t *testing.T) {
+	model := newCapacityModel([]string{nodeTestZone})
+	for _, name := range []string{"node-0", "node-1"} {
+		node := modelTestNode(name, "8")
+		node.Labels = map[string]string{nodeTestZone: "a"}
+		model.updateNode(node)
+	}
+	tainted := modelTestNode("node-2", "8")
+	tainted.Spec.Taints = []v1.Taint{{Key: "dedicated", Effect: v1.TaintEffectNoSchedule}}
+	model.updateNode(tainted)
+	model.updatePod(modelTestPod("pod", "node-0", "4", v1.PodRunning))
+
+	capacity, nodes, _ := model.snapshot(&mcadv1beta1.CapacityPolicy{BurstReservePercent: 25})
+	if cpu := capacity.AsResources()[v1.ResourceCPU]; cpu.Cmp(resource.MustParse("9")) != 0 {
+		t.Errorf("capacity = %s, want 9", cpu.String())
+	}
+	for _, expected := range []struct{ node, cpu string }{{"node-0", "3"}, {"node-1", "6"}, {"node-2", "6"}} {
+		for _, node := range nodes {
+			if cpu := node.Capacity[v1.ResourceCPU]; node.Name == expected.node && cpu.Cmp(resource.MustParse(expected.cpu)) != 0 {
+				t.Errorf("capacity of %s = %s, want %s", node.Name, cpu.String(), expected.cpu)
+			}
+		}
+	}
+	// per-node capacity metrics report the capacity of each untainted node
+	for _, expected := range []struct {
+		node string
+		cpu  float64
+	}{{"node-0", 3}, {"node-1", 6}} {
+		if cpu := testutil.ToFloat64(totalCapacityCpu.WithLabelValues(expected.node)); cpu != expected.cpu {
+			t.Errorf("capacity metric of %s = %v, want %v", expected.node, cpu, expected.cpu)
+		}
+	}
+	if domains := topologyDomains([]string{nodeTestZone}, nodes); len(domains) != 1 || domains[0].Capacity.Cpu().Cmp(resource.MustParse("9")) != 0 {
+		t.Errorf("domains = %v, want zone a with 9 cpus", domains)
+	}
+	if tainted := taintedCapacity(nodes); len(tainted) != 1 || tainted[0].Capacity.Cpu().Cmp(resource.MustParse("6")) != 0 {
+		t.Errorf("tainted capacity = %v, want 6 cpus", tainted)
+	}
+}
+
+func TestCapacityModelReset(t *testing.T) {
+	model := newCapacityModel(nil)
+	model.updateNode(modelTestNode("node-0", "4"))
+	model.updateNode(modelTestNode("stale", "4"))
+	model.updatePod(modelTestPod("pod", "node-0", "1", v1.PodRunning))
+	model.updatePod(modelTestPod("stale", "node-0", "1", v1.PodRunning))
+	version := model.currentVersion()
+
+	// missed deletion of node and pod
+	nodes := []v1.Node{*modelTestNode("node-0", "4")}
+	pods := []v1.Pod{*modelTestPod("pod", "node-0", "1", v1.PodRunning)}
+	if !model.reset(nodes, pods) {
+		t.Error("reset() = false, want true")
+	}
+	if !model.isSynced() {
+		t.Error("model not synced after reset")
+	}
+	if model.currentVersion() == version {
+		t.Error("model version unchanged after reset")
+	}
+	capacity, snapshot, _ := model.snapshot(&mcadv1beta1.CapacityPolicy{})
+	if cpu := capacity.AsResources()[v1.ResourceCPU]; cpu.Cmp(resource.MustParse("3")) != 0 || len(snapshot) != 1 || len(model.pods) != 1 {
+		t.Errorf("capacity = %s with %d nodes and %d pods, want 3 with 1 node and 1 pod", cpu.String(), len(snapshot), len(model.pods))
+	}
+
+	// no change
+	version = model.currentVersion()
+	if model.reset(nodes, pods) || model.currentVersion() != version {
+		t.Error("reset() changed the model, want no change")
+	}
+}
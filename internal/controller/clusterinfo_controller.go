@@ -18,14 +18,24 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"sort"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
 )
@@ -34,6 +44,27 @@ import (
 type ClusterInfoReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// Node label keys to group capacity by in the cluster info status
+	TopologyKeys []string
+
+	// Node label keys to report for each node in the cluster info status in addition to the topology keys
+	NodeLabelKeys []string
+
+	// Capacity model maintained from node and pod events
+	model *capacityModel
+
+	// Event channel to trigger the reconciliation of all ClusterInfo objects when the capacity model changes
+	events chan event.GenericEvent
+
+	// Model version and resource version of each ClusterInfo object when its status was last found up to date
+	reported map[types.NamespacedName]reportedClusterInfo
+}
+
+// The versions of the capacity model and of a ClusterInfo object when its status was last found up to date
+type reportedClusterInfo struct {
+	modelVersion    uint64
+	resourceVersion string
 }
 
 // permission to edit clusterinfo
@@ -42,113 +73,266 @@ type ClusterInfoReconciler struct {
 //+kubebuilder:rbac:groups=workload.codeflare.dev,resources=clusterinfo/status,verbs=get;update;patch
 
 // Reconcile ClusterInfo object
+// The status is only updated if the capacity changed and at most once per clusterInfoMinInterval
+// The status is not recomputed if neither the capacity model nor the object changed since it was last found up to date
+// The status is not updated until the capacity model is built from the synced informer cache
 func (r *ClusterInfoReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	clusterInfo := &mcadv1beta1.ClusterInfo{}
-	if err := r.Client.Get(ctx, req.NamespacedName, clusterInfo); err == nil {
-		// do not recompute cluster capacity if old value has not expired yet
-		now := time.Now()
-		expiration := clusterInfo.Status.Time.Add(clusterInfoTimeout)
-		if expiration.After(now) {
-			// requeue to update after expiration
-			return ctrl.Result{RequeueAfter: expiration.Sub(now)}, nil
-		}
-	} else {
-		return ctrl.Result{}, err
+	if err := r.Client.Get(ctx, req.NamespacedName, clusterInfo); err != nil {
+		delete(r.reported, req.NamespacedName)
+		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
-	// compute available capacity
-	capacity, err := r.computeCapacity(ctx)
-	if err != nil {
-		return ctrl.Result{}, err
+	if !r.model.isSynced() {
+		return ctrl.Result{}, nil // reconciliation is triggered once the model is synced
+	}
+	if r.reported[req.NamespacedName] == (reportedClusterInfo{modelVersion: r.model.currentVersion(), resourceVersion: clusterInfo.ResourceVersion}) {
+		return ctrl.Result{}, nil // no change
+	}
+	// compute available capacity from capacity model and capacity policy
+	capacity, nodes, version := r.model.snapshot(&clusterInfo.Spec.CapacityPolicy)
+	status := mcadv1beta1.ClusterInfoStatus{
+		Capacity:        capacity.AsResources(),
+		TaintedCapacity: taintedCapacity(nodes),
+		Nodes:           nodes,
+		NodeLabelKeys:   r.model.labelKeys,
+		Domains:         topologyDomains(r.TopologyKeys, nodes),
+		Time:            clusterInfo.Status.Time,
+	}
+	if !clusterInfo.Status.Time.IsZero() && equality.Semantic.DeepEqual(status, clusterInfo.Status) {
+		r.reported[req.NamespacedName] = reportedClusterInfo{modelVersion: version, resourceVersion: clusterInfo.ResourceVersion}
+		return ctrl.Result{}, nil // no change
+	}
+	// rate limit updates
+	if wait := time.Until(clusterInfo.Status.Time.Add(clusterInfoMinInterval)); wait > 0 {
+		return ctrl.Result{RequeueAfter: wait}, nil
 	}
-	clusterInfo.Status.Capacity = capacity.AsResources()
-	clusterInfo.Status.Time = metav1.Now()
+	status.Time = metav1.Now()
+	clusterInfo.Status = status
 	// update cluster info status
 	if err := r.Status().Update(ctx, clusterInfo); err != nil {
 		return ctrl.Result{}, err
 	}
-	return ctrl.Result{RequeueAfter: clusterInfoTimeout}, nil
+	r.reported[req.NamespacedName] = reportedClusterInfo{modelVersion: version, resourceVersion: clusterInfo.ResourceVersion}
+	return ctrl.Result{}, nil
 }
 
-// Compute available cluster capacity
-func (r *ClusterInfoReconciler) computeCapacity(ctx context.Context) (Weights, error) {
-	capacity := Weights{}
-	// add allocatable capacity for each schedulable node
-	nodes := &v1.NodeList{}
-	if err := r.List(ctx, nodes, client.UnsafeDisableDeepCopy); err != nil {
-		return nil, err
-	}
-LOOP:
-	for _, node := range nodes.Items {
-		// skip unschedulable nodes
-		if node.Spec.Unschedulable {
+// Group the capacity of the tainted nodes by taint set
+// Taint sets are listed in the order of their first node
+func taintedCapacity(nodes []mcadv1beta1.NodeInfo) []mcadv1beta1.TaintedCapacityInfo {
+	tainted := []mcadv1beta1.TaintedCapacityInfo{}
+	capacity := map[string]Weights{}
+	index := map[string]int{}
+	for _, node := range nodes {
+		if len(node.Taints) == 0 {
 			continue
 		}
-		for _, taint := range node.Spec.Taints {
-			if taint.Effect == v1.TaintEffectNoSchedule || taint.Effect == v1.TaintEffectNoExecute {
-				continue LOOP
+		key := taintSetKey(node.Taints)
+		if _, ok := index[key]; !ok {
+			index[key] = len(tainted)
+			capacity[key] = Weights{}
+			tainted = append(tainted, mcadv1beta1.TaintedCapacityInfo{Taints: node.Taints})
+		}
+		capacity[key].Add(NewWeights(node.Capacity))
+		tainted[index[key]].NodeCount++
+	}
+	for key, i := range index {
+		tainted[i].Capacity = capacity[key].AsResources()
+	}
+	return tainted
+}
+
+// Group the capacity of the nodes by topology domain for each topology key
+// Domains are listed in topology key order then in increasing label value order
+// Nodes without a given label do not belong to any domain for this label, tainted nodes do not belong to any domain
+func topologyDomains(keys []string, nodes []mcadv1beta1.NodeInfo) []mcadv1beta1.TopologyDomainInfo {
+	domains := []mcadv1beta1.TopologyDomainInfo{}
+	for _, key := range keys {
+		capacity := map[string]Weights{}
+		counts := map[string]int32{}
+		for _, node := range nodes {
+			value, ok := node.Labels[key]
+			if !ok || len(node.Taints) > 0 {
+				continue
+			}
+			if capacity[value] == nil {
+				capacity[value] = Weights{}
 			}
+			capacity[value].Add(NewWeights(node.Capacity))
+			counts[value]++
 		}
-		// compute allocatable capacity on the node
-		nodeCapacity := NewWeights(node.Status.Allocatable)
-		// subtract requests from non-AppWrapper, non-terminated pods on this node
-		fieldSelector, err := fields.ParseSelector(specNodeName + "=" + node.Name)
-		if err != nil {
-			return nil, err
+		values := make([]string, 0, len(capacity))
+		for value := range capacity {
+			values = append(values, value)
 		}
-		pods := &v1.PodList{}
-		if err := r.List(ctx, pods, client.UnsafeDisableDeepCopy,
-			client.MatchingFieldsSelector{Selector: fieldSelector}); err != nil {
-			return nil, err
+		sort.Strings(values)
+		for _, value := range values {
+			domains = append(domains, mcadv1beta1.TopologyDomainInfo{Key: key, Value: value, NodeCount: counts[value], Capacity: capacity[value].AsResources()})
 		}
-		for _, pod := range pods.Items {
-			if _, ok := pod.GetLabels()[nameLabel]; !ok && pod.Status.Phase != v1.PodFailed && pod.Status.Phase != v1.PodSucceeded {
-				nodeCapacity.Sub(NewWeightsForPod(&pod))
-			}
+	}
+	return domains
+}
+
+// Merge topology keys and node label keys into a sorted list without duplicates
+func reportedLabelKeys(topologyKeys []string, nodeLabelKeys []string) []string {
+	seen := map[string]bool{}
+	keys := []string{}
+	for _, key := range append(append([]string{}, topologyKeys...), nodeLabelKeys...) {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
 		}
-		// add allocatable capacity on the node
-		capacity.Add(nodeCapacity)
-		updateCapacityMetrics(capacity, node)
 	}
-	return capacity, nil
+	sort.Strings(keys)
+	return keys
 }
 
 // Update capacity metrics
-func updateCapacityMetrics(capacity Weights, node v1.Node) {
+func updateCapacityMetrics(capacity Weights, node string) {
 	capacityCpu, err := Dec2float64(capacity["cpu"])
 	if err != nil {
-		mcadLog.Error(err, "Unable to get CPU capacity", "node", node.Name)
+		mcadLog.Error(err, "Unable to get CPU capacity", "node", node)
 	} else {
-		totalCapacityCpu.WithLabelValues(node.Name).Set(capacityCpu)
+		totalCapacityCpu.WithLabelValues(node).Set(capacityCpu)
 	}
 
 	capacityMemory, err := Dec2float64(capacity["memory"])
 	if err != nil {
-		mcadLog.Error(err, "Unable to get memory capacity", "node", node.Name)
+		mcadLog.Error(err, "Unable to get memory capacity", "node", node)
 	} else {
-		totalCapacityMemory.WithLabelValues(node.Name).Set(capacityMemory)
+		totalCapacityMemory.WithLabelValues(node).Set(capacityMemory)
 	}
 
 	if val, exists := capacity["nvidia.com/gpu"]; exists {
 		capacityGpu, err := Dec2float64(val)
 		if err != nil {
-			mcadLog.Error(err, "Unable to get GPU capacity", "node", node.Name)
+			mcadLog.Error(err, "Unable to get GPU capacity", "node", node)
 		} else {
-			totalCapacityGpu.WithLabelValues(node.Name).Set(capacityGpu)
+			totalCapacityGpu.WithLabelValues(node).Set(capacityGpu)
 		}
 	}
 }
 
+// Rebuild the capacity model from the nodes and pods in the informer cache and trigger reconciliation if the model changed
+// The first resync marks the model as synced and always triggers reconciliation
+func (r *ClusterInfoReconciler) resync(ctx context.Context) error {
+	r.model.lock.Lock()
+	defer r.model.lock.Unlock()
+	nodes := &v1.NodeList{}
+	if err := r.List(ctx, nodes); err != nil {
+		return err
+	}
+	pods := &v1.PodList{}
+	if err := r.List(ctx, pods); err != nil {
+		return err
+	}
+	synced := r.model.synced
+	if r.model.reset(nodes.Items, pods.Items) || !synced {
+		r.triggerReconcile()
+	}
+	return nil
+}
+
+// Resync the capacity model once the informer cache is synced then every clusterInfoResync until the context is done
+func (r *ClusterInfoReconciler) resyncPeriodically(ctx context.Context, cache cache.Cache) error {
+	if !cache.WaitForCacheSync(ctx) {
+		return fmt.Errorf("unable to sync the informer cache")
+	}
+	ticker := time.NewTicker(clusterInfoResync)
+	defer ticker.Stop()
+	for {
+		if err := r.resync(ctx); err != nil {
+			mcadLog.Error(err, "Unable to resync the capacity model")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Enqueue all ClusterInfo objects
+// Invoked once per trigger event, bursts of node and pod events are coalesced into one trigger event
+func (r *ClusterInfoReconciler) enqueueAll(ctx context.Context, q workqueue.RateLimitingInterface) {
+	clusterInfos := &mcadv1beta1.ClusterInfoList{}
+	if err := r.List(ctx, clusterInfos); err != nil {
+		mcadLog.Error(err, "Unable to list cluster info objects")
+		return
+	}
+	for _, clusterInfo := range clusterInfos.Items {
+		q.Add(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: clusterInfo.Namespace, Name: clusterInfo.Name}})
+	}
+}
+
+// Trigger the reconciliation of all ClusterInfo objects
+func (r *ClusterInfoReconciler) triggerReconcile() {
+	select {
+	case r.events <- event.GenericEvent{Object: &metav1.PartialObjectMetadata{}}:
+	default:
+		// do not block if event is already in channel
+	}
+}
+
+// Update capacity model from node events and trigger reconciliation if the model changed
+func (r *ClusterInfoReconciler) nodeHandler() handler.EventHandler {
+	return handler.Funcs{
+		CreateFunc: func(ctx context.Context, e event.CreateEvent, q workqueue.RateLimitingInterface) {
+			if r.model.updateNode(e.Object.(*v1.Node)) {
+				r.triggerReconcile()
+			}
+		},
+		UpdateFunc: func(ctx context.Context, e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+			if r.model.updateNode(e.ObjectNew.(*v1.Node)) {
+				r.triggerReconcile()
+			}
+		},
+		DeleteFunc: func(ctx context.Context, e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+			if r.model.deleteNode(e.Object.GetName()) {
+				r.triggerReconcile()
+			}
+		},
+	}
+}
+
+// Update capacity model from pod events and trigger reconciliation if the model changed
+func (r *ClusterInfoReconciler) podHandler() handler.EventHandler {
+	return handler.Funcs{
+		CreateFunc: func(ctx context.Context, e event.CreateEvent, q workqueue.RateLimitingInterface) {
+			if r.model.updatePod(e.Object.(*v1.Pod)) {
+				r.triggerReconcile()
+			}
+		},
+		UpdateFunc: func(ctx context.Context, e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+			if r.model.updatePod(e.ObjectNew.(*v1.Pod)) {
+				r.triggerReconcile()
+			}
+		},
+		DeleteFunc: func(ctx context.Context, e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+			if r.model.deletePod(e.Object.GetUID()) {
+				r.triggerReconcile()
+			}
+		},
+	}
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *ClusterInfoReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	// index pods with nodeName key
-	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &v1.Pod{}, specNodeName, func(obj client.Object) []string {
-		pod := obj.(*v1.Pod)
-		return []string{pod.Spec.NodeName}
-	}); err != nil {
+	r.model = newCapacityModel(reportedLabelKeys(r.TopologyKeys, r.NodeLabelKeys))
+	r.events = make(chan event.GenericEvent, 1)
+	r.reported = map[types.NamespacedName]reportedClusterInfo{}
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		return r.resyncPeriodically(ctx, mgr.GetCache())
+	})); err != nil {
 		return err
 	}
-
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&mcadv1beta1.ClusterInfo{}).
+		Watches(&v1.Node{}, r.nodeHandler()).
+		Watches(&v1.Pod{}, r.podHandler()).
+		WatchesRawSource(&source.Channel{Source: r.events}, handler.Funcs{
+			GenericFunc: func(ctx context.Context, e event.GenericEvent, q workqueue.RateLimitingInterface) {
+				r.enqueueAll(ctx, q)
+			},
+		}).
 		Complete(r)
 }
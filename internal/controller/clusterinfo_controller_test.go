@@ -0,0 +1,135 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+// Build a node info with the given CPU capacity and labels
+func clusterTestNode(name string, cpu string, labels map[string]string, taints ...v1.Taint) mcadv1beta1.NodeInfo {
+	return mcadv1beta1.NodeInfo{
+		Name:     name,
+		Labels:   labels,
+		Capacity: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)},
+		Taints:   taints,
+	}
+}
+
+func TestTopologyDomains(t *testing.T) {
+	const rack = "example.com/rack"
+	nodes := []mcadv1beta1.NodeInfo{
+		clusterTestNode("node-0", "4", map[string]string{nodeTestZone: "b", rack: "r1"}),
+		clusterTestNode("node-1", "2", map[string]string{nodeTestZone: "a", rack: "r1"}),
+		clusterTestNode("node-2", "8", map[string]string{nodeTestZone: "b"}),
+		clusterTestNode("node-3", "16", map[string]string{nodeTestZone: "a", rack: "r2"},
+			v1.Taint{Key: "dedicated", Effect: v1.TaintEffectNoSchedule}),
+	}
+	domains := topologyDomains([]string{nodeTestZone, rack}, nodes)
+	expected := []struct {
+		key, value string
+		nodes      int32
+		cpu        string
+	}{
+		{nodeTestZone, "a", 1, "2"},
+		{nodeTestZone, "b", 2, "12"},
+		{rack, "r1", 2, "6"},
+	}
+	if len(domains) != len(expected) {
+		t.Fatalf("topologyDomains() = %v, want %d domains", domains, len(expected))
+	}
+	for i, e := range expected {
+		d := domains[i]
+		if d.Key != e.key || d.Value != e.value || d.NodeCount != e.nodes || d.Capacity.Cpu().Cmp(resource.MustParse(e.cpu)) != 0 {
+			t.Errorf("domain %d = %s=%s with %d nodes and %v cpus, want %s=%s with %d nodes and %s cpus",
+				i, d.Key, d.Value, d.NodeCount, d.Capacity.Cpu(), e.key, e.value, e.nodes, e.cpu)
+		}
+	}
+}
+
+func TestReportedLabelKeys(t *testing.T) {
+	keys := reportedLabelKeys([]string{nodeTestZone, "example.com/rack"}, []string{"example.com/pool", nodeTestZone})
+	if fmt.Sprint(keys) != "[example.com/pool example.com/rack topology.kubernetes.io/zone]" {
+		t.Errorf("reportedLabelKeys() = %v, want sorted keys without duplicates", keys)
+	}
+}
+
+func TestClusterInfoReconcileWaitsForSync(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := mcadv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	clusterInfo := &mcadv1beta1.ClusterInfo{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cluster"}}
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(clusterInfo, modelTestNode("node-0", "4")).
+		WithStatusSubresource(&mcadv1beta1.ClusterInfo{}).
+		Build()
+	r := &ClusterInfoReconciler{
+		Client:   c,
+		model:    newCapacityModel(nil),
+		events:   make(chan event.GenericEvent, 1),
+		reported: map[types.NamespacedName]reportedClusterInfo{},
+	}
+	ctx := context.Background()
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "cluster"}}
+	getCapacity := func() *resource.Quantity {
+		if err := c.Get(ctx, req.NamespacedName, clusterInfo); err != nil {
+			t.Fatal(err)
+		}
+		return clusterInfo.Status.Capacity.Cpu()
+	}
+
+	// model not synced yet, status not published
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+	if cpu := getCapacity(); !cpu.IsZero() || !clusterInfo.Status.Time.IsZero() {
+		t.Errorf("status = %v before sync, want empty", clusterInfo.Status)
+	}
+
+	// first resync triggers the reconciliation
+	if err := r.resync(ctx); err != nil {
+		t.Fatalf("resync() = %v", err)
+	}
+	if len(r.events) != 1 {
+		t.Fatal("resync() did not trigger reconciliation")
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+	if cpu := getCapacity(); cpu.Cmp(resource.MustParse("4")) != 0 {
+		t.Errorf("capacity = %s after sync, want 4", cpu.String())
+	}
+}
@@ -19,6 +19,7 @@ package controller
 import (
 	"context"
 	"fmt"
+	"math"
 	"sort"
 	"strconv"
 	"strings"
@@ -26,15 +27,43 @@ import (
 
 	"gopkg.in/inf.v0"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// Resources reserved by a dispatched AppWrapper
+type reservation struct {
+	// dispatched AppWrapper (shallow copy, must be cloned if mutated)
+	appWrapper *mcadv1beta1.AppWrapper
+
+	// cached state and step of the AppWrapper
+	state mcadv1beta1.AppWrapperState
+	step  mcadv1beta1.AppWrapperStep
+
+	// effective priority of the AppWrapper
+	priority int
+
+	// reserved resources
+	request Weights
+
+	// expected completion time of the AppWrapper, zero if unknown
+	expectedEnd time.Time
+}
+
+// A running AppWrapper selected for preemption to make room for a queued AppWrapper
+type Preemption struct {
+	victim  *mcadv1beta1.AppWrapper // deep copy
+	message string                  // why the AppWrapper is preempted
+}
+
 type QueuingDecision struct {
-	reason  mcadv1beta1.AppWrapperQueuedReason
-	message string
+	reason            mcadv1beta1.AppWrapperQueuedReason
+	message           string
+	effectivePriority int
 }
 
 // Dec2float64 converts inf.Dec to float64
@@ -74,11 +103,74 @@ func assignedToCluster(aw mcadv1beta1.AppWrapper, cluster string) bool {
 	return aw.Labels != nil && aw.Labels[assignedClusterLabel] == cluster
 }
 
+// Compute the effective priority of an AppWrapper at a given time
+func effectivePriority(appWrapper *mcadv1beta1.AppWrapper, now time.Time) int {
+	return agedPriority(appWrapper, appWrapper.Spec.Priority, appWrapper.Spec.PrioritySlope, now)
+}
+
+// Compute the effective priority of a generic item at a given time
+// The item priority and priority slope default to the AppWrapper priority and priority slope
+func itemPriority(appWrapper *mcadv1beta1.AppWrapper, item int, now time.Time) int {
+	resource := appWrapper.Spec.Resources.GenericItems[item]
+	priority := appWrapper.Spec.Priority
+	if resource.Priority != nil {
+		priority = *resource.Priority
+	}
+	slope := appWrapper.Spec.PrioritySlope
+	if resource.PrioritySlope != nil {
+		slope = *resource.PrioritySlope
+	}
+	return agedPriority(appWrapper, priority, slope, now)
+}
+
+// Compute an effective priority for an AppWrapper at a given time
+// effective priority = priority + priority slope * seconds queued since creation or last requeuing
+// The time queued stops increasing once the AppWrapper is dispatched
+func agedPriority(appWrapper *mcadv1beta1.AppWrapper, basePriority int32, slope resource.Quantity, now time.Time) int {
+	priority := int64(basePriority)
+	if slope.IsZero() {
+		return int(priority)
+	}
+	queuedSince := appWrapper.CreationTimestamp.Time
+	if appWrapper.Status.RequeueTimestamp.After(queuedSince) {
+		queuedSince = appWrapper.Status.RequeueTimestamp.Time
+	}
+	queuedUntil := now
+	if appWrapper.Status.DispatchTimestamp.After(queuedSince) {
+		queuedUntil = appWrapper.Status.DispatchTimestamp.Time
+	}
+	seconds := int64(queuedUntil.Sub(queuedSince) / time.Second)
+	if seconds <= 0 {
+		return int(priority)
+	}
+	increment := new(inf.Dec).Mul(slope.AsDec(), inf.NewDec(seconds, 0))
+	increment.Round(increment, 0, inf.RoundFloor)
+	if delta, ok := increment.Unscaled(); ok && delta < math.MaxInt32 && delta > math.MinInt32 {
+		priority += delta
+	} else if increment.Sign() > 0 {
+		priority = math.MaxInt32
+	} else {
+		priority = math.MinInt32
+	}
+	// clamp to the range of int32 priorities
+	if priority > math.MaxInt32 {
+		priority = math.MaxInt32
+	} else if priority < math.MinInt32 {
+		priority = math.MinInt32
+	}
+	return int(priority)
+}
+
 // buildQueue returns a dispatch ordered queue of pending AppWrappers and the resources reserved by AppWrappers at every priority level.
 // AppWrappers in the returned queue must be cloned if mutated
-func (r *Dispatcher) buildQueue(ctx context.Context, appWrappers *mcadv1beta1.AppWrapperList, cluster string) (map[int]Weights, []*mcadv1beta1.AppWrapper, error) {
-	reserved := map[int]Weights{}        // total request per priority level
-	queue := []*mcadv1beta1.AppWrapper{} // queued appWrappers
+// Priorities are effective priorities at the given time
+// AppWrappers with the same effective priority are ordered using the queue order policy of the dispatcher
+// Reserved resources are also recorded per node in the node tracker and per AppWrapper in the returned reservations
+func (r *Dispatcher) buildQueue(ctx context.Context, appWrappers *mcadv1beta1.AppWrapperList, cluster string, capacity Weights, now time.Time, nodes *NodeTracker) (map[int]Weights, []*mcadv1beta1.AppWrapper, []*reservation, error) {
+	reserved := map[int]Weights{}         // total request per priority level
+	queue := []*mcadv1beta1.AppWrapper{}  // queued appWrappers
+	reservations := []*reservation{}      // reservations of dispatched appWrappers
+	released := map[types.UID]time.Time{} // time idle appWrappers released their resources if known
 
 	appWrapperCount := map[stateStepPriority]int{}
 
@@ -88,8 +180,8 @@ func (r *Dispatcher) buildQueue(ctx context.Context, appWrappers *mcadv1beta1.Ap
 		}
 
 		// get AppWrapper from cache if available as reconciler cache may be lagging
-		state, step := r.getCachedAW(&appWrapper)
-		priority := int(appWrapper.Spec.Priority)
+		state, step := r.getAccountedAW(&appWrapper)
+		priority := effectivePriority(&appWrapper, now)
 		key := stateStepPriority{state, step, priority}
 		if _, exists := appWrapperCount[key]; !exists {
 			appWrapperCount[key] = 0
@@ -99,25 +191,73 @@ func (r *Dispatcher) buildQueue(ctx context.Context, appWrappers *mcadv1beta1.Ap
 		if reserved[priority] == nil {
 			reserved[priority] = Weights{}
 		}
+		awRequests := aggregateRequestsByPriority(&appWrapper, now)
+		for p := range awRequests {
+			if reserved[p] == nil {
+				reserved[p] = Weights{}
+			}
+		}
+		if n := len(appWrapper.Status.Transitions); step == mcadv1beta1.Idle && n > 0 && appWrapper.Status.Transitions[n-1].Step == mcadv1beta1.Idle {
+			released[appWrapper.UID] = appWrapper.Status.Transitions[n-1].Time.Time // time of the transition to idle
+		}
 		if step != mcadv1beta1.Idle {
-			// use max request among AppWrapper request and total request of non-terminated AppWrapper pods
-			awRequest := aggregateRequests(&appWrapper)
-			podRequest := Weights{}
+			// use max request among AppWrapper request and total request of non-terminated AppWrapper pods at each item priority
+			podRequests := map[int]Weights{}
 			pods := &v1.PodList{}
 			if err := r.List(ctx, pods, client.UnsafeDisableDeepCopy,
 				client.MatchingLabels{namespaceLabel: appWrapper.Namespace, nameLabel: appWrapper.Name}); err != nil {
-				return nil, nil, err
+				return nil, nil, nil, err
 			}
 			for _, pod := range pods.Items {
 				if pod.Spec.NodeName != "" && pod.Status.Phase != v1.PodFailed && pod.Status.Phase != v1.PodSucceeded {
-					podRequest.Add(NewWeightsForPod(&pod))
+					// attribute pod to the priority of its generic item if known
+					p := priority
+					if i, err := strconv.Atoi(pod.Labels[itemLabel]); err == nil && i >= 0 && i < len(appWrapper.Spec.Resources.GenericItems) {
+						p = itemPriority(&appWrapper, i, now)
+					}
+					if podRequests[p] == nil {
+						podRequests[p] = Weights{}
+					}
+					if reserved[p] == nil {
+						reserved[p] = Weights{}
+					}
+					request := NewWeightsForPod(&pod)
+					podRequests[p].Add(request)
+					nodes.AddPlaced(pod.Spec.NodeName, p, request)
 				}
 			}
-			// compute max
-			awRequest.Max(podRequest)
-			reserved[int(appWrapper.Spec.Priority)].Add(awRequest)
+			for p := range podRequests {
+				if awRequests[p] == nil {
+					awRequests[p] = Weights{}
+				}
+			}
+			var tolerations podTolerations // only needed if the cluster has tainted nodes
+			if nodes.Tainted() {
+				tolerations = getPodTolerationsForAppWrapper(&appWrapper)
+			}
+			copy := appWrapper // must copy appWrapper before taking a reference, shallow copy ok
+			for p, awRequest := range awRequests {
+				// compute max
+				podRequest := podRequests[p]
+				awRequest.Max(podRequest)
+				reserved[p].Add(awRequest)
+				reservations = append(reservations, &reservation{
+					appWrapper:  &copy,
+					state:       state,
+					step:        step,
+					priority:    p,
+					request:     awRequest,
+					expectedEnd: expectedEnd(&appWrapper, now),
+				})
+				// record the part of the reservation that is not placed on a node yet
+				unplaced := awRequest.Clone()
+				unplaced.Sub(podRequest)
+				floating := Weights{}
+				floating.Max(unplaced) // drop negative quantities
+				nodes.AddFloating(appWrapper.Spec.Scheduling.NodeSelector, tolerations, p, floating)
+			}
 		} else if state == mcadv1beta1.Queued &&
-			time.Now().After(appWrapper.Status.RequeueTimestamp.Add(time.Duration(appWrapper.Spec.Scheduling.Requeuing.PauseTimeInSeconds)*time.Second)) {
+			now.After(appWrapper.Status.RequeueTimestamp.Add(time.Duration(appWrapper.Spec.Scheduling.Requeuing.PauseTimeInSeconds)*time.Second)) {
 			// add AppWrapper to queue of candidates to dispatch
 			copy := appWrapper // must copy appWrapper before taking a reference, shallow copy ok
 			queue = append(queue, &copy)
@@ -140,14 +280,37 @@ func (r *Dispatcher) buildQueue(ctx context.Context, appWrappers *mcadv1beta1.Ap
 	}
 	// propagate reservations at all priority levels to all levels below
 	assertPriorities(reserved)
-	// order AppWrapper queue based on priority and precedence (creation time)
+	// order AppWrapper queue based on effective priority, queue order policy, and precedence (creation time)
+	priorities := make(map[*mcadv1beta1.AppWrapper]int, len(queue))
+	for _, appWrapper := range queue {
+		priorities[appWrapper] = effectivePriority(appWrapper, now)
+	}
+	policy := r.QueueOrder
+	if policy == nil {
+		policy = &fifoQueueOrder{}
+	}
+	state := &QueueState{Capacity: capacity, Usage: map[string]Weights{}}
+	for _, reservation := range reservations {
+		namespace := reservation.appWrapper.Namespace
+		if state.Usage[namespace] == nil {
+			state.Usage[namespace] = Weights{}
+		}
+		state.Usage[namespace].Add(reservation.request)
+	}
+	if r.FairShare != nil {
+		r.FairShare.Record(cluster, reservations, released, now)
+		state.Scores = r.FairShare.Scores(cluster, capacity, now)
+	}
 	sort.Slice(queue, func(i, j int) bool {
-		if queue[i].Spec.Priority > queue[j].Spec.Priority {
+		if priorities[queue[i]] > priorities[queue[j]] {
 			return true
 		}
-		if queue[i].Spec.Priority < queue[j].Spec.Priority {
+		if priorities[queue[i]] < priorities[queue[j]] {
 			return false
 		}
+		if c := policy.Compare(queue[i], queue[j], state); c != 0 {
+			return c < 0
+		}
 		if queue[i].CreationTimestamp.Before(&queue[j].CreationTimestamp) {
 			return true
 		}
@@ -156,23 +319,145 @@ func (r *Dispatcher) buildQueue(ctx context.Context, appWrappers *mcadv1beta1.Ap
 		}
 		return queue[i].UID < queue[j].UID // break ties with UID to ensure total ordering
 	})
-	return reserved, queue, nil
+	return reserved, queue, reservations, nil
+}
+
+// Compute the expected completion time of a dispatched AppWrapper from its expected dispatch duration
+// Return zero time if no expected dispatch duration is specified
+func expectedEnd(appWrapper *mcadv1beta1.AppWrapper, now time.Time) time.Time {
+	expected := appWrapper.Spec.Scheduling.DispatchDuration.Expected
+	if expected <= 0 {
+		return time.Time{}
+	}
+	start := now // not accepted by the runner yet
+	if appWrapper.Status.DispatchTimestamp.After(appWrapper.Status.RequeueTimestamp.Time) {
+		start = appWrapper.Status.DispatchTimestamp.Time
+	}
+	end := start.Add(time.Duration(expected) * time.Second)
+	if end.Before(now) {
+		return now // overrunning AppWrapper is expected to complete any time now
+	}
+	return end
+}
+
+// Estimate when a request at a given priority will fit given available resources
+// by accumulating the resources released by dispatched AppWrappers at the same or higher priorities
+// in the order of their expected completion times
+// Return zero time if the request is not expected to fit based on known completion times
+func estimateFitTime(request Weights, available Weights, priority int, reservations []*reservation) time.Time {
+	releases := []*reservation{}
+	for _, r := range reservations {
+		if r.priority >= priority && !r.expectedEnd.IsZero() {
+			releases = append(releases, r)
+		}
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].expectedEnd.Before(releases[j].expectedEnd)
+	})
+	avail := available.Clone()
+	for _, r := range releases {
+		avail.Add(r.request)
+		if fits, _ := request.Fits(avail); fits {
+			return r.expectedEnd
+		}
+	}
+	return time.Time{}
+}
+
+// Capacity reserved for the first blocked AppWrapper at a priority level when backfilling
+// AppWrappers queued behind at the same priority may only be dispatched ahead
+// if they are expected to complete before the blocked AppWrapper is expected to fit
+// or if they only use capacity not needed by the blocked AppWrapper at that time
+type headReservation struct {
+	// blocked AppWrapper
+	appWrapper *mcadv1beta1.AppWrapper
+
+	// time when the blocked AppWrapper is expected to fit, zero if unknown
+	shadow time.Time
+
+	// capacity not needed by the blocked AppWrapper at shadow time
+	extra Weights
+}
+
+// Reserve capacity for a blocked AppWrapper based on the expected completion times of dispatched AppWrappers
+func newHeadReservation(appWrapper *mcadv1beta1.AppWrapper, request Weights, available Weights, priority int, reservations []*reservation, shadow time.Time) *headReservation {
+	extra := available.Clone()
+	if !shadow.IsZero() {
+		for _, r := range reservations {
+			if r.priority >= priority && !r.expectedEnd.IsZero() && !r.expectedEnd.After(shadow) {
+				extra.Add(r.request)
+			}
+		}
+	}
+	extra.Sub(request)
+	return &headReservation{appWrapper: appWrapper, shadow: shadow, extra: extra}
+}
+
+// Check if an AppWrapper with the given request and expected completion time may be dispatched ahead
+func (head *headReservation) admits(request Weights, end time.Time) bool {
+	if !head.shadow.IsZero() && !end.IsZero() && !end.After(head.shadow) {
+		return true
+	}
+	fits, _ := request.Fits(head.extra)
+	return fits
+}
+
+// Record an AppWrapper dispatched ahead of the blocked AppWrapper
+func (head *headReservation) backfill(request Weights, end time.Time) {
+	if head.shadow.IsZero() || end.IsZero() || end.After(head.shadow) {
+		head.extra.Sub(request) // still running at shadow time
+	}
 }
 
-// Find next AppWrappers to dispatch in queue order
-func (r *Dispatcher) selectForDispatch(ctx context.Context, quotatracker *QuotaTracker) ([]*mcadv1beta1.AppWrapper, error) {
+// Find next AppWrappers to dispatch in queue order and running AppWrappers to preempt if preemption is enabled
+func (r *Dispatcher) selectForDispatch(ctx context.Context, quotatracker *QuotaTracker) ([]*mcadv1beta1.AppWrapper, []*Preemption, error) {
 	allClusters := &mcadv1beta1.ClusterInfoList{}
 	if err := r.List(ctx, allClusters); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	allAppWrappers := &mcadv1beta1.AppWrapperList{}
 	if err := r.List(ctx, allAppWrappers, client.UnsafeDisableDeepCopy); err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	// account for the requests of the pod templates in strict demand mode
+	declared := map[types.UID]*mcadv1beta1.AppWrapperSpec{} // declared specs of adjusted AppWrappers
+	if r.StrictDemand {
+		listed := map[types.UID]bool{}
+		for i := range allAppWrappers.Items {
+			listed[allAppWrappers.Items[i].UID] = true
+			if adjusted, _ := r.cachedStrictDemand(&allAppWrappers.Items[i]); adjusted != &allAppWrappers.Items[i] {
+				spec := allAppWrappers.Items[i].Spec // copy before overwriting item, shallow copy ok as adjusted spec is a deep copy
+				declared[adjusted.UID] = &spec
+				allAppWrappers.Items[i] = *adjusted
+			}
+		}
+		for uid := range r.demands {
+			if !listed[uid] {
+				delete(r.demands, uid) // forget deleted AppWrappers
+			}
+		}
 	}
 	selected := []*mcadv1beta1.AppWrapper{}
-	logThisDispatch := time.Now().After(r.NextLoggedDispatch)
+	preemptions := []*Preemption{}
+	preempted := map[types.UID]bool{} // AppWrappers selected for preemption
+	// build quota tree from quota nodes and dispatched AppWrappers in all clusters
+	quotaNodes := &mcadv1beta1.QuotaNodeList{}
+	if err := r.List(ctx, quotaNodes); err != nil {
+		return nil, nil, err
+	}
+	quotaTree := NewQuotaTree(quotaNodes.Items)
+	for i := range allAppWrappers.Items {
+		appWrapper := &allAppWrappers.Items[i]
+		if state, step := r.getAccountedAW(appWrapper); step != mcadv1beta1.Idle {
+			quotaTree.AddAppWrapper(appWrapper, state, step)
+		}
+	}
+	r.updateQuotaNodes(ctx, quotaTree) // publish the quota used by dispatched AppWrappers before selecting more
+
+	now := time.Now() // compute all effective priorities at the same time
+	logThisDispatch := now.After(r.NextLoggedDispatch)
 	if logThisDispatch {
-		r.NextLoggedDispatch = time.Now().Add(clusterInfoTimeout)
+		r.NextLoggedDispatch = now.Add(clusterInfoTimeout)
 	}
 
 	// For each cluster, make dispatching decisions
@@ -181,9 +466,10 @@ func (r *Dispatcher) selectForDispatch(ctx context.Context, quotatracker *QuotaT
 		if logThisDispatch {
 			mcadLog.Info("Total capacity", "cluster", cluster.Name, "capacity", capacity)
 		}
-		requests, queue, err := r.buildQueue(ctx, allAppWrappers, cluster.Name)
+		nodes := NewNodeTracker(&cluster)
+		requests, queue, reservations, err := r.buildQueue(ctx, allAppWrappers, cluster.Name, capacity, now, nodes)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		// compute available cluster capacity at each priority level
 		// available cluster capacity = total capacity reported in cluster info - capacity reserved by AppWrappers
@@ -204,56 +490,305 @@ func (r *Dispatcher) selectForDispatch(ctx context.Context, quotatracker *QuotaT
 			}
 			mcadLog.Info("Queue", "cluster", cluster.Name, "queue", pretty)
 		}
+		// capacity reserved for the first blocked AppWrapper at each priority if backfilling
+		heads := map[int]*headReservation{}
 		// compute ordered slice of AppWrappers that fit on the cluster (may be empty)
 		for _, appWrapper := range queue {
-			request := aggregateRequests(appWrapper)
-			// get resourceQuota in AppWrapper namespace, if any
+			priority := effectivePriority(appWrapper, now)
+			requests := aggregateRequestsByPriority(appWrapper, now)
+			// get resourceQuotas in AppWrapper namespace, if any
 			resourceQuotas := &v1.ResourceQuotaList{}
 			namespace := appWrapper.GetNamespace()
 			if err := r.List(ctx, resourceQuotas, client.UnsafeDisableDeepCopy,
 				&client.ListOptions{Namespace: namespace}); err != nil {
-				return nil, err
+				return nil, nil, err
+			}
+			// check every resourceQuota matching the AppWrapper pods
+			var objectCounts Weights
+			if len(resourceQuotas.Items) > 0 {
+				objectCounts = getObjectCountsForAppWrapper(r.RESTMapper(), appWrapper, nil)
 			}
 			quotaFits := true
-			var appWrapperAskWeights *WeightsPair
+			quotaBlocker := "" // quota object with insufficient quota if any
+			appWrapperAskWeights := make([]*WeightsPair, len(resourceQuotas.Items))
 			insufficientResources := []v1.ResourceName{}
-			if len(resourceQuotas.Items) > 0 {
-				appWrapperAskWeights = getWeightsPairForAppWrapper(appWrapper)
-				// assuming only one resourceQuota per nameSpace
-				quotaFits, insufficientResources = quotatracker.Satisfies(appWrapperAskWeights, &resourceQuotas.Items[0])
+			for i := range resourceQuotas.Items {
+				resourceQuota := &resourceQuotas.Items[i]
+				appWrapperAskWeights[i] = getWeightsPairForAppWrapperInQuota(appWrapper, resourceQuota, objectCounts)
+				if quotaFits, insufficientResources = quotatracker.Satisfies(appWrapperAskWeights[i], resourceQuota); !quotaFits {
+					quotaBlocker = "resource quota " + resourceQuota.Name
+					break
+				}
+			}
+			quotaTreeBlocks := false // does the quota tree block the AppWrapper?
+			if quotaFits {
+				var quotaNode string
+				quotaFits, quotaNode, insufficientResources = quotaTree.Fits(appWrapper)
+				quotaBlocker = "quota node " + quotaNode
+				quotaTreeBlocks = !quotaFits
+			}
+			// check the requests of the items at each item priority level from the highest down
+			// the request at a given level includes the requests at all levels above
+			fits := true
+			var gaps []v1.ResourceName
+			request := Weights{} // cumulative request down to the level being checked
+			level := priority    // level being checked
+			selector := appWrapper.Spec.Scheduling.NodeSelector
+			topologyKey := appWrapper.Spec.Scheduling.TopologyKey
+			// account for the capacity of the tainted nodes tolerated by the pod templates
+			var tolerations podTolerations // only needed if the cluster has tainted nodes
+			awAvailable := available       // capacity available to the AppWrapper at each priority level
+			if len(cluster.Status.TaintedCapacity) > 0 || nodes.Tainted() {
+				tolerations = getPodTolerationsForAppWrapper(appWrapper)
+				if extra := toleratedCapacity(&cluster, tolerations); len(extra) > 0 {
+					awAvailable = make(map[int]Weights, len(available))
+					for p, avail := range available {
+						awAvailable[p] = avail.Clone()
+						awAvailable[p].Add(extra)
+					}
+				}
+			}
+			var selectorMsg string        // explain why the request does not fit on the nodes matching the node selector
+			var fragmentedMsg string      // explain why the pods cannot be placed on the nodes
+			var placements []podPlacement // placement of the pods on the nodes if per-node capacity is known
+			for _, p := range decreasingPriorities(requests) {
+				request.Add(requests[p])
+				level = p
+				if fits, gaps = request.Fits(awAvailable[p]); !fits {
+					break
+				}
+				if len(selector) > 0 && nodes.Known() {
+					// check the request against the nodes matching the node selector only
+					selectorAvailable, matches := nodes.Available(selector, tolerations, p)
+					if matches == 0 {
+						fits = false
+						selectorMsg = fmt.Sprintf("No schedulable node matches node selector %v. ", selector)
+						break
+					} else if fits, gaps = request.Fits(selectorAvailable); !fits {
+						var msgBuilder strings.Builder
+						for _, resource := range gaps {
+							msgBuilder.WriteString(
+								fmt.Sprintf("Insufficient %v on nodes matching node selector %v; requested %v but only %v available. ", resource, selector, request[resource], selectorAvailable[resource]),
+							)
+						}
+						selectorMsg = msgBuilder.String()
+						break
+					}
+				}
+				if topologyKey != "" {
+					// place the pods at this level or above on the nodes of a single topology domain
+					if !nodes.Known() {
+						fits = false
+						fragmentedMsg = fmt.Sprintf("Per-node capacity is unknown, cannot place pods within one %v domain. ", topologyKey)
+						break
+					}
+					if len(nodes.DomainValues(selector, tolerations, topologyKey)) == 0 {
+						fits = false
+						selectorMsg = fmt.Sprintf("No schedulable node matching node selector %v has topology label %v. ", selector, topologyKey)
+						break
+					}
+					if placements, _ = nodes.PlaceInDomain(selector, tolerations, topologyKey, p, podSetsByPriority(appWrapper, p, now)); placements == nil {
+						fits = false
+						fragmentedMsg = fmt.Sprintf("Requests fit in aggregate but no %v domain has room for all the pods. ", topologyKey)
+						break
+					}
+				} else if nodes.Known() {
+					// place the pods at this level or above on the nodes
+					var unplaced *podSet
+					var count int32
+					if placements, unplaced, count = nodes.Place(selector, tolerations, p, podSetsByPriority(appWrapper, p, now)); unplaced != nil {
+						fits = false
+						fragmentedMsg = fmt.Sprintf("Requests fit in aggregate but %d pods requesting %v each do not fit on any node", count, unplaced.request)
+						if len(selector) > 0 {
+							fragmentedMsg += fmt.Sprintf(" matching node selector %v", selector)
+						}
+						fragmentedMsg += ". "
+						break
+					}
+				}
+			}
+			var head *headReservation // head reservation preventing AppWrapper from jumping ahead if any
+			if fits && heads[priority] != nil && !heads[priority].admits(request, expectedEnd(appWrapper, now)) {
+				head = heads[priority]
+			}
+			if fits && head == nil && quotaTreeBlocks && r.Preemption && !r.MultiClusterMode {
+				// requeue AppWrappers borrowing quota if the AppWrapper is entitled to it and would be dispatched otherwise
+				victims := quotaTree.Reclaim(appWrapper)
+				for _, victim := range victims {
+					preempted[victim.UID] = true
+					message := fmt.Sprintf("quota reclaimed by %s/%s", appWrapper.Namespace, appWrapper.Name)
+					preemptions = append(preemptions, &Preemption{victim: victim.DeepCopy(), message: message})
+				}
+				if len(victims) > 0 {
+					var quotaNode string
+					quotaFits, quotaNode, insufficientResources = quotaTree.Fits(appWrapper)
+					quotaBlocker = "quota node " + quotaNode
+				}
 			}
-			fits, gaps := request.Fits(available[int(appWrapper.Spec.Priority)])
 			if fits {
 				// check if appwrapper passes resource quota (if any)
-				if quotaFits {
-					quotatracker.Allocate(namespace, appWrapperAskWeights)
-					selected = append(selected, appWrapper.DeepCopy()) // deep copy AppWrapper
-					for priority, avail := range available {
-						if priority <= int(appWrapper.Spec.Priority) {
-							avail.Sub(request)
+				if quotaFits && head != nil {
+					message := fmt.Sprintf("Held back to reserve capacity for %s/%s. ", head.appWrapper.Namespace, head.appWrapper.Name)
+					if !head.shadow.IsZero() {
+						message += fmt.Sprintf("Expected to be dispatched after %v. ", head.shadow.UTC().Format(time.RFC3339))
+					}
+					r.Decisions[appWrapper.UID] = &QueuingDecision{reason: mcadv1beta1.QueuedInsufficientResources, message: message, effectivePriority: priority}
+				} else if quotaFits {
+					if heads[priority] != nil {
+						heads[priority].backfill(request, expectedEnd(appWrapper, now))
+					}
+					for i := range resourceQuotas.Items {
+						quotatracker.Allocate(&resourceQuotas.Items[i], appWrapperAskWeights[i])
+					}
+					quotaTree.Allocate(appWrapper)
+					copy := appWrapper.DeepCopy() // deep copy AppWrapper
+					copy.Status.Allocated = allocatedPods(appWrapper, placements)
+					if spec, ok := declared[appWrapper.UID]; ok {
+						copy.Spec = *spec.DeepCopy()                     // do not persist adjusted demand
+						copy.Status.Allocated = allocatedPods(copy, nil) // declared pods, not the extra pods of the adjusted demand
+					}
+					copy.Status.EffectivePriority = int32(priority)
+					selected = append(selected, copy)
+					if r.Preemption && !r.MultiClusterMode {
+						// preempt lower-priority AppWrappers if the request does not fit in the unreserved capacity
+						total := Weights{}
+						for _, request := range requests {
+							total.Add(request)
+						}
+						for _, victim := range selectVictims(total, capacity, priority, reservations, preempted, now) {
+							preempted[victim.UID] = true
+							quotaTree.Release(victim)
+							message := fmt.Sprintf("preempted by %s/%s", appWrapper.Namespace, appWrapper.Name)
+							preemptions = append(preemptions, &Preemption{victim: victim.DeepCopy(), message: message})
 						}
 					}
+					for p, avail := range available {
+						for q, request := range requests {
+							if p <= q {
+								avail.Sub(request)
+							}
+						}
+					}
+					if placements != nil {
+						nodes.AddPlacements(placements)
+					}
+					for q, request := range requests {
+						if placements == nil {
+							nodes.AddFloating(selector, tolerations, q, request)
+						}
+						reservations = append(reservations, &reservation{
+							appWrapper:  appWrapper,
+							state:       mcadv1beta1.Running,
+							step:        mcadv1beta1.Dispatching,
+							priority:    q,
+							request:     request,
+							expectedEnd: expectedEnd(appWrapper, now),
+						})
+					}
 				} else {
 					var msgBuilder strings.Builder
 					for _, resource := range insufficientResources {
-						msgBuilder.WriteString(fmt.Sprintf("Insufficient %v. ", resource))
+						msgBuilder.WriteString(fmt.Sprintf("Insufficient %v in %v. ", resource, quotaBlocker))
 					}
-					r.Decisions[appWrapper.UID] = &QueuingDecision{reason: mcadv1beta1.QueuedInsufficientQuota, message: msgBuilder.String()}
+					r.Decisions[appWrapper.UID] = &QueuingDecision{reason: mcadv1beta1.QueuedInsufficientQuota, message: msgBuilder.String(), effectivePriority: priority}
 				}
+			} else if selectorMsg != "" {
+				r.Decisions[appWrapper.UID] = &QueuingDecision{reason: mcadv1beta1.QueuedInsufficientResources, message: selectorMsg, effectivePriority: priority}
+			} else if fragmentedMsg != "" {
+				r.Decisions[appWrapper.UID] = &QueuingDecision{reason: mcadv1beta1.QueuedFragmented, message: fragmentedMsg, effectivePriority: priority}
 			} else {
 				var msgBuilder strings.Builder
 				for _, resource := range gaps {
 					msgBuilder.WriteString(
-						fmt.Sprintf("Insufficient %v; requested %v but only %v available. ", resource, request[resource], available[int(appWrapper.Spec.Priority)][resource]),
+						fmt.Sprintf("Insufficient %v; requested %v but only %v available. ", resource, request[resource], awAvailable[level][resource]),
 					)
 
 				}
-				r.Decisions[appWrapper.UID] = &QueuingDecision{reason: mcadv1beta1.QueuedInsufficientResources, message: msgBuilder.String()}
+				fitTime := estimateFitTime(request, awAvailable[level], level, reservations)
+				if !fitTime.IsZero() {
+					msgBuilder.WriteString(fmt.Sprintf("Expected to fit by %v based on expected dispatch durations. ", fitTime.UTC().Format(time.RFC3339)))
+				}
+				if r.Backfill && heads[priority] == nil {
+					// reserve capacity for the first blocked AppWrapper at this priority
+					heads[priority] = newHeadReservation(appWrapper, request, awAvailable[level], level, reservations, fitTime)
+				}
+				r.Decisions[appWrapper.UID] = &QueuingDecision{reason: mcadv1beta1.QueuedInsufficientResources, message: msgBuilder.String(), effectivePriority: priority}
 			}
 		}
 	}
 
-	return selected, nil
+	return selected, preemptions, nil
+}
+
+// Select a minimal set of lower-priority AppWrappers to preempt so that a request fits in the unreserved capacity
+// Only AppWrappers in the Running/Created state past their preemption grace period are candidates
+// Resources reserved by AppWrappers in a deleting step or already selected for preemption are expected to be released
+// Return no AppWrappers if preemption cannot make room for the request
+func selectVictims(request Weights, capacity Weights, priority int, reservations []*reservation, preempted map[types.UID]bool, now time.Time) []*mcadv1beta1.AppWrapper {
+	free := capacity.Clone()
+	candidates := map[types.UID]Weights{}       // total reservations of candidate AppWrappers
+	appWrappers := map[types.UID]*reservation{} // one reservation per candidate AppWrapper
+	for _, r := range reservations {
+		uid := r.appWrapper.UID
+		if r.step == mcadv1beta1.Deleting || r.step == mcadv1beta1.Deleted || preempted[uid] {
+			continue // pending release
+		}
+		free.Sub(r.request)
+		if r.state == mcadv1beta1.Running && r.step == mcadv1beta1.Created &&
+			effectivePriority(r.appWrapper, now) < priority &&
+			now.After(r.appWrapper.Status.DispatchTimestamp.Add(time.Duration(r.appWrapper.Spec.Scheduling.PreemptionGracePeriodInSeconds)*time.Second)) {
+			if candidates[uid] == nil {
+				candidates[uid] = Weights{}
+				appWrappers[uid] = r
+			}
+			candidates[uid].Add(r.request)
+		}
+	}
+	if fits, _ := request.Fits(free); fits {
+		return nil
+	}
+	// order candidates by increasing priority then decreasing dispatch time to minimize lost work
+	order := make([]types.UID, 0, len(candidates))
+	for uid := range candidates {
+		order = append(order, uid)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		pi := effectivePriority(appWrappers[order[i]].appWrapper, now)
+		pj := effectivePriority(appWrappers[order[j]].appWrapper, now)
+		if pi != pj {
+			return pi < pj
+		}
+		ti := appWrappers[order[i]].appWrapper.Status.DispatchTimestamp
+		tj := appWrappers[order[j]].appWrapper.Status.DispatchTimestamp
+		if !ti.Equal(&tj) {
+			return tj.Before(&ti)
+		}
+		return order[i] < order[j]
+	})
+	// greedily select victims until the request fits
+	victims := []types.UID{}
+	fits := false
+	for _, uid := range order {
+		free.Add(candidates[uid])
+		victims = append(victims, uid)
+		if fits, _ = request.Fits(free); fits {
+			break
+		}
+	}
+	if !fits {
+		return nil
+	}
+	// prune victims that are not needed for the request to fit, starting with the first selected
+	minimal := []*mcadv1beta1.AppWrapper{}
+	for _, uid := range victims {
+		free.Sub(candidates[uid])
+		if fits, _ := request.Fits(free); fits {
+			continue // not needed
+		}
+		free.Add(candidates[uid])
+		minimal = append(minimal, appWrappers[uid].appWrapper)
+	}
+	return minimal
 }
 
 // Aggregate requests
@@ -267,6 +802,56 @@ func aggregateRequests(appWrapper *mcadv1beta1.AppWrapper) Weights {
 	return request
 }
 
+// Aggregate requests per effective item priority
+func aggregateRequestsByPriority(appWrapper *mcadv1beta1.AppWrapper, now time.Time) map[int]Weights {
+	requests := map[int]Weights{}
+	for i, r := range appWrapper.Spec.Resources.GenericItems {
+		priority := itemPriority(appWrapper, i, now)
+		if requests[priority] == nil {
+			requests[priority] = Weights{}
+		}
+		for _, cpr := range r.CustomPodResources {
+			requests[priority].AddProd(cpr.Replicas, NewWeights(cpr.Requests))
+		}
+	}
+	return requests
+}
+
+// Count the pods of each generic item placed on nodes
+// Count the pods declared by the custom pod resources if the pods were not placed, e.g., if per-node capacity is unknown
+func allocatedPods(appWrapper *mcadv1beta1.AppWrapper, placements []podPlacement) []int32 {
+	allocated := make([]int32, len(appWrapper.Spec.Resources.GenericItems))
+	if placements != nil {
+		for _, placement := range placements {
+			allocated[placement.item]++
+		}
+		return allocated
+	}
+	for i, r := range appWrapper.Spec.Resources.GenericItems {
+		for _, cpr := range r.CustomPodResources {
+			allocated[i] += cpr.Replicas
+		}
+	}
+	return allocated
+}
+
+// Collect the custom pod resources of the items with an effective priority at or above a given level as pod sets
+// Pod sets carry the node constraints of their pod templates
+func podSetsByPriority(appWrapper *mcadv1beta1.AppWrapper, level int, now time.Time) []podSet {
+	podSets := []podSet{}
+	for i, r := range appWrapper.Spec.Resources.GenericItems {
+		priority := itemPriority(appWrapper, i, now)
+		if priority < level {
+			continue
+		}
+		constraints := getNodeConstraintsForItem(appWrapper, i)
+		for j, cpr := range r.CustomPodResources {
+			podSets = append(podSets, podSet{item: i, priority: priority, count: cpr.Replicas, request: NewWeights(cpr.Requests), constraints: constraints[j]})
+		}
+	}
+	return podSets
+}
+
 // Aggregate limits
 func aggregateLimits(appWrapper *mcadv1beta1.AppWrapper) Weights {
 	limit := Weights{}
@@ -280,14 +865,20 @@ func aggregateLimits(appWrapper *mcadv1beta1.AppWrapper) Weights {
 
 // Propagate reservations at all priority levels to all levels below
 func assertPriorities(w map[int]Weights) {
+	keys := decreasingPriorities(w)
+	for i := 1; i < len(keys); i++ {
+		w[keys[i]].Add(w[keys[i-1]])
+	}
+}
+
+// Return the priority levels of a map in decreasing order
+func decreasingPriorities(w map[int]Weights) []int {
 	keys := make([]int, len(w))
 	i := 0
 	for k := range w {
 		keys[i] = k
 		i += 1
 	}
-	sort.Ints(keys)
-	for i := len(keys) - 1; i > 0; i-- {
-		w[keys[i-1]].Add(w[keys[i]])
-	}
+	sort.Sort(sort.Reverse(sort.IntSlice(keys)))
+	return keys
 }
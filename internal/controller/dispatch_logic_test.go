@@ -0,0 +1,354 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+// Creation time of the first AppWrapper of a test, later AppWrappers are created one second apart
+var dispatchTestEpoch = time.Now().Add(-time.Hour)
+
+// Build a static REST mapper for the namespaced kinds wrapped by test AppWrappers
+func dispatchTestRESTMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	for _, gvk := range []schema.GroupVersionKind{
+		{Version: "v1", Kind: "ConfigMap"},
+		{Version: "v1", Kind: "PersistentVolumeClaim"},
+		{Version: "v1", Kind: "Pod"},
+		{Version: "v1", Kind: "Service"},
+		{Group: "batch", Version: "v1", Kind: "Job"},
+		{Group: PodGroupXK8s, Version: "v1alpha1", Kind: "PodGroup"},
+	} {
+		mapper.Add(gvk, meta.RESTScopeNamespace)
+	}
+	return mapper
+}
+
+// Build a dispatcher backed by a fake client holding the given objects
+func dispatchTestDispatcher(t *testing.T, objs ...client.Object) *Dispatcher {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := mcadv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithRESTMapper(dispatchTestRESTMapper()).
+		WithObjects(objs...).
+		WithStatusSubresource(&mcadv1beta1.AppWrapper{}, &mcadv1beta1.ClusterInfo{}, &mcadv1beta1.QuotaNode{}).
+		Build()
+	return &Dispatcher{
+		AppWrapperReconciler: AppWrapperReconciler{Client: c, Scheme: scheme, Cache: map[types.UID]*CachedAppWrapper{}},
+		Decisions:            map[types.UID]*QueuingDecision{},
+		NextLoggedDispatch:   time.Now().Add(time.Hour), // do not log dispatch decisions
+	}
+}
+
+// Build a cluster info object reporting the given aggregate CPU capacity
+func dispatchTestCluster(cpu string) *mcadv1beta1.ClusterInfo {
+	return &mcadv1beta1.ClusterInfo{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cluster"},
+		Status:     mcadv1beta1.ClusterInfoStatus{Capacity: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}},
+	}
+}
+
+// Build a cluster info object reporting the given nodes, the aggregate capacity is the capacity of the untainted nodes
+func dispatchTestNodeCluster(labelKeys []string, nodes ...mcadv1beta1.NodeInfo) *mcadv1beta1.ClusterInfo {
+	capacity := Weights{}
+	for _, node := range nodes {
+		if len(node.Taints) == 0 {
+			capacity.Add(NewWeights(node.Capacity))
+		}
+	}
+	cluster := dispatchTestCluster("0")
+	cluster.Status.Capacity = capacity.AsResources()
+	cluster.Status.Nodes = nodes
+	cluster.Status.NodeLabelKeys = labelKeys
+	cluster.Status.TaintedCapacity = taintedCapacity(nodes)
+	return cluster
+}
+
+// Build a queued AppWrapper wrapping a pod requesting the given CPUs
+// The index determines the creation timestamp
+func dispatchTestAppWrapper(name string, index int, cpu string) *mcadv1beta1.AppWrapper {
+	raw := fmt.Sprintf(`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": %q}, "spec": {"containers": [
+		{"name": "busybox", "image": "busybox", "resources": {"requests": {"cpu": %q}}}]}}`, name, cpu)
+	return &mcadv1beta1.AppWrapper{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "default",
+			Name:              name,
+			UID:               types.UID(name),
+			CreationTimestamp: metav1.NewTime(dispatchTestEpoch.Add(time.Duration(index) * time.Second)),
+		},
+		Spec: mcadv1beta1.AppWrapperSpec{Resources: mcadv1beta1.AppWrapperResources{GenericItems: []mcadv1beta1.GenericItem{{
+			GenericTemplate: runtime.RawExtension{Raw: []byte(raw)},
+			CustomPodResources: []mcadv1beta1.CustomPodResource{
+				{Replicas: 1, Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}},
+			},
+		}}}},
+		Status: mcadv1beta1.AppWrapperStatus{State: mcadv1beta1.Queued, Step: mcadv1beta1.Idle},
+	}
+}
+
+// Mark an AppWrapper as dispatched at the given time
+func dispatchTestRunning(appWrapper *mcadv1beta1.AppWrapper, dispatched time.Time) *mcadv1beta1.AppWrapper {
+	appWrapper.Status.State = mcadv1beta1.Running
+	appWrapper.Status.Step = mcadv1beta1.Created
+	appWrapper.Status.DispatchTimestamp = metav1.NewTime(dispatched)
+	return appWrapper
+}
+
+// Set the expected dispatch duration of an AppWrapper
+func dispatchTestExpected(appWrapper *mcadv1beta1.AppWrapper, seconds int32) *mcadv1beta1.AppWrapper {
+	appWrapper.Spec.Scheduling.DispatchDuration.Expected = seconds
+	return appWrapper
+}
+
+// Run one dispatch cycle and return the names of the selected AppWrappers in order
+func dispatchTestSelect(t *testing.T, r *Dispatcher) []string {
+	selected, _, err := r.selectForDispatch(context.Background(), NewQuotaTracker())
+	if err != nil {
+		t.Fatalf("selectForDispatch() = %v", err)
+	}
+	names := []string{}
+	for _, appWrapper := range selected {
+		names = append(names, appWrapper.Name)
+	}
+	return names
+}
+
+func TestSelectForDispatchFitTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected int32 // expected dispatch duration of the running AppWrapper in seconds, none if zero
+		fitTime  bool  // is the queued AppWrapper expected to fit at some time?
+	}{
+		{name: "running AppWrapper with expected dispatch duration", expected: 600, fitTime: true},
+		{name: "running AppWrapper without expected dispatch duration"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			running := dispatchTestRunning(dispatchTestAppWrapper("running", 0, "2"), time.Now())
+			if tt.expected > 0 {
+				dispatchTestExpected(running, tt.expected)
+			}
+			r := dispatchTestDispatcher(t, dispatchTestCluster("4"), running, dispatchTestAppWrapper("aw", 1, "4"))
+			if selected := dispatchTestSelect(t, r); len(selected) != 0 {
+				t.Errorf("selected %v, want none", selected)
+			}
+			decision := r.Decisions["aw"]
+			if decision == nil || decision.reason != mcadv1beta1.QueuedInsufficientResources {
+				t.Fatalf("decision = %+v, want %v", decision, mcadv1beta1.QueuedInsufficientResources)
+			}
+			if fitTime := strings.Contains(decision.message, "Expected to fit by"); fitTime != tt.fitTime {
+				t.Errorf("decision message %q reports fit time = %v, want %v", decision.message, fitTime, tt.fitTime)
+			}
+		})
+	}
+}
+
+func TestSelectForDispatchBackfill(t *testing.T) {
+	tests := []struct {
+		name     string
+		backfill bool
+		capacity string
+		running  string // CPUs of a running AppWrapper expected to complete in 10 minutes
+		head     string // CPUs of the blocked AppWrapper at the head of the queue
+		small    string // CPUs of the AppWrapper queued behind the head
+		expected int32  // expected dispatch duration of the small AppWrapper in seconds, none if zero
+		selected []string
+		heldBack bool // is the small AppWrapper held back by the head?
+	}{
+		{
+			name: "disabled", capacity: "4", running: "2", head: "4", small: "2",
+			selected: []string{"small"},
+		},
+		{
+			name: "small AppWrapper completing before head fits", backfill: true, capacity: "4", running: "2", head: "4", small: "2", expected: 300,
+			selected: []string{"small"},
+		},
+		{
+			name: "small AppWrapper completing after head fits", backfill: true, capacity: "4", running: "2", head: "4", small: "2", expected: 3600,
+			selected: []string{}, heldBack: true,
+		},
+		{
+			name: "small AppWrapper without expected duration", backfill: true, capacity: "4", running: "2", head: "4", small: "2",
+			selected: []string{}, heldBack: true,
+		},
+		{
+			name: "small AppWrapper using capacity not needed by head", backfill: true, capacity: "6", running: "4", head: "4", small: "1", expected: 3600,
+			selected: []string{"small"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			running := dispatchTestExpected(dispatchTestRunning(dispatchTestAppWrapper("running", 0, tt.running), time.Now()), 600)
+			small := dispatchTestAppWrapper("small", 2, tt.small)
+			if tt.expected > 0 {
+				dispatchTestExpected(small, tt.expected)
+			}
+			r := dispatchTestDispatcher(t, dispatchTestCluster(tt.capacity), running, dispatchTestAppWrapper("head", 1, tt.head), small)
+			r.Backfill = tt.backfill
+			if selected := dispatchTestSelect(t, r); fmt.Sprint(selected) != fmt.Sprint(tt.selected) {
+				t.Errorf("selected %v, want %v", selected, tt.selected)
+			}
+			decision := r.Decisions["small"]
+			if heldBack := decision != nil && strings.Contains(decision.message, "Held back to reserve capacity for default/head"); heldBack != tt.heldBack {
+				t.Errorf("small AppWrapper held back = %v, want %v (decision %+v)", heldBack, tt.heldBack, decision)
+			}
+			if decision := r.Decisions["head"]; decision == nil || decision.reason != mcadv1beta1.QueuedInsufficientResources {
+				t.Errorf("head decision = %+v, want %v", decision, mcadv1beta1.QueuedInsufficientResources)
+			}
+		})
+	}
+}
+
+func TestSelectForDispatchTopology(t *testing.T) {
+	zoneA := map[string]string{nodeTestZone: "a"}
+	zoneB := map[string]string{nodeTestZone: "b"}
+	tests := []struct {
+		name    string
+		cluster *mcadv1beta1.ClusterInfo
+		reason  mcadv1beta1.AppWrapperQueuedReason // expected queuing reason, selected if empty
+		message string
+	}{
+		{
+			name: "room within one domain",
+			cluster: dispatchTestNodeCluster([]string{nodeTestZone},
+				clusterTestNode("node-0", "2", zoneA), clusterTestNode("node-1", "2", zoneB), clusterTestNode("node-2", "2", zoneB)),
+		},
+		{
+			name: "room across domains only",
+			cluster: dispatchTestNodeCluster([]string{nodeTestZone},
+				clusterTestNode("node-0", "2", zoneA), clusterTestNode("node-1", "2", zoneB)),
+			reason:  mcadv1beta1.QueuedFragmented,
+			message: "no topology.kubernetes.io/zone domain has room for all the pods",
+		},
+		{
+			name:    "per-node capacity unknown",
+			cluster: dispatchTestCluster("8"),
+			reason:  mcadv1beta1.QueuedFragmented,
+			message: "Per-node capacity is unknown",
+		},
+		{
+			name: "no node with topology label",
+			cluster: dispatchTestNodeCluster([]string{nodeTestZone},
+				clusterTestNode("node-0", "4", nil), clusterTestNode("node-1", "4", nil)),
+			reason:  mcadv1beta1.QueuedInsufficientResources,
+			message: "has topology label topology.kubernetes.io/zone",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appWrapper := dispatchTestAppWrapper("aw", 0, "2")
+			appWrapper.Spec.Resources.GenericItems[0].CustomPodResources[0].Replicas = 2
+			appWrapper.Spec.Scheduling.TopologyKey = nodeTestZone
+			r := dispatchTestDispatcher(t, tt.cluster, appWrapper)
+			selected := dispatchTestSelect(t, r)
+			if tt.reason == "" {
+				if len(selected) != 1 {
+					t.Errorf("selected %v, want [aw] (decision %+v)", selected, r.Decisions["aw"])
+				}
+				return
+			}
+			if len(selected) != 0 {
+				t.Errorf("selected %v, want none", selected)
+			}
+			if decision := r.Decisions["aw"]; decision == nil || decision.reason != tt.reason || !strings.Contains(decision.message, tt.message) {
+				t.Errorf("decision = %+v, want %v with message containing %q", decision, tt.reason, tt.message)
+			}
+		})
+	}
+}
+
+func TestSelectForDispatchAllocated(t *testing.T) {
+	tests := []struct {
+		name     string
+		cluster  *mcadv1beta1.ClusterInfo
+		strict   bool
+		template string // CPU request of the pod template of the first item
+	}{
+		{name: "aggregate capacity", cluster: dispatchTestCluster("8"), template: "1"},
+		{
+			name:     "per-node capacity",
+			cluster:  dispatchTestNodeCluster(nil, clusterTestNode("node-0", "4", nil), clusterTestNode("node-1", "4", nil)),
+			template: "1",
+		},
+		{name: "strict demand", cluster: dispatchTestCluster("8"), strict: true, template: "3"},
+		{
+			name:     "strict demand with per-node capacity",
+			cluster:  dispatchTestNodeCluster(nil, clusterTestNode("node-0", "4", nil), clusterTestNode("node-1", "4", nil)),
+			strict:   true,
+			template: "3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// two pods of the first item and one pod of the second item
+			appWrapper := strictTestAppWrapper(tt.template, "1")
+			appWrapper.Spec.Resources.GenericItems[0].CustomPodResources[0].Replicas = 2
+			appWrapper.Spec.Resources.GenericItems = append(appWrapper.Spec.Resources.GenericItems,
+				dispatchTestAppWrapper("aw-1", 0, "1").Spec.Resources.GenericItems[0])
+			r := dispatchTestDispatcher(t, tt.cluster, appWrapper)
+			r.StrictDemand = tt.strict
+			selected, _, err := r.selectForDispatch(context.Background(), NewQuotaTracker())
+			if err != nil {
+				t.Fatalf("selectForDispatch() = %v", err)
+			}
+			if len(selected) != 1 {
+				t.Fatalf("selected %d AppWrappers, want 1 (decision %+v)", len(selected), r.Decisions["aw"])
+			}
+			if allocated := selected[0].Status.Allocated; fmt.Sprint(allocated) != "[2 1]" {
+				t.Errorf("allocated = %v, want [2 1]", allocated)
+			}
+		})
+	}
+}
+
+func TestAllocatedPods(t *testing.T) {
+	appWrapper := dispatchTestAppWrapper("aw", 0, "1")
+	appWrapper.Spec.Resources.GenericItems = append(appWrapper.Spec.Resources.GenericItems,
+		webhookTestItem(webhookTestConfigMap), dispatchTestAppWrapper("aw-2", 0, "1").Spec.Resources.GenericItems[0])
+	appWrapper.Spec.Resources.GenericItems[2].CustomPodResources[0].Replicas = 3
+	if allocated := allocatedPods(appWrapper, nil); fmt.Sprint(allocated) != "[1 0 3]" {
+		t.Errorf("allocatedPods() = %v without placements, want declared pods [1 0 3]", allocated)
+	}
+	placements := []podPlacement{{node: "node-0", item: 2}, {node: "node-1", item: 2}, {node: "node-0", item: 0}}
+	if allocated := allocatedPods(appWrapper, placements); fmt.Sprint(allocated) != "[1 0 2]" {
+		t.Errorf("allocatedPods() = %v, want placed pods [1 0 2]", allocated)
+	}
+}
@@ -47,6 +47,12 @@ type Dispatcher struct {
 	Decisions          map[types.UID]*QueuingDecision // transient log of queuing decisions to enable recording in AppWrapper Status
 	Events             chan event.GenericEvent        // event channel to trigger dispatch
 	NextLoggedDispatch time.Time                      // when next to log dispatching decisions
+	Preemption         bool                           // preempt lower-priority AppWrappers to dispatch higher-priority AppWrappers
+	Backfill           bool                           // reserve capacity for blocked AppWrappers and only backfill around them
+	QueueOrder         QueueOrderPolicy               // order of queued AppWrappers with the same priority, FIFO if nil
+	FairShare          *FairShareTracker              // tracker of decayed usage per namespace, nil if disabled
+	StrictDemand       bool                           // use the max of declared and pod template requests as demand
+	demands            map[types.UID]*cachedDemand    // cache of strict demands, initialized on first use
 }
 
 const (
@@ -122,19 +128,31 @@ func (r *Dispatcher) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resu
 				return ctrl.Result{}, err
 			}
 		}
+		// imported legacy AppWrappers dispatched by the legacy controller keep running
+		if !r.MultiClusterMode && legacyDispatched(appWrapper) {
+			appWrapper.Status.DispatchTimestamp = metav1.Now()
+			appWrapper.Status.Allocated = allocatedPods(appWrapper, nil)
+			return r.updateStatus(ctx, appWrapper, mcadv1beta1.Running, mcadv1beta1.Created, "imported running legacy AppWrapper")
+		}
 		// set queued/idle status only after adding finalizers
 		return r.updateStatus(ctx, appWrapper, mcadv1beta1.Queued, mcadv1beta1.Idle)
 
 	case mcadv1beta1.Queued:
+		// Flag discrepancies between declared and inferred demand in strict demand mode
+		changed := r.StrictDemand && r.setDemandDiscrepancy(appWrapper)
 		// Propagate most recent queuing decision to AppWrapper's Queued Condition
-		if decision, ok := r.Decisions[appWrapper.UID]; ok {
+		decision, ok := r.Decisions[appWrapper.UID]
+		if ok {
 			meta.SetStatusCondition(&appWrapper.Status.Conditions, metav1.Condition{
 				Type:    string(mcadv1beta1.Queued),
 				Status:  metav1.ConditionTrue,
 				Reason:  string(decision.reason),
 				Message: decision.message,
 			})
-			if r.Status().Update(ctx, appWrapper) == nil {
+			appWrapper.Status.EffectivePriority = int32(decision.effectivePriority)
+		}
+		if ok || changed {
+			if r.Status().Update(ctx, appWrapper) == nil && ok {
 				// If successfully propagated, remove from in memory map
 				delete(r.Decisions, appWrapper.UID)
 			}
@@ -176,17 +194,25 @@ func (r *Dispatcher) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resu
 				}
 			}
 
-			// reset status to queued/idle
-			appWrapper.Status.Restarts += 1
+			// reset status to queued/idle, preemptions do not count as restarts
+			preemption := r.lastPreemption(appWrapper)
+			if preemption == nil {
+				appWrapper.Status.Restarts += 1
+			}
 			appWrapper.Status.RequeueTimestamp = metav1.Now() // overwrite requeue decision time (runner clock) with completion time (dispatcher clock)
+			reason := mcadv1beta1.QueuedRequeue
 			msg := "Requeued by MCAD"
-			if decision, ok := r.Decisions[appWrapper.UID]; ok && decision.reason == mcadv1beta1.QueuedRequeue {
+			if decision, ok := r.Decisions[appWrapper.UID]; ok && (decision.reason == mcadv1beta1.QueuedRequeue || decision.reason == mcadv1beta1.QueuedPreempted) {
+				reason = decision.reason
 				msg = fmt.Sprintf("Requeued because %s", decision.message)
+			} else if preemption != nil {
+				reason = mcadv1beta1.QueuedPreempted
+				msg = fmt.Sprintf("Requeued because %s", preemption.Reason)
 			}
 			meta.SetStatusCondition(&appWrapper.Status.Conditions, metav1.Condition{
 				Type:    string(mcadv1beta1.Queued),
 				Status:  metav1.ConditionTrue,
-				Reason:  string(mcadv1beta1.QueuedRequeue),
+				Reason:  string(reason),
 				Message: msg,
 			})
 			res, err := r.updateStatus(ctx, appWrapper, mcadv1beta1.Queued, mcadv1beta1.Idle)
@@ -247,15 +273,39 @@ func (r *Dispatcher) dispatch(ctx context.Context) (ctrl.Result, error) {
 	// track quota allocation to AppWrappers during a dispatching cycle;
 	// used in only one cycle, does not carry from cycle to cycle
 	quotaTracker := NewQuotaTracker()
-	if weightsPairMap, err := r.getUnadmittedPodsWeights(ctx); err == nil {
-		quotaTracker.Init(weightsPairMap)
+	if inFlight, err := r.getInFlightAppWrappers(ctx); err == nil {
+		quotaTracker.Init(inFlight)
 	}
 	// find dispatch candidates according to priorities, precedence, and available resources
-	selectedAppWrappers, err := r.selectForDispatch(ctx, quotaTracker)
+	selectedAppWrappers, preemptions, err := r.selectForDispatch(ctx, quotaTracker)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
+	// Preempt one by one until either exhausted victims or hit an error
+	for _, preemption := range preemptions {
+		appWrapper := preemption.victim
+		// append appWrapper ID to logger
+		ctx := withAppWrapper(ctx, appWrapper)
+		// skip victim if reconciler cache is stale, the victim may already be releasing its resources
+		if r.isStale(ctx, appWrapper) {
+			log.FromContext(ctx).Info("Skipping stale preemption victim")
+			continue
+		}
+		// check state again to be extra safe
+		if appWrapper.Status.State != mcadv1beta1.Running || appWrapper.Status.Step != mcadv1beta1.Created {
+			log.FromContext(ctx).Info("Skipping preemption victim no longer running")
+			continue
+		}
+		reason := preemption.message
+		r.Decisions[appWrapper.UID] = &QueuingDecision{reason: mcadv1beta1.QueuedPreempted, message: reason}
+		// request deletion of wrapped resources
+		appWrapper.Status.RequeueTimestamp = metav1.Now()
+		if _, err := r.updateStatus(ctx, appWrapper, mcadv1beta1.Running, mcadv1beta1.Deleting, reason); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
 	// Dispatch one by one until either exhausted candidates or hit an error
 	for _, appWrapper := range selectedAppWrappers {
 		// append appWrapper ID to logger
@@ -283,6 +333,21 @@ func (r *Dispatcher) dispatch(ctx context.Context) (ctrl.Result, error) {
 	return ctrl.Result{RequeueAfter: dispatchDelay}, nil
 }
 
+// Return the transition of the AppWrapper to the deleting step of the running state if made by the dispatcher, i.e., a preemption
+// The runner makes all the other transitions to this step
+func (r *Dispatcher) lastPreemption(appWrapper *mcadv1beta1.AppWrapper) *mcadv1beta1.AppWrapperTransition {
+	for i := len(appWrapper.Status.Transitions) - 1; i >= 0; i-- {
+		transition := &appWrapper.Status.Transitions[i]
+		if transition.State == mcadv1beta1.Running && transition.Step == mcadv1beta1.Deleting {
+			if transition.Controller == r.ControllerName {
+				return transition
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
 func (r *Dispatcher) createBindingPolicy(ctx context.Context, appWrapper *mcadv1beta1.AppWrapper) error {
 	bindingPolicy := ksv1alpha1.BindingPolicy{}
 	namespacedName := types.NamespacedName{
@@ -367,39 +432,42 @@ func (r *Dispatcher) deleteBindingPolicy(ctx context.Context, appWrapper *mcadv1
 	return nil
 }
 
-// Calculate resource demands of pods for appWrappers that have been dispatched but haven't
-// passed through ResourceQuota admission controller yet (approximated by resources not created yet)
-func (r *Dispatcher) getUnadmittedPodsWeights(ctx context.Context) (map[string]*WeightsPair, error) {
+// Collect the appWrappers that have been dispatched but whose pods may not have passed through
+// the ResourceQuota admission controller yet (approximated by resources not created yet)
+func (r *Dispatcher) getInFlightAppWrappers(ctx context.Context) (map[string][]*inFlightAppWrapper, error) {
 	appWrappers := &mcadv1beta1.AppWrapperList{}
 	if err := r.List(ctx, appWrappers, client.UnsafeDisableDeepCopy); err != nil {
 		return nil, err
 	}
-	weightsPairMap := make(map[string]*WeightsPair)
-	for _, appWrapper := range appWrappers.Items {
-		_, step := r.getCachedAW(&appWrapper)
+	inFlightMap := make(map[string][]*inFlightAppWrapper)
+	for i := range appWrappers.Items {
+		appWrapper := &appWrappers.Items[i]
+		_, step := r.getAccountedAW(appWrapper)
 		if step != mcadv1beta1.Idle {
+			if r.StrictDemand {
+				appWrapper, _ = r.cachedStrictDemand(appWrapper)
+			}
 			namespace := appWrapper.GetNamespace()
-			weightsPair := weightsPairMap[namespace]
-			if weightsPair == nil {
-				weightsPair = NewWeightsPair(Weights{}, Weights{})
+			inFlight := &inFlightAppWrapper{appWrapper: appWrapper}
+			if step == mcadv1beta1.Dispatching || step == mcadv1beta1.Accepting || step == mcadv1beta1.Creating {
+				// count objects not created yet
+				var exists func(client.Object) bool
+				if step == mcadv1beta1.Creating {
+					// skip objects already created, hence admitted by the ResourceQuota admission controller
+					exists = func(obj client.Object) bool {
+						return r.Get(ctx, client.ObjectKeyFromObject(obj), obj.DeepCopyObject().(client.Object)) == nil
+					}
+				}
+				inFlight.objectCounts = getObjectCountsForAppWrapper(r.RESTMapper(), appWrapper, exists)
 			}
-			weightsPair.Add(getWeightsPairForAppWrapper(&appWrapper))
-
-			// subtract weights for admitted (created) pods for this appWrapper
-			// (already accounted for in the used status of the resourceQuota)
+			// admitted (created) pods for this appWrapper
 			pods := &v1.PodList{}
 			if err := r.List(ctx, pods, client.UnsafeDisableDeepCopy,
 				client.MatchingLabels{namespaceLabel: namespace, nameLabel: appWrapper.Name}); err == nil {
-				createdPodsWeightsPair := &WeightsPair{requests: Weights{}, limits: Weights{}}
-				for _, pod := range pods.Items {
-					createdPodsWeightsPair.Add(NewWeightsPairForPod(&pod))
-				}
-				weightsPair.QuotaSub(createdPodsWeightsPair)
+				inFlight.pods = pods.Items
 			}
-			nonNegativeWeightsPair := NewWeightsPair(Weights{}, Weights{})
-			nonNegativeWeightsPair.Max(weightsPair)
-			weightsPairMap[namespace] = nonNegativeWeightsPair
+			inFlightMap[namespace] = append(inFlightMap[namespace], inFlight)
 		}
 	}
-	return weightsPairMap, nil
+	return inFlightMap, nil
 }
@@ -0,0 +1,203 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"math"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// A tracker of the resources consumed by the dispatched AppWrappers of each namespace in each cluster
+// Usage is measured in resource-seconds from dispatch to completion and decays exponentially over time
+// The tracker is in memory only, the usage history is lost when the dispatcher restarts
+type FairShareTracker struct {
+	// time for the recorded usage to decay by half
+	halfLife time.Duration
+
+	// decayed resource-seconds per namespace per cluster
+	usage map[string]map[string]map[v1.ResourceName]float64
+
+	// time of last decay
+	last time.Time
+
+	// progress of the recording of the usage of each dispatched AppWrapper
+	recorded map[types.UID]*usageRecord
+
+	// namespaces with an exported score per cluster
+	exported map[string]map[string]bool
+}
+
+// Progress of the recording of the usage of a dispatched AppWrapper
+type usageRecord struct {
+	// cluster the AppWrapper is dispatched to
+	cluster string
+
+	// namespace of the AppWrapper
+	namespace string
+
+	// total request of the AppWrapper at the last recording
+	request Weights
+
+	// time up to which the usage of the AppWrapper is recorded
+	until time.Time
+}
+
+// Create a new FairShareTracker with the given half-life
+func NewFairShareTracker(halfLife time.Duration) *FairShareTracker {
+	return &FairShareTracker{
+		halfLife: halfLife,
+		usage:    map[string]map[string]map[v1.ResourceName]float64{},
+		recorded: map[types.UID]*usageRecord{},
+		exported: map[string]map[string]bool{},
+	}
+}
+
+// Decay the recorded usage up to the given time
+func (tracker *FairShareTracker) decay(now time.Time) {
+	if tracker.last.IsZero() {
+		tracker.last = now
+		return
+	}
+	if !now.After(tracker.last) {
+		return
+	}
+	factor := math.Pow(0.5, float64(now.Sub(tracker.last))/float64(tracker.halfLife))
+	for cluster, namespaces := range tracker.usage {
+		for namespace, usage := range namespaces {
+			total := 0.0
+			for resource := range usage {
+				usage[resource] *= factor
+				total += usage[resource]
+			}
+			if total < 1e-9 {
+				delete(namespaces, namespace) // forget namespaces with negligible usage
+			}
+		}
+		if len(namespaces) == 0 {
+			delete(tracker.usage, cluster)
+		}
+	}
+	tracker.last = now
+}
+
+// Add the usage of a request held from start to end to the usage of a namespace in a cluster
+func (tracker *FairShareTracker) add(cluster string, namespace string, request Weights, start time.Time, end time.Time) {
+	if !end.After(start) {
+		return
+	}
+	seconds := end.Sub(start).Seconds()
+	if tracker.usage[cluster] == nil {
+		tracker.usage[cluster] = map[string]map[v1.ResourceName]float64{}
+	}
+	if tracker.usage[cluster][namespace] == nil {
+		tracker.usage[cluster][namespace] = map[v1.ResourceName]float64{}
+	}
+	for resource, quantity := range request {
+		if q, err := Dec2float64(quantity); err == nil && q > 0 {
+			tracker.usage[cluster][namespace][resource] += q * seconds
+		}
+	}
+}
+
+// Record the usage of the AppWrappers dispatched to a cluster up to the given time
+// Released maps the AppWrappers of this cluster that no longer hold resources to the time they released them
+// The usage of the AppWrappers previously dispatched to this cluster and no longer accepted by the runner is recorded
+// up to their release or requeuing time, or up to the given time if unknown, e.g., for deleted AppWrappers,
+// then these AppWrappers are no longer tracked
+func (tracker *FairShareTracker) Record(cluster string, reservations []*reservation, released map[types.UID]time.Time, now time.Time) {
+	tracker.decay(now)
+	// compute the interval to record and the total request of each AppWrapper before recording any reservation
+	since := map[types.UID]time.Time{}
+	records := map[types.UID]*usageRecord{}
+	requeued := map[types.UID]time.Time{} // AppWrappers requeued since their last dispatch
+	for _, r := range reservations {
+		appWrapper := r.appWrapper
+		if record, ok := records[appWrapper.UID]; ok {
+			record.request.Add(r.request)
+			continue
+		}
+		// skip AppWrappers not accepted by the runner yet
+		if !appWrapper.Status.DispatchTimestamp.After(appWrapper.Status.RequeueTimestamp.Time) {
+			requeued[appWrapper.UID] = appWrapper.Status.RequeueTimestamp.Time
+			continue
+		}
+		start := appWrapper.Status.DispatchTimestamp.Time
+		if record, ok := tracker.recorded[appWrapper.UID]; ok && record.until.After(start) {
+			start = record.until
+		}
+		since[appWrapper.UID] = start
+		records[appWrapper.UID] = &usageRecord{cluster: cluster, namespace: appWrapper.Namespace, request: r.request.Clone(), until: now}
+	}
+	for uid, record := range records {
+		tracker.add(cluster, record.namespace, record.request, since[uid], now)
+	}
+	// flush the usage of AppWrappers no longer holding resources
+	for uid, record := range tracker.recorded {
+		if _, ok := records[uid]; ok || record.cluster != cluster {
+			continue
+		}
+		end := now
+		if t, ok := released[uid]; ok && t.Before(end) {
+			end = t
+		}
+		if t, ok := requeued[uid]; ok && t.Before(end) {
+			end = t
+		}
+		tracker.add(cluster, record.namespace, record.request, record.until, end)
+		delete(tracker.recorded, uid)
+	}
+	for uid, record := range records {
+		tracker.recorded[uid] = record
+	}
+}
+
+// Compute the fair-share score of each namespace with a recorded usage in a cluster and update the corresponding metrics
+// The score of a namespace is the max across resources of the decayed usage divided by the cluster capacity,
+// i.e., the number of seconds the namespace would have used the entire capacity for the dominant resource
+// Only the metrics of the given cluster are updated, metrics of forgotten namespaces are deleted
+func (tracker *FairShareTracker) Scores(cluster string, capacity Weights, now time.Time) map[string]float64 {
+	tracker.decay(now)
+	scores := map[string]float64{}
+	for namespace, usage := range tracker.usage[cluster] {
+		score := 0.0
+		for resource, u := range usage {
+			c, ok := capacity[resource]
+			if !ok || c.Sign() <= 0 {
+				continue
+			}
+			if fc, err := Dec2float64(c); err == nil && u/fc > score {
+				score = u / fc
+			}
+		}
+		scores[namespace] = score
+		fairShareScore.WithLabelValues(cluster, namespace).Set(score)
+	}
+	for namespace := range tracker.exported[cluster] {
+		if _, ok := scores[namespace]; !ok {
+			fairShareScore.DeleteLabelValues(cluster, namespace)
+		}
+	}
+	exported := make(map[string]bool, len(scores))
+	for namespace := range scores {
+		exported[namespace] = true
+	}
+	tracker.exported[cluster] = exported
+	return scores
+}
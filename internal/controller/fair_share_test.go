@@ -0,0 +1,150 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+// Build the reservation of an AppWrapper of the given namespace dispatched at the given time
+func fairShareTestReservation(name string, namespace string, dispatched time.Time, request v1.ResourceList) *reservation {
+	appWrapper := dispatchTestRunning(dispatchTestAppWrapper(name, 0, "1"), dispatched)
+	appWrapper.Namespace = namespace
+	return &reservation{appWrapper: appWrapper, state: mcadv1beta1.Running, step: mcadv1beta1.Created, request: NewWeights(request)}
+}
+
+// Build CPU weights
+func fairShareTestCPU(cpu string) v1.ResourceList {
+	return v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}
+}
+
+// Check a score up to rounding errors and negligible decay
+func fairShareTestScore(t *testing.T, scores map[string]float64, namespace string, expected float64) {
+	t.Helper()
+	if score, ok := scores[namespace]; !ok || math.Abs(score-expected) > 1e-3*math.Max(1, expected) {
+		t.Errorf("score of namespace %q = %v (found %v), want %v", namespace, score, ok, expected)
+	}
+}
+
+func TestFairShareTrackerDecay(t *testing.T) {
+	tests := []struct {
+		name    string
+		elapsed time.Duration // time elapsed since the last recording
+		score   float64
+	}{
+		{name: "no decay", score: 20},
+		{name: "one half-life", elapsed: time.Hour, score: 10},
+		{name: "two half-lives", elapsed: 2 * time.Hour, score: 5},
+		{name: "forgotten usage", elapsed: 100 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := dispatchTestEpoch
+			now := start.Add(100 * time.Second)
+			tracker := NewFairShareTracker(time.Hour)
+			tracker.Record("cluster", []*reservation{fairShareTestReservation("aw", "a", start, fairShareTestCPU("2"))}, nil, now)
+			scores := tracker.Scores("cluster", NewWeights(fairShareTestCPU("10")), now.Add(tt.elapsed))
+			if tt.score == 0 {
+				if len(scores) != 0 {
+					t.Errorf("scores = %v, want usage forgotten", scores)
+				}
+				return
+			}
+			fairShareTestScore(t, scores, "a", tt.score)
+		})
+	}
+}
+
+func TestFairShareTrackerScores(t *testing.T) {
+	start := dispatchTestEpoch
+	now := start.Add(100 * time.Second)
+	tracker := NewFairShareTracker(1000 * time.Hour)
+	tracker.Record("cluster", []*reservation{
+		fairShareTestReservation("aw-0", "a", start, v1.ResourceList{v1.ResourceCPU: resource.MustParse("2"), nvidiaGpu: resource.MustParse("1")}),
+		fairShareTestReservation("aw-1", "b", start, fairShareTestCPU("2")),
+		fairShareTestReservation("aw-2", "c", start, v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Gi")}),
+	}, nil, now)
+	tracker.Record("other", []*reservation{fairShareTestReservation("aw-3", "d", start, fairShareTestCPU("2"))}, nil, now)
+
+	capacity := NewWeights(v1.ResourceList{v1.ResourceCPU: resource.MustParse("10"), nvidiaGpu: resource.MustParse("4")})
+	scores := tracker.Scores("cluster", capacity, now)
+	if len(scores) != 3 {
+		t.Errorf("scores = %v, want scores of the namespaces of the cluster", scores)
+	}
+	fairShareTestScore(t, scores, "a", 25) // dominant resource is GPU: 100 GPU-seconds over 4 GPUs
+	fairShareTestScore(t, scores, "b", 20)
+	fairShareTestScore(t, scores, "c", 0) // no capacity for memory
+	fairShareTestScore(t, tracker.Scores("other", capacity, now), "d", 20)
+}
+
+func TestFairShareTrackerRecord(t *testing.T) {
+	start := dispatchTestEpoch
+	tests := []struct {
+		name     string
+		second   []*reservation          // reservations at the second recording, 300s after dispatch
+		released map[types.UID]time.Time // released AppWrappers at the second recording
+		score    float64                 // expected CPU-seconds over a capacity of 1 CPU
+		tracked  bool                    // AppWrapper still tracked after the second recording
+	}{
+		{
+			name:    "running AppWrapper",
+			second:  []*reservation{fairShareTestReservation("aw", "a", start, fairShareTestCPU("2"))},
+			score:   600,
+			tracked: true,
+		},
+		{
+			name:     "completed AppWrapper",
+			released: map[types.UID]time.Time{"aw": start.Add(150 * time.Second)},
+			score:    300,
+		},
+		{
+			name:  "deleted AppWrapper",
+			score: 600,
+		},
+		{
+			name: "requeued AppWrapper dispatched again",
+			second: []*reservation{func() *reservation {
+				r := fairShareTestReservation("aw", "a", start.Add(250*time.Second), fairShareTestCPU("2"))
+				r.appWrapper.Status.RequeueTimestamp = metav1.NewTime(start.Add(120 * time.Second))
+				r.appWrapper.Status.DispatchTimestamp = metav1.NewTime(start.Add(100 * time.Second)) // not accepted by the runner yet
+				return r
+			}()},
+			score: 240,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewFairShareTracker(1000 * time.Hour)
+			first := []*reservation{fairShareTestReservation("aw", "a", start, fairShareTestCPU("2"))}
+			tracker.Record("cluster", first, nil, start.Add(100*time.Second))
+			tracker.Record("cluster", tt.second, tt.released, start.Add(300*time.Second))
+			fairShareTestScore(t, tracker.Scores("cluster", NewWeights(fairShareTestCPU("1")), start.Add(300*time.Second)), "a", tt.score)
+			if _, ok := tracker.recorded["aw"]; ok != tt.tracked {
+				t.Errorf("AppWrapper tracked = %v after second recording, want %v", ok, tt.tracked)
+			}
+		})
+	}
+}
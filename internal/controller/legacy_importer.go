@@ -0,0 +1,179 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+const (
+	legacyImportedAnnotation = "workload.codeflare.dev/imported"     // annotation marking imported legacy AppWrappers
+	legacyStateAnnotation    = "workload.codeflare.dev/legacy-state" // annotation recording the state of the legacy AppWrapper
+	legacyUIDAnnotation      = "workload.codeflare.dev/legacy-uid"   // annotation recording the UID of the legacy AppWrapper
+)
+
+// Group version kind of legacy AppWrappers
+var legacyAppWrapperGVK = schema.GroupVersionKind{Group: "mcad.ibm.com", Version: "v1beta1", Kind: "AppWrapper"}
+
+// States of legacy AppWrappers that are not imported
+var legacyTerminalStates = map[string]bool{
+	"Completed": true,
+	"Failed":    true,
+	"Deleted":   true,
+}
+
+// States of legacy AppWrappers dispatched by the legacy controller
+var legacyDispatchedStates = map[string]bool{
+	"Running":               true,
+	"RunningHoldCompletion": true,
+}
+
+// LegacyImporter creates an AppWrapper for each legacy mcad.ibm.com/v1beta1 AppWrapper
+// The new AppWrapper has the same namespace and name and records the UID of the legacy AppWrapper in an annotation
+// The new AppWrapper is not owned by the legacy AppWrapper so that deleting the legacy AppWrapper does not delete it
+type LegacyImporter struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// permission to watch and annotate legacy appwrappers
+
+//+kubebuilder:rbac:groups=mcad.ibm.com,resources=appwrappers,verbs=get;list;watch;update;patch
+
+// Import a legacy AppWrapper
+func (r *LegacyImporter) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	legacy := &unstructured.Unstructured{}
+	legacy.SetGroupVersionKind(legacyAppWrapperGVK)
+	if err := r.Get(ctx, req.NamespacedName, legacy); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !legacy.GetDeletionTimestamp().IsZero() || legacy.GetAnnotations()[legacyImportedAnnotation] != "" {
+		return ctrl.Result{}, nil
+	}
+	state, _, _ := unstructured.NestedString(legacy.Object, "status", "state")
+	if legacyTerminalStates[state] {
+		log.Info("Skipping legacy AppWrapper", "state", state)
+		return ctrl.Result{}, nil
+	}
+	appWrapper, err := ConvertLegacyAppWrapper(legacy)
+	if err != nil {
+		log.Error(err, "Conversion error")
+		return ctrl.Result{}, nil // do not retry
+	}
+	if err := r.Create(ctx, appWrapper); err != nil && !apierrors.IsAlreadyExists(err) {
+		return ctrl.Result{}, err
+	}
+	// mark legacy AppWrapper as imported
+	annotations := legacy.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[legacyImportedAnnotation] = appWrapper.Namespace + "/" + appWrapper.Name
+	legacy.SetAnnotations(annotations)
+	if err := r.Update(ctx, legacy); err != nil {
+		return ctrl.Result{}, err
+	}
+	log.Info("Imported legacy AppWrapper")
+	return ctrl.Result{}, nil
+}
+
+// Convert a legacy mcad.ibm.com/v1beta1 AppWrapper into a new AppWrapper with the same namespace, name, labels, and annotations
+// Legacy fields with a counterpart in AppWrapperSpec are preserved, other fields are dropped
+// Allocations recorded by the legacy controller are reset and pod templates are labeled like by the runner
+// The state and UID of the legacy AppWrapper if any are recorded in annotations
+func ConvertLegacyAppWrapper(legacy *unstructured.Unstructured) (*mcadv1beta1.AppWrapper, error) {
+	appWrapper := &mcadv1beta1.AppWrapper{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   legacy.GetNamespace(),
+			Name:        legacy.GetName(),
+			Labels:      legacy.GetLabels(),
+			Annotations: map[string]string{},
+		},
+	}
+	for k, v := range legacy.GetAnnotations() {
+		if k != "kubectl.kubernetes.io/last-applied-configuration" {
+			appWrapper.Annotations[k] = v
+		}
+	}
+	if state, ok, _ := unstructured.NestedString(legacy.Object, "status", "state"); ok && state != "" {
+		appWrapper.Annotations[legacyStateAnnotation] = state
+	}
+	if uid := legacy.GetUID(); uid != "" {
+		appWrapper.Annotations[legacyUIDAnnotation] = string(uid)
+	}
+	spec, ok, err := unstructured.NestedMap(legacy.Object, "spec")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if items, ok, _ := unstructured.NestedSlice(spec, "resources", "GenericItems"); ok {
+			for i, item := range items {
+				if item, ok := item.(map[string]interface{}); ok {
+					delete(item, "allocated") // legacy allocation count without v1beta1 counterpart
+					if template, ok := item["generictemplate"].(map[string]interface{}); ok {
+						fixMap(appWrapper, i, nil, template)
+					}
+				}
+			}
+			if err := unstructured.SetNestedSlice(spec, items, "resources", "GenericItems"); err != nil {
+				return nil, err
+			}
+		}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(spec, &appWrapper.Spec); err != nil {
+			return nil, err
+		}
+	}
+	return appWrapper, nil
+}
+
+// Check if an AppWrapper was imported from a legacy AppWrapper dispatched by the legacy controller
+func legacyDispatched(appWrapper *mcadv1beta1.AppWrapper) bool {
+	return legacyDispatchedStates[appWrapper.Annotations[legacyStateAnnotation]] && appWrapper.Annotations[legacyUIDAnnotation] != ""
+}
+
+// Return the state and step of an AppWrapper to account for in dispatching decisions
+// New AppWrappers imported from dispatched legacy AppWrappers are accounted for as dispatched before their status is updated
+// They are reported in the Creating step so they are neither preempted nor reclaimed before the update
+func (r *Dispatcher) getAccountedAW(appWrapper *mcadv1beta1.AppWrapper) (mcadv1beta1.AppWrapperState, mcadv1beta1.AppWrapperStep) {
+	state, step := r.getCachedAW(appWrapper)
+	if state == mcadv1beta1.Empty && !r.MultiClusterMode && legacyDispatched(appWrapper) {
+		return mcadv1beta1.Running, mcadv1beta1.Creating
+	}
+	return state, step
+}
+
+// SetupWithManager sets up the controller with the Manager
+func (r *LegacyImporter) SetupWithManager(mgr ctrl.Manager) error {
+	legacy := &unstructured.Unstructured{}
+	legacy.SetGroupVersionKind(legacyAppWrapperGVK)
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("legacyimporter").
+		For(legacy).
+		Complete(r)
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+// Build a legacy AppWrapper wrapping a deployment in the given state
+func legacyTestAppWrapper(state string) *unstructured.Unstructured {
+	legacy := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"namespace":   "default",
+			"name":        "legacy",
+			"labels":      map[string]interface{}{"team": "a"},
+			"annotations": map[string]interface{}{"kubectl.kubernetes.io/last-applied-configuration": "{}", "note": "kept"},
+		},
+		"spec": map[string]interface{}{
+			"priority": int64(5),
+			"resources": map[string]interface{}{
+				"GenericItems": []interface{}{
+					map[string]interface{}{
+						"allocated": int64(2),
+						"generictemplate": map[string]interface{}{
+							"apiVersion": "apps/v1",
+							"kind":       "Deployment",
+							"metadata":   map[string]interface{}{"name": "deployment"},
+							"spec": map[string]interface{}{
+								"replicas": int64(2),
+								"template": map[string]interface{}{
+									"spec": map[string]interface{}{
+										"containers": []interface{}{map[string]interface{}{"name": "busybox", "image": "busybox"}},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		"status": map[string]interface{}{"state": state},
+	}}
+	legacy.SetGroupVersionKind(legacyAppWrapperGVK)
+	return legacy
+}
+
+func TestConvertLegacyAppWrapper(t *testing.T) {
+	appWrapper, err := ConvertLegacyAppWrapper(legacyTestAppWrapper("Running"))
+	if err != nil {
+		t.Fatalf("ConvertLegacyAppWrapper() = %v", err)
+	}
+	if appWrapper.Namespace != "default" || appWrapper.Name != "legacy" || appWrapper.Labels["team"] != "a" {
+		t.Errorf("metadata = %v, want namespace, name, and labels of legacy AppWrapper", appWrapper.ObjectMeta)
+	}
+	if _, ok := appWrapper.Annotations["kubectl.kubernetes.io/last-applied-configuration"]; ok || appWrapper.Annotations["note"] != "kept" {
+		t.Errorf("annotations = %v, want legacy annotations except last applied configuration", appWrapper.Annotations)
+	}
+	if appWrapper.Annotations[legacyStateAnnotation] != "Running" {
+		t.Errorf("legacy state annotation = %q, want Running", appWrapper.Annotations[legacyStateAnnotation])
+	}
+	if appWrapper.Spec.Priority != 5 {
+		t.Errorf("priority = %d, want 5", appWrapper.Spec.Priority)
+	}
+	items := appWrapper.Spec.Resources.GenericItems
+	if len(items) != 1 {
+		t.Fatalf("got %d generic items, want 1", len(items))
+	}
+	// pod template is labeled with the runner labels
+	obj, err := parseResource(appWrapper, 0, items[0].GenericTemplate.Raw)
+	if err != nil {
+		t.Fatalf("parseResource() = %v", err)
+	}
+	labels, _, _ := unstructured.NestedStringMap(obj.Object, "spec", "template", "metadata", "labels")
+	if labels[nameLabel] != "legacy" || labels[namespaceLabel] != "default" || labels[itemLabel] != "0" {
+		t.Errorf("pod template labels = %v, want AppWrapper labels", labels)
+	}
+	if !hasLabeledPodTemplate(items[0].GenericTemplate.Raw) {
+		t.Error("converted generic template has no labeled pod template")
+	}
+}
+
+func TestLegacyDispatched(t *testing.T) {
+	tests := []struct {
+		state      string
+		uid        string
+		dispatched bool
+	}{
+		{state: "Running", uid: "legacy-uid", dispatched: true},
+		{state: "RunningHoldCompletion", uid: "legacy-uid", dispatched: true},
+		{state: "Pending", uid: "legacy-uid", dispatched: false},
+		{state: "", uid: "legacy-uid", dispatched: false},
+		{state: "Running", dispatched: false},
+	}
+	for _, tt := range tests {
+		legacy := legacyTestAppWrapper(tt.state)
+		legacy.SetUID(types.UID(tt.uid))
+		appWrapper, err := ConvertLegacyAppWrapper(legacy)
+		if err != nil {
+			t.Fatalf("ConvertLegacyAppWrapper() = %v", err)
+		}
+		if len(appWrapper.OwnerReferences) > 0 {
+			t.Errorf("owner references = %v, want none", appWrapper.OwnerReferences)
+		}
+		if dispatched := legacyDispatched(appWrapper); dispatched != tt.dispatched {
+			t.Errorf("legacyDispatched() = %v for state %q and legacy UID %q, want %v", dispatched, tt.state, tt.uid, tt.dispatched)
+		}
+	}
+}
+
+func TestSelectForDispatchLegacyDispatched(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    string
+		selected bool
+	}{
+		{name: "imported running legacy AppWrapper", state: "Running"},
+		{name: "imported pending legacy AppWrapper", state: "Pending", selected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// imported AppWrapper not reconciled by the dispatcher yet
+			imported := dispatchTestAppWrapper("imported", 0, "3")
+			imported.Status = mcadv1beta1.AppWrapperStatus{}
+			imported.Annotations = map[string]string{legacyStateAnnotation: tt.state, legacyUIDAnnotation: "legacy-uid"}
+			r := dispatchTestDispatcher(t, dispatchTestCluster("4"), imported, dispatchTestAppWrapper("aw", 1, "2"))
+			if selected := dispatchTestSelect(t, r); (len(selected) == 1) != tt.selected {
+				t.Errorf("selected %v, want selected %v", selected, tt.selected)
+			}
+		})
+	}
+}
@@ -65,6 +65,12 @@ var (
 		Name:      "requested_gpu",
 		Help:      "Requested GPU per priority",
 	}, []string{"priority"})
+
+	fairShareScore = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "mcad",
+		Name:      "fair_share_score",
+		Help:      "Fair-share score per cluster and namespace, lower scores are served first",
+	}, []string{"cluster", "namespace"})
 )
 
 func init() {
@@ -76,5 +82,6 @@ func init() {
 		requestedCpu,
 		requestedMemory,
 		requestedGpu,
+		fairShareScore,
 	)
 }
@@ -0,0 +1,158 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"slices"
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+// The node constraints of a pod template: its node selector and required node affinity
+// A nil value matches every node
+type nodeConstraints struct {
+	// node selector of the pod template
+	selector map[string]string
+
+	// required node affinity of the pod template if any, any term must match
+	affinity *v1.NodeSelector
+}
+
+// Extract the node constraints of a pod spec, nil if unconstrained
+func getNodeConstraintsForPodSpec(spec *v1.PodSpec) *nodeConstraints {
+	constraints := &nodeConstraints{selector: spec.NodeSelector}
+	if spec.Affinity != nil && spec.Affinity.NodeAffinity != nil {
+		constraints.affinity = spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	}
+	if len(constraints.selector) == 0 && constraints.affinity == nil {
+		return nil
+	}
+	return constraints
+}
+
+// Collect the node constraints of the pod templates of a generic item, one per custom pod resource
+// Custom pod resources are matched with pod templates in order if they have the same length,
+// otherwise the constraints of the pod templates only apply if identical for all the pod templates
+func getNodeConstraintsForItem(appWrapper *mcadv1beta1.AppWrapper, item int) []*nodeConstraints {
+	cprs := appWrapper.Spec.Resources.GenericItems[item].CustomPodResources
+	constraints := make([]*nodeConstraints, len(cprs))
+	obj, err := parseResource(appWrapper, item, appWrapper.Spec.Resources.GenericItems[item].GenericTemplate.Raw)
+	if err != nil {
+		return constraints // AppWrapper will fail in createResources
+	}
+	templates := []podTemplate{}
+	findPodTemplates(obj.UnstructuredContent(), 1, &templates)
+	if len(templates) == 0 {
+		return constraints
+	}
+	if len(templates) == len(cprs) {
+		for i, template := range templates {
+			constraints[i] = getNodeConstraintsForPodSpec(template.spec)
+		}
+		return constraints
+	}
+	shared := getNodeConstraintsForPodSpec(templates[0].spec)
+	for _, template := range templates[1:] {
+		if !reflect.DeepEqual(shared, getNodeConstraintsForPodSpec(template.spec)) {
+			return constraints
+		}
+	}
+	for i := range constraints {
+		constraints[i] = shared
+	}
+	return constraints
+}
+
+// Check if a node satisfies node constraints
+// Label keys not in the given set of reported keys cannot be checked and are ignored
+func (constraints *nodeConstraints) Matches(node *mcadv1beta1.NodeInfo, labelKeys map[string]bool) bool {
+	if constraints == nil {
+		return true
+	}
+	for k, v := range constraints.selector {
+		if labelKeys[k] && node.Labels[k] != v {
+			return false
+		}
+	}
+	if constraints.affinity == nil || len(constraints.affinity.NodeSelectorTerms) == 0 {
+		return true
+	}
+	for _, term := range constraints.affinity.NodeSelectorTerms {
+		if termMatches(term, node, labelKeys) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if a node matches all the requirements of a node selector term
+// A term without requirements matches no node
+func termMatches(term v1.NodeSelectorTerm, node *mcadv1beta1.NodeInfo, labelKeys map[string]bool) bool {
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+		return false
+	}
+	for _, requirement := range term.MatchExpressions {
+		if !labelKeys[requirement.Key] {
+			continue
+		}
+		value, ok := node.Labels[requirement.Key]
+		if !requirementMatches(requirement, value, ok) {
+			return false
+		}
+	}
+	for _, requirement := range term.MatchFields {
+		if requirement.Key == "metadata.name" && !requirementMatches(requirement, node.Name, true) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check if a label value, possibly absent, satisfies a node selector requirement
+func requirementMatches(requirement v1.NodeSelectorRequirement, value string, ok bool) bool {
+	switch requirement.Operator {
+	case v1.NodeSelectorOpIn:
+		return ok && slices.Contains(requirement.Values, value)
+	case v1.NodeSelectorOpNotIn:
+		return !ok || !slices.Contains(requirement.Values, value)
+	case v1.NodeSelectorOpExists:
+		return ok
+	case v1.NodeSelectorOpDoesNotExist:
+		return !ok
+	case v1.NodeSelectorOpGt, v1.NodeSelectorOpLt:
+		if !ok || len(requirement.Values) != 1 {
+			return false
+		}
+		actual, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return false
+		}
+		bound, err := strconv.ParseInt(requirement.Values[0], 10, 64)
+		if err != nil {
+			return false
+		}
+		if requirement.Operator == v1.NodeSelectorOpGt {
+			return actual > bound
+		}
+		return actual < bound
+	}
+	return false
+}
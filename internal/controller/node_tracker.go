@@ -0,0 +1,315 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sort"
+
+	"gopkg.in/inf.v0"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+// A tracker of the capacity of the nodes of a cluster and of the resources reserved on these nodes
+// Resources requested by running pods are attributed to the nodes the pods are bound to.
+// Resources requested by AppWrappers but not bound to a node yet are tracked separately
+// together with the set of nodes they may be placed on.
+type NodeTracker struct {
+	// nodes of the cluster in the order reported in the cluster info
+	nodes []mcadv1beta1.NodeInfo
+
+	// node label keys reported in the cluster info
+	labelKeys map[string]bool
+
+	// available capacity per node
+	capacity map[string]Weights
+
+	// requests of running pods per node and priority
+	placed map[string]map[int]Weights
+
+	// requests not placed on a node yet
+	floating []*floatingRequest
+}
+
+// A request that is not bound to a node yet
+type floatingRequest struct {
+	// nodes the request may be placed on
+	nodes map[string]bool
+
+	// priority of the request
+	priority int
+
+	// requested resources
+	request Weights
+}
+
+// Create a new NodeTracker from a ClusterInfo object
+func NewNodeTracker(cluster *mcadv1beta1.ClusterInfo) *NodeTracker {
+	tracker := &NodeTracker{
+		nodes:     cluster.Status.Nodes,
+		labelKeys: map[string]bool{},
+		capacity:  map[string]Weights{},
+		placed:    map[string]map[int]Weights{},
+	}
+	for _, key := range cluster.Status.NodeLabelKeys {
+		tracker.labelKeys[key] = true
+	}
+	for _, node := range cluster.Status.Nodes {
+		tracker.capacity[node.Name] = NewWeights(node.Capacity)
+	}
+	return tracker
+}
+
+// Is per-node capacity known for this cluster?
+func (tracker *NodeTracker) Known() bool {
+	return len(tracker.nodes) > 0
+}
+
+// Does the cluster have tainted nodes?
+func (tracker *NodeTracker) Tainted() bool {
+	for _, node := range tracker.nodes {
+		if len(node.Taints) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the names of the nodes matching a node selector with taints tolerated by the given tolerations
+// A nil selector matches every node, nil tolerations only match untainted nodes
+// Selector keys not reported in the cluster info cannot be checked and are ignored
+func (tracker *NodeTracker) MatchingNodes(selector map[string]string, tolerations podTolerations) map[string]bool {
+	matches := map[string]bool{}
+	known := map[string]string{}
+	for k, v := range selector {
+		if tracker.labelKeys[k] {
+			known[k] = v
+		}
+	}
+	s := labels.SelectorFromSet(known)
+	for _, node := range tracker.nodes {
+		if s.Matches(labels.Set(node.Labels)) && tolerations.Tolerate(node.Taints) {
+			matches[node.Name] = true
+		}
+	}
+	return matches
+}
+
+// Record the request of a running pod on a node
+func (tracker *NodeTracker) AddPlaced(node string, priority int, request Weights) {
+	if tracker.placed[node] == nil {
+		tracker.placed[node] = map[int]Weights{}
+	}
+	if tracker.placed[node][priority] == nil {
+		tracker.placed[node][priority] = Weights{}
+	}
+	tracker.placed[node][priority].Add(request)
+}
+
+// Record a request that may be placed on any node matching a node selector and tolerations
+func (tracker *NodeTracker) AddFloating(selector map[string]string, tolerations podTolerations, priority int, request Weights) {
+	tracker.floating = append(tracker.floating, &floatingRequest{
+		nodes:    tracker.MatchingNodes(selector, tolerations),
+		priority: priority,
+		request:  request.Clone(),
+	})
+}
+
+// Compute the capacity available at a given priority on the nodes matching a node selector and tolerations
+// available capacity = capacity of matching nodes
+// - requests of pods placed on matching nodes at this priority or above
+// - requests not placed yet at this priority or above that may be placed on matching nodes
+// Also return the number of matching nodes
+func (tracker *NodeTracker) Available(selector map[string]string, tolerations podTolerations, priority int) (Weights, int) {
+	available := Weights{}
+	nodes := tracker.MatchingNodes(selector, tolerations)
+	for node := range nodes {
+		available.Add(tracker.capacity[node])
+		for p, request := range tracker.placed[node] {
+			if p >= priority {
+				available.Sub(request)
+			}
+		}
+	}
+	for _, floating := range tracker.floating {
+		if floating.priority >= priority && overlaps(floating.nodes, nodes) {
+			available.Sub(floating.request)
+		}
+	}
+	return available, len(nodes)
+}
+
+// Check if two sets of node names intersect
+func overlaps(a map[string]bool, b map[string]bool) bool {
+	for node := range a {
+		if b[node] {
+			return true
+		}
+	}
+	return false
+}
+
+// A set of identical pods to place on nodes
+type podSet struct {
+	// index of the generic item creating the pods
+	item int
+
+	// priority of the pods
+	priority int
+
+	// number of pods
+	count int32
+
+	// requests of each pod
+	request Weights
+
+	// node constraints of the pod template of the pods if any
+	constraints *nodeConstraints
+}
+
+// A pod placed on a node by the node tracker
+type podPlacement struct {
+	node     string
+	item     int
+	priority int
+	request  Weights
+}
+
+// Compute the free capacity of every node at a given priority
+// The free capacity of a node is its capacity minus the requests of the pods placed on the node at this priority or above.
+// Requests not placed on a node yet at this priority or above are then reserved against the nodes they may be placed on:
+// each requested resource fills the free capacity of these nodes in cluster info order.
+func (tracker *NodeTracker) freeCapacity(priority int) map[string]Weights {
+	free := map[string]Weights{}
+	for _, node := range tracker.nodes {
+		free[node.Name] = tracker.capacity[node.Name].Clone()
+		for p, request := range tracker.placed[node.Name] {
+			if p >= priority {
+				free[node.Name].Sub(request)
+			}
+		}
+	}
+	zero := &inf.Dec{} // shared zero, never mutated
+	for _, floating := range tracker.floating {
+		if floating.priority < priority {
+			continue
+		}
+		for k, v := range floating.request {
+			remaining := new(inf.Dec).Set(v)
+			for _, node := range tracker.nodes {
+				if remaining.Cmp(zero) <= 0 {
+					break
+				}
+				avail := free[node.Name][k]
+				if !floating.nodes[node.Name] || avail == nil || avail.Cmp(zero) <= 0 {
+					continue
+				}
+				reserved := new(inf.Dec).Set(remaining)
+				if avail.Cmp(remaining) < 0 {
+					reserved.Set(avail)
+				}
+				avail.Sub(avail, reserved)
+				remaining.Sub(remaining, reserved)
+			}
+		}
+	}
+	return free
+}
+
+// Place pod sets on the nodes matching a node selector and tolerations using first-fit-decreasing at a given priority
+// The free capacity of the nodes is computed by freeCapacity.
+// Pod sets are placed in decreasing order of their dominant share of the capacity of the matching nodes,
+// each pod on the first node in cluster info order with enough free capacity that satisfies the node constraints of the pod set.
+// Return the placements of all the pods or nil and the pod set that cannot be placed with its number of unplaced pods
+func (tracker *NodeTracker) Place(selector map[string]string, tolerations podTolerations, priority int, podSets []podSet) ([]podPlacement, *podSet, int32) {
+	matches := tracker.MatchingNodes(selector, tolerations)
+	free := tracker.freeCapacity(priority)
+	total := Weights{}
+	for node := range matches {
+		total.Add(tracker.capacity[node])
+	}
+	shares := make([]float64, len(podSets))
+	order := make([]int, len(podSets))
+	for i := range podSets {
+		order[i] = i
+		shares[i] = dominantShare(podSets[i].request, total)
+	}
+	sort.SliceStable(order, func(i, j int) bool { return shares[order[i]] > shares[order[j]] })
+	placements := []podPlacement{}
+	for _, i := range order {
+		set := &podSets[i]
+		for n := int32(0); n < set.count; n++ {
+			placed := false
+			for j := range tracker.nodes {
+				node := &tracker.nodes[j]
+				if !matches[node.Name] || !set.constraints.Matches(node, tracker.labelKeys) {
+					continue
+				}
+				if fits, _ := set.request.Fits(free[node.Name]); fits {
+					free[node.Name].Sub(set.request)
+					placements = append(placements, podPlacement{node: node.Name, item: set.item, priority: set.priority, request: set.request})
+					placed = true
+					break
+				}
+			}
+			if !placed {
+				return nil, set, set.count - n
+			}
+		}
+	}
+	return placements, nil, 0
+}
+
+// Return the values of a topology label on the nodes matching a node selector and tolerations in increasing order
+func (tracker *NodeTracker) DomainValues(selector map[string]string, tolerations podTolerations, key string) []string {
+	matches := tracker.MatchingNodes(selector, tolerations)
+	seen := map[string]bool{}
+	values := []string{}
+	for _, node := range tracker.nodes {
+		if value, ok := node.Labels[key]; ok && matches[node.Name] && !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	sort.Strings(values)
+	return values
+}
+
+// Place pod sets on the nodes matching a node selector and tolerations within a single topology domain
+// Domains are tried in increasing label value order
+// Return the placements and the domain or nil if no domain has room for all the pods
+func (tracker *NodeTracker) PlaceInDomain(selector map[string]string, tolerations podTolerations, key string, priority int, podSets []podSet) ([]podPlacement, string) {
+	for _, value := range tracker.DomainValues(selector, tolerations, key) {
+		domainSelector := map[string]string{key: value}
+		for k, v := range selector {
+			domainSelector[k] = v
+		}
+		if placements, unplaced, _ := tracker.Place(domainSelector, tolerations, priority, podSets); unplaced == nil {
+			return placements, value
+		}
+	}
+	return nil, ""
+}
+
+// Record the placements computed by Place
+func (tracker *NodeTracker) AddPlacements(placements []podPlacement) {
+	for _, placement := range placements {
+		tracker.AddPlaced(placement.node, placement.priority, placement.request)
+	}
+}
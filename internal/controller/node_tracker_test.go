@@ -0,0 +1,271 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+const (
+	nodeTestGPU  = v1.ResourceName("nvidia.com/gpu")
+	nodeTestZone = "topology.kubernetes.io/zone"
+)
+
+// Build a node tracker for nodes with the given numbers of GPUs, alternating between zones a and b
+func nodeTestTracker(gpus ...int64) *NodeTracker {
+	cluster := &mcadv1beta1.ClusterInfo{Status: mcadv1beta1.ClusterInfoStatus{NodeLabelKeys: []string{nodeTestZone}}}
+	for i, n := range gpus {
+		cluster.Status.Nodes = append(cluster.Status.Nodes, mcadv1beta1.NodeInfo{
+			Name:     fmt.Sprintf("node-%d", i),
+			Labels:   map[string]string{nodeTestZone: string(rune('a' + i%2))},
+			Capacity: v1.ResourceList{nodeTestGPU: *resource.NewQuantity(n, resource.DecimalSI)},
+		})
+	}
+	return NewNodeTracker(cluster)
+}
+
+// Build GPU weights
+func nodeTestGPUs(n int64) Weights {
+	return NewWeights(v1.ResourceList{nodeTestGPU: *resource.NewQuantity(n, resource.DecimalSI)})
+}
+
+func TestNodeTrackerPlace(t *testing.T) {
+	inZoneB := &nodeConstraints{selector: map[string]string{nodeTestZone: "b"}}
+	notInZoneA := &nodeConstraints{affinity: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{{
+		MatchExpressions: []v1.NodeSelectorRequirement{{Key: nodeTestZone, Operator: v1.NodeSelectorOpNotIn, Values: []string{"a"}}},
+	}}}}
+	unreported := &nodeConstraints{selector: map[string]string{"example.com/unreported": "x"}}
+
+	tests := []struct {
+		name     string
+		gpus     []int64
+		placed   map[string]int64 // GPUs of running pods per node
+		floating []int64          // GPUs requested but not placed yet
+		sets     []podSet
+		unplaced int32 // expected number of unplaced pods
+		nodes    []string
+	}{
+		{
+			name:  "fragmented capacity",
+			gpus:  []int64{1, 1, 1, 1},
+			sets:  []podSet{{count: 1, request: nodeTestGPUs(2)}},
+			nodes: nil, unplaced: 1,
+		},
+		{
+			name:  "first fit decreasing",
+			gpus:  []int64{2, 4},
+			sets:  []podSet{{count: 2, request: nodeTestGPUs(1)}, {count: 1, request: nodeTestGPUs(4)}},
+			nodes: []string{"node-1", "node-0", "node-0"},
+		},
+		{
+			name:   "running pods",
+			gpus:   []int64{4, 4},
+			placed: map[string]int64{"node-0": 3},
+			sets:   []podSet{{count: 1, request: nodeTestGPUs(2)}},
+			nodes:  []string{"node-1"},
+		},
+		{
+			name:     "floating requests reserve capacity",
+			gpus:     []int64{4, 4},
+			floating: []int64{4},
+			sets:     []podSet{{count: 2, request: nodeTestGPUs(4)}},
+			unplaced: 1,
+		},
+		{
+			name:     "floating requests spread over nodes",
+			gpus:     []int64{2, 2, 4},
+			floating: []int64{3},
+			sets:     []podSet{{count: 1, request: nodeTestGPUs(4)}},
+			nodes:    []string{"node-2"},
+		},
+		{
+			name:  "pod template node selector",
+			gpus:  []int64{4, 4, 4},
+			sets:  []podSet{{count: 1, request: nodeTestGPUs(4), constraints: inZoneB}},
+			nodes: []string{"node-1"},
+		},
+		{
+			name:     "pod template node selector without room",
+			gpus:     []int64{4, 4, 4},
+			sets:     []podSet{{count: 2, request: nodeTestGPUs(4), constraints: inZoneB}},
+			unplaced: 1,
+		},
+		{
+			name:  "pod template node affinity",
+			gpus:  []int64{8, 2, 8, 2},
+			sets:  []podSet{{count: 2, request: nodeTestGPUs(2), constraints: notInZoneA}},
+			nodes: []string{"node-1", "node-3"},
+		},
+		{
+			name:  "unreported label keys are ignored",
+			gpus:  []int64{4},
+			sets:  []podSet{{count: 1, request: nodeTestGPUs(4), constraints: unreported}},
+			nodes: []string{"node-0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := nodeTestTracker(tt.gpus...)
+			for node, n := range tt.placed {
+				tracker.AddPlaced(node, 0, nodeTestGPUs(n))
+			}
+			for _, n := range tt.floating {
+				tracker.AddFloating(nil, nil, 0, nodeTestGPUs(n))
+			}
+			placements, unplaced, count := tracker.Place(nil, nil, 0, tt.sets)
+			if tt.unplaced > 0 {
+				if unplaced == nil || count != tt.unplaced {
+					t.Fatalf("Place() left %d pods unplaced, want %d", count, tt.unplaced)
+				}
+				return
+			}
+			if unplaced != nil {
+				t.Fatalf("Place() left %d pods unplaced, want none", count)
+			}
+			nodes := []string{}
+			for _, placement := range placements {
+				nodes = append(nodes, placement.node)
+			}
+			if fmt.Sprint(nodes) != fmt.Sprint(tt.nodes) {
+				t.Errorf("Place() placed pods on %v, want %v", nodes, tt.nodes)
+			}
+		})
+	}
+}
+
+func TestNodeTrackerPlaceIgnoresLowerPriorityFloatingRequests(t *testing.T) {
+	tracker := nodeTestTracker(4)
+	tracker.AddFloating(nil, nil, 0, nodeTestGPUs(4))
+	if _, unplaced, _ := tracker.Place(nil, nil, 1, []podSet{{priority: 1, count: 1, request: nodeTestGPUs(4)}}); unplaced != nil {
+		t.Error("Place() accounted for a lower priority floating request")
+	}
+	if _, unplaced, _ := tracker.Place(nil, nil, 0, []podSet{{count: 1, request: nodeTestGPUs(4)}}); unplaced == nil {
+		t.Error("Place() ignored a floating request at the same priority")
+	}
+}
+
+func TestPodSetsByPriorityNodeConstraints(t *testing.T) {
+	// a PyTorchJob-like item with two pod templates with different node selectors, visited in key order
+	raw := `{"apiVersion": "example.com/v1", "kind": "Job", "metadata": {"name": "job"}, "spec": {"replicaSpecs": {
+		"Worker": {"replicas": 2, "template": {"spec": {"nodeSelector": {"pool": "workers"}, "containers": [{"name": "c", "image": "i"}]}}},
+		"Master": {"replicas": 1, "template": {"spec": {"nodeSelector": {"pool": "masters"}, "containers": [{"name": "c", "image": "i"}]}}}}}}`
+	cpr := mcadv1beta1.CustomPodResource{Replicas: 1, Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}}
+	appWrapper := &mcadv1beta1.AppWrapper{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "aw"},
+		Spec: mcadv1beta1.AppWrapperSpec{Resources: mcadv1beta1.AppWrapperResources{GenericItems: []mcadv1beta1.GenericItem{
+			{GenericTemplate: runtime.RawExtension{Raw: []byte(raw)}, CustomPodResources: []mcadv1beta1.CustomPodResource{cpr, cpr}},
+			{GenericTemplate: runtime.RawExtension{Raw: []byte(raw)}, CustomPodResources: []mcadv1beta1.CustomPodResource{cpr}},
+		}}},
+	}
+	podSets := podSetsByPriority(appWrapper, 0, time.Now())
+	if len(podSets) != 3 {
+		t.Fatalf("got %d pod sets, want 3", len(podSets))
+	}
+	if c := podSets[0].constraints; c == nil || c.selector["pool"] != "masters" {
+		t.Errorf("constraints of first pod set = %+v, want masters pool", c)
+	}
+	if c := podSets[1].constraints; c == nil || c.selector["pool"] != "workers" {
+		t.Errorf("constraints of second pod set = %+v, want workers pool", c)
+	}
+	// custom pod resources not matching the pod templates one to one and templates with distinct constraints
+	if c := podSets[2].constraints; c != nil {
+		t.Errorf("constraints of third pod set = %+v, want none", c)
+	}
+}
+
+func TestNodeTrackerPlaceInDomain(t *testing.T) {
+	tests := []struct {
+		name   string
+		gpus   []int64
+		placed map[string]int64 // GPUs of running pods per node
+		sets   []podSet
+		domain string // expected domain, none if empty
+		nodes  []string
+	}{
+		{
+			name:   "first domain with room",
+			gpus:   []int64{4, 2, 4, 2},
+			sets:   []podSet{{count: 2, request: nodeTestGPUs(4)}},
+			domain: "a", nodes: []string{"node-0", "node-2"},
+		},
+		{
+			name:   "pods spread over nodes of one domain",
+			gpus:   []int64{4, 2, 4, 2},
+			sets:   []podSet{{count: 3, request: nodeTestGPUs(2)}},
+			domain: "a", nodes: []string{"node-0", "node-0", "node-2"},
+		},
+		{
+			name:   "first domain full",
+			gpus:   []int64{4, 2, 4, 2},
+			placed: map[string]int64{"node-0": 4, "node-2": 4},
+			sets:   []podSet{{count: 2, request: nodeTestGPUs(2)}},
+			domain: "b", nodes: []string{"node-1", "node-3"},
+		},
+		{
+			name: "room across domains only",
+			gpus: []int64{4, 4},
+			sets: []podSet{{count: 2, request: nodeTestGPUs(4)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := nodeTestTracker(tt.gpus...)
+			for node, n := range tt.placed {
+				tracker.AddPlaced(node, 0, nodeTestGPUs(n))
+			}
+			placements, domain := tracker.PlaceInDomain(nil, nil, nodeTestZone, 0, tt.sets)
+			if domain != tt.domain {
+				t.Fatalf("PlaceInDomain() chose domain %q, want %q", domain, tt.domain)
+			}
+			if tt.domain == "" {
+				if placements != nil {
+					t.Errorf("PlaceInDomain() = %v without domain, want nil", placements)
+				}
+				return
+			}
+			nodes := []string{}
+			for _, placement := range placements {
+				nodes = append(nodes, placement.node)
+			}
+			if fmt.Sprint(nodes) != fmt.Sprint(tt.nodes) {
+				t.Errorf("PlaceInDomain() placed pods on %v, want %v", nodes, tt.nodes)
+			}
+		})
+	}
+}
+
+func TestNodeTrackerDomainValues(t *testing.T) {
+	tracker := nodeTestTracker(1, 1, 1)
+	if values := tracker.DomainValues(nil, nil, nodeTestZone); fmt.Sprint(values) != "[a b]" {
+		t.Errorf("DomainValues() = %v, want [a b]", values)
+	}
+	if values := tracker.DomainValues(map[string]string{nodeTestZone: "b"}, nil, nodeTestZone); fmt.Sprint(values) != "[b]" {
+		t.Errorf("DomainValues() = %v for selector, want [b]", values)
+	}
+	if values := tracker.DomainValues(nil, nil, "example.com/rack"); len(values) != 0 {
+		t.Errorf("DomainValues() = %v for missing label, want none", values)
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+// Names of the built-in queue ordering policies
+const (
+	FIFOQueueOrder        = "fifo"
+	SJFQueueOrder         = "sjf"
+	SmallestGPUQueueOrder = "smallest-gpu"
+	FairShareQueueOrder   = "fair-share"
+)
+
+// Information available to queue ordering policies
+type QueueState struct {
+	// capacity of the cluster
+	Capacity Weights
+
+	// resources reserved by dispatched AppWrappers per namespace
+	Usage map[string]Weights
+
+	// fair-share scores per namespace based on decayed usage history, nil if not tracked
+	Scores map[string]float64
+}
+
+// A policy to order queued AppWrappers with the same effective priority
+// Ties are broken using creation time then UID
+type QueueOrderPolicy interface {
+	// Compare two queued AppWrappers
+	// Return a negative number if a goes first, a positive number if b goes first, zero if tied
+	Compare(a *mcadv1beta1.AppWrapper, b *mcadv1beta1.AppWrapper, state *QueueState) int
+}
+
+// Return the built-in queue ordering policy with the given name
+func NewQueueOrderPolicy(name string) (QueueOrderPolicy, error) {
+	switch name {
+	case FIFOQueueOrder, "":
+		return &fifoQueueOrder{}, nil
+	case SJFQueueOrder:
+		return &sjfQueueOrder{}, nil
+	case SmallestGPUQueueOrder:
+		return &smallestGPUQueueOrder{}, nil
+	case FairShareQueueOrder:
+		return &fairShareQueueOrder{}, nil
+	}
+	return nil, fmt.Errorf("unknown queue order policy %q", name)
+}
+
+// Dispatch AppWrappers in creation order
+type fifoQueueOrder struct{}
+
+func (*fifoQueueOrder) Compare(a *mcadv1beta1.AppWrapper, b *mcadv1beta1.AppWrapper, state *QueueState) int {
+	return 0
+}
+
+// Dispatch AppWrappers with the smallest aggregate request relative to the cluster capacity first
+type sjfQueueOrder struct{}
+
+func (*sjfQueueOrder) Compare(a *mcadv1beta1.AppWrapper, b *mcadv1beta1.AppWrapper, state *QueueState) int {
+	return compareFloat64(dominantShare(aggregateRequests(a), state.Capacity), dominantShare(aggregateRequests(b), state.Capacity))
+}
+
+// Dispatch AppWrappers with the smallest aggregate GPU request first
+type smallestGPUQueueOrder struct{}
+
+func (*smallestGPUQueueOrder) Compare(a *mcadv1beta1.AppWrapper, b *mcadv1beta1.AppWrapper, state *QueueState) int {
+	ga := aggregateRequests(a)[nvidiaGpu]
+	gb := aggregateRequests(b)[nvidiaGpu]
+	switch {
+	case ga == nil && gb == nil:
+		return 0
+	case ga == nil:
+		return -gb.Sign()
+	case gb == nil:
+		return ga.Sign()
+	}
+	return ga.Cmp(gb)
+}
+
+// Dispatch AppWrappers from the namespaces with the smallest fair-share scores first if tracked
+// or the smallest share of the cluster capacity reserved by dispatched AppWrappers otherwise
+type fairShareQueueOrder struct{}
+
+func (*fairShareQueueOrder) Compare(a *mcadv1beta1.AppWrapper, b *mcadv1beta1.AppWrapper, state *QueueState) int {
+	if a.Namespace == b.Namespace {
+		return 0
+	}
+	if state.Scores != nil {
+		return compareFloat64(state.Scores[a.Namespace], state.Scores[b.Namespace])
+	}
+	return compareFloat64(dominantShare(state.Usage[a.Namespace], state.Capacity), dominantShare(state.Usage[b.Namespace], state.Capacity))
+}
+
+// Compute the max ratio of requested to available quantities across all resources with a positive capacity
+func dominantShare(request Weights, capacity Weights) float64 {
+	share := 0.0
+	for k, v := range request {
+		c, ok := capacity[k]
+		if !ok || c.Sign() <= 0 {
+			continue
+		}
+		fv, err := Dec2float64(v)
+		if err != nil {
+			continue
+		}
+		fc, err := Dec2float64(c)
+		if err != nil {
+			continue
+		}
+		if fv/fc > share {
+			share = fv / fc
+		}
+	}
+	return share
+}
+
+// Compare two float64 values
+func compareFloat64(a float64, b float64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
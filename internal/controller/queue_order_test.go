@@ -0,0 +1,137 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+// A queued or running AppWrapper for queue order tests
+type queueTestAppWrapper struct {
+	name      string
+	namespace string
+	cpu       string
+	gpu       int64
+	priority  int32
+	running   bool
+}
+
+// Build the AppWrapper, the index determines the creation timestamp
+func (aw queueTestAppWrapper) build(index int) *mcadv1beta1.AppWrapper {
+	appWrapper := dispatchTestAppWrapper(aw.name, index, aw.cpu)
+	if aw.namespace != "" {
+		appWrapper.Namespace = aw.namespace
+	}
+	if aw.gpu > 0 {
+		appWrapper.Spec.Resources.GenericItems[0].CustomPodResources[0].Requests[nvidiaGpu] = *resource.NewQuantity(aw.gpu, resource.DecimalSI)
+	}
+	appWrapper.Spec.Priority = aw.priority
+	if aw.running {
+		dispatchTestRunning(appWrapper, time.Now())
+	}
+	return appWrapper
+}
+
+func TestBuildQueueOrder(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      string
+		appWrappers []queueTestAppWrapper
+		queue       []string
+	}{
+		{
+			name:        "creation order",
+			policy:      FIFOQueueOrder,
+			appWrappers: []queueTestAppWrapper{{name: "big", cpu: "4"}, {name: "small", cpu: "1"}},
+			queue:       []string{"big", "small"},
+		},
+		{
+			name:        "smallest request first",
+			policy:      SJFQueueOrder,
+			appWrappers: []queueTestAppWrapper{{name: "big", cpu: "4"}, {name: "small", cpu: "1"}, {name: "medium", cpu: "2"}},
+			queue:       []string{"small", "medium", "big"},
+		},
+		{
+			name:        "priority before smallest request",
+			policy:      SJFQueueOrder,
+			appWrappers: []queueTestAppWrapper{{name: "small", cpu: "1"}, {name: "big", cpu: "4", priority: 1}},
+			queue:       []string{"big", "small"},
+		},
+		{
+			name:        "smallest GPU request first",
+			policy:      SmallestGPUQueueOrder,
+			appWrappers: []queueTestAppWrapper{{name: "one", cpu: "4", gpu: 1}, {name: "none", cpu: "4"}, {name: "two", cpu: "1", gpu: 2}},
+			queue:       []string{"none", "one", "two"},
+		},
+		{
+			name:   "namespace with smallest usage first",
+			policy: FairShareQueueOrder,
+			appWrappers: []queueTestAppWrapper{
+				{name: "running", namespace: "team-a", cpu: "4", running: true},
+				{name: "a", namespace: "team-a", cpu: "1"},
+				{name: "b", namespace: "team-b", cpu: "1"},
+				{name: "a2", namespace: "team-a", cpu: "1"},
+			},
+			queue: []string{"b", "a", "a2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := NewQueueOrderPolicy(tt.policy)
+			if err != nil {
+				t.Fatalf("NewQueueOrderPolicy() = %v", err)
+			}
+			objs := []client.Object{}
+			for i, aw := range tt.appWrappers {
+				objs = append(objs, aw.build(i))
+			}
+			r := dispatchTestDispatcher(t, objs...)
+			r.QueueOrder = policy
+			appWrappers := &mcadv1beta1.AppWrapperList{}
+			if err := r.List(context.Background(), appWrappers); err != nil {
+				t.Fatal(err)
+			}
+			capacity := NewWeights(v1.ResourceList{v1.ResourceCPU: resource.MustParse("8"), nvidiaGpu: resource.MustParse("8")})
+			_, queue, _, err := r.buildQueue(context.Background(), appWrappers, "cluster", capacity, time.Now(), NewNodeTracker(&mcadv1beta1.ClusterInfo{}))
+			if err != nil {
+				t.Fatalf("buildQueue() = %v", err)
+			}
+			names := []string{}
+			for _, appWrapper := range queue {
+				names = append(names, appWrapper.Name)
+			}
+			if fmt.Sprint(names) != fmt.Sprint(tt.queue) {
+				t.Errorf("queue = %v, want %v", names, tt.queue)
+			}
+		})
+	}
+}
+
+func TestNewQueueOrderPolicyUnknown(t *testing.T) {
+	if _, err := NewQueueOrderPolicy("lifo"); err == nil {
+		t.Error("NewQueueOrderPolicy() = nil error for unknown policy")
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"gopkg.in/inf.v0"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+// Suffix of storage class quota keys
+const storageClassSuffix = ".storageclass.storage.k8s.io/"
+
+// Core resources with a legacy object count quota key in addition to count/<resource>
+var legacyCountResources = map[string]bool{
+	"configmaps":             true,
+	"persistentvolumeclaims": true,
+	"replicationcontrollers": true,
+	"resourcequotas":         true,
+	"secrets":                true,
+	"services":               true,
+}
+
+// Object counts of a single pod
+func podCounts() Weights {
+	return Weights{
+		v1.ResourcePods:               inf.NewDec(1, 0),
+		v1.ResourceName("count/pods"): inf.NewDec(1, 0),
+	}
+}
+
+// Count the pods of an AppWrapper
+func getPodCountsForAppWrapper(appWrapper *mcadv1beta1.AppWrapper) Weights {
+	counts := Weights{}
+	for _, item := range appWrapper.Spec.Resources.GenericItems {
+		for _, cpr := range item.CustomPodResources {
+			counts.AddProd(cpr.Replicas, podCounts())
+		}
+	}
+	return counts
+}
+
+// Count the objects created by an AppWrapper other than pods, keyed by ResourceQuota keys,
+// i.e., count/<resource>.<group>, legacy core keys such as services, services.loadbalancers, services.nodeports,
+// and persistent volume claim storage keys, including storage class keys
+// Pods are counted from the expected replicas of the generic items by getPodCountsForAppWrapper
+// Objects of unknown kinds are not counted
+// Objects for which exists returns true are not counted, e.g., objects already created for an AppWrapper being created
+func getObjectCountsForAppWrapper(mapper meta.RESTMapper, appWrapper *mcadv1beta1.AppWrapper, exists func(client.Object) bool) Weights {
+	counts := Weights{}
+	objects, err := parseResources(appWrapper, nil)
+	if err != nil {
+		return counts // AppWrapper will fail in createResources
+	}
+	for _, obj := range objects {
+		gvk := obj.GetObjectKind().GroupVersionKind()
+		if gvk.Group == "" && gvk.Kind == "Pod" {
+			continue
+		}
+		if exists != nil && exists(obj) {
+			continue
+		}
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			continue
+		}
+		resource := mapping.Resource.Resource
+		if mapping.Resource.Group == "" {
+			addCount(counts, "count/"+resource, 1)
+			if legacyCountResources[resource] {
+				addCount(counts, resource, 1)
+			}
+		} else {
+			addCount(counts, "count/"+resource+"."+mapping.Resource.Group, 1)
+			continue
+		}
+		content := obj.(*unstructured.Unstructured).UnstructuredContent()
+		switch gvk.Kind {
+		case "Service":
+			service := &v1.Service{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, service); err == nil {
+				addServiceCounts(counts, &service.Spec)
+			}
+		case "PersistentVolumeClaim":
+			pvc := &v1.PersistentVolumeClaim{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, pvc); err == nil {
+				addPersistentVolumeClaimCounts(counts, pvc)
+			}
+		}
+	}
+	if service := serviceForAppWrapper(appWrapper); service != nil && (exists == nil || !exists(service)) {
+		addCount(counts, "count/services", 1)
+		addCount(counts, "services", 1)
+		addServiceCounts(counts, &appWrapper.Spec.Service.Spec)
+	}
+	return counts
+}
+
+// Count load balancers and node ports of a Service
+func addServiceCounts(counts Weights, spec *v1.ServiceSpec) {
+	switch spec.Type {
+	case v1.ServiceTypeLoadBalancer:
+		addCount(counts, string(v1.ResourceServicesLoadBalancers), 1)
+		if spec.AllocateLoadBalancerNodePorts == nil || *spec.AllocateLoadBalancerNodePorts {
+			addCount(counts, string(v1.ResourceServicesNodePorts), int64(len(spec.Ports)))
+		}
+	case v1.ServiceTypeNodePort:
+		addCount(counts, string(v1.ResourceServicesNodePorts), int64(len(spec.Ports)))
+	}
+}
+
+// Count storage requested by a PersistentVolumeClaim
+// Storage requests are keyed by storage since the requests. prefix is trimmed from ResourceQuota keys
+func addPersistentVolumeClaimCounts(counts Weights, pvc *v1.PersistentVolumeClaim) {
+	storage, hasStorage := pvc.Spec.Resources.Requests[v1.ResourceStorage]
+	if hasStorage {
+		counts.Add(Weights{v1.ResourceStorage: storage.AsDec()})
+	}
+	if pvc.Spec.StorageClassName != nil && *pvc.Spec.StorageClassName != "" {
+		prefix := *pvc.Spec.StorageClassName + storageClassSuffix
+		addCount(counts, prefix+string(v1.ResourcePersistentVolumeClaims), 1)
+		if hasStorage {
+			counts.Add(Weights{v1.ResourceName(prefix + string(v1.ResourceRequestsStorage)): storage.AsDec()})
+		}
+	}
+}
+
+// Increment an object count
+func addCount(counts Weights, key string, n int64) {
+	counts.Add(Weights{v1.ResourceName(key): inf.NewDec(n, 0)})
+}
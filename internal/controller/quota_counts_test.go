@@ -0,0 +1,244 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+const (
+	countTestService = `{"apiVersion": "v1", "kind": "Service", "metadata": {"name": "service"},
+		"spec": {"type": "LoadBalancer", "ports": [{"port": 80}, {"port": 443}]}}`
+	countTestClaim = `{"apiVersion": "v1", "kind": "PersistentVolumeClaim", "metadata": {"name": "claim"},
+		"spec": {"storageClassName": "fast", "resources": {"requests": {"storage": "10Gi"}}}}`
+	countTestJob = `{"apiVersion": "batch/v1", "kind": "Job", "metadata": {"name": "job"},
+		"spec": {"template": {"spec": {"containers": [{"name": "busybox", "image": "busybox"}]}}}}`
+	countTestUnknown = `{"apiVersion": "example.com/v1", "kind": "Widget", "metadata": {"name": "widget"}}`
+)
+
+// Append a generic item wrapping the given raw template to an AppWrapper
+func countTestAddItem(appWrapper *mcadv1beta1.AppWrapper, raw string) *mcadv1beta1.AppWrapper {
+	appWrapper.Spec.Resources.GenericItems = append(appWrapper.Spec.Resources.GenericItems,
+		mcadv1beta1.GenericItem{GenericTemplate: runtime.RawExtension{Raw: []byte(raw)}})
+	return appWrapper
+}
+
+func TestGetObjectCountsForAppWrapper(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		counts map[string]string // expected counts, other keys must be absent
+	}{
+		{
+			name:   "pod",
+			counts: map[string]string{},
+		},
+		{
+			name: "load balancer service",
+			raw:  countTestService,
+			counts: map[string]string{
+				"count/services":         "1",
+				"services":               "1",
+				"services.loadbalancers": "1",
+				"services.nodeports":     "2",
+			},
+		},
+		{
+			name: "persistent volume claim",
+			raw:  countTestClaim,
+			counts: map[string]string{
+				"count/persistentvolumeclaims": "1",
+				"persistentvolumeclaims":       "1",
+				"storage":                      "10Gi",
+				"fast.storageclass.storage.k8s.io/persistentvolumeclaims": "1",
+				"fast.storageclass.storage.k8s.io/requests.storage":       "10Gi",
+			},
+		},
+		{
+			name:   "job",
+			raw:    countTestJob,
+			counts: map[string]string{"count/jobs.batch": "1"},
+		},
+		{
+			name:   "unknown kind",
+			raw:    countTestUnknown,
+			counts: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appWrapper := dispatchTestAppWrapper("aw", 0, "1")
+			if tt.raw != "" {
+				countTestAddItem(appWrapper, tt.raw)
+			}
+			counts := getObjectCountsForAppWrapper(dispatchTestRESTMapper(), appWrapper, nil).AsResources()
+			if len(counts) != len(tt.counts) {
+				t.Errorf("counts = %v, want %v", counts, tt.counts)
+			}
+			for key, expected := range tt.counts {
+				if count, ok := counts[v1.ResourceName(key)]; !ok || count.Cmp(resource.MustParse(expected)) != 0 {
+					t.Errorf("count %s = %v, want %s", key, count.String(), expected)
+				}
+			}
+		})
+	}
+}
+
+func TestGetInFlightAppWrappersObjectCounts(t *testing.T) {
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "service"}}
+	tests := []struct {
+		name     string
+		step     mcadv1beta1.AppWrapperStep
+		existing []client.Object
+		counts   map[string]string // expected counts of services and claims, other keys are not checked
+	}{
+		{
+			name:   "dispatching",
+			step:   mcadv1beta1.Dispatching,
+			counts: map[string]string{"count/services": "1", "count/persistentvolumeclaims": "1"},
+		},
+		{
+			name:   "creating without created objects",
+			step:   mcadv1beta1.Creating,
+			counts: map[string]string{"count/services": "1", "count/persistentvolumeclaims": "1"},
+		},
+		{
+			name:     "creating with created service",
+			step:     mcadv1beta1.Creating,
+			existing: []client.Object{service},
+			counts:   map[string]string{"count/persistentvolumeclaims": "1"},
+		},
+		{
+			name:     "created",
+			step:     mcadv1beta1.Created,
+			existing: []client.Object{service},
+			counts:   map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appWrapper := countTestAddItem(countTestAddItem(dispatchTestAppWrapper("aw", 0, "1"), countTestService), countTestClaim)
+			dispatchTestRunning(appWrapper, time.Now()).Status.Step = tt.step
+			r := dispatchTestDispatcher(t, append(tt.existing, appWrapper)...)
+			inFlight, err := r.getInFlightAppWrappers(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(inFlight["default"]) != 1 {
+				t.Fatalf("in-flight AppWrappers = %v, want aw", inFlight)
+			}
+			counts := inFlight["default"][0].objectCounts.AsResources()
+			for _, key := range []v1.ResourceName{"count/services", "count/persistentvolumeclaims"} {
+				count, ok := counts[key]
+				if expected, counted := tt.counts[string(key)]; ok != counted || counted && count.Cmp(resource.MustParse(expected)) != 0 {
+					t.Errorf("count %s = %v (found %v), want %q", key, count.String(), ok, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestGetPodCountsForAppWrapper(t *testing.T) {
+	appWrapper := dispatchTestAppWrapper("aw", 0, "1")
+	appWrapper.Spec.Resources.GenericItems[0].CustomPodResources[0].Replicas = 3
+	counts := getPodCountsForAppWrapper(appWrapper)
+	for _, key := range []v1.ResourceName{v1.ResourcePods, "count/pods"} {
+		if count := counts.AsResources()[key]; count.Cmp(resource.MustParse("3")) != 0 {
+			t.Errorf("count %s = %v, want 3", key, count.String())
+		}
+	}
+}
+
+func TestSelectForDispatchObjectCountQuota(t *testing.T) {
+	tests := []struct {
+		name     string
+		hard     v1.ResourceList
+		used     v1.ResourceList
+		selected bool
+		message  string // expected substring of the queuing decision if not selected
+	}{
+		{
+			name:     "count quota with room",
+			hard:     v1.ResourceList{"count/services": resource.MustParse("2")},
+			used:     v1.ResourceList{"count/services": resource.MustParse("1")},
+			selected: true,
+		},
+		{
+			name:    "exhausted count quota",
+			hard:    v1.ResourceList{"count/services": resource.MustParse("1")},
+			used:    v1.ResourceList{"count/services": resource.MustParse("1")},
+			message: "Insufficient count/services in resource quota quota",
+		},
+		{
+			name:    "exhausted load balancer quota",
+			hard:    v1.ResourceList{v1.ResourceServicesLoadBalancers: resource.MustParse("0")},
+			used:    v1.ResourceList{v1.ResourceServicesLoadBalancers: resource.MustParse("0")},
+			message: "Insufficient services.loadbalancers",
+		},
+		{
+			name:    "exhausted storage class quota",
+			hard:    v1.ResourceList{"fast.storageclass.storage.k8s.io/requests.storage": resource.MustParse("15Gi")},
+			used:    v1.ResourceList{"fast.storageclass.storage.k8s.io/requests.storage": resource.MustParse("10Gi")},
+			message: "Insufficient fast.storageclass.storage.k8s.io/requests.storage",
+		},
+		{
+			name:     "pod quota with room",
+			hard:     v1.ResourceList{v1.ResourcePods: resource.MustParse("2")},
+			used:     v1.ResourceList{v1.ResourcePods: resource.MustParse("1")},
+			selected: true,
+		},
+		{
+			name:    "exhausted pod quota",
+			hard:    v1.ResourceList{v1.ResourcePods: resource.MustParse("1")},
+			used:    v1.ResourceList{v1.ResourcePods: resource.MustParse("1")},
+			message: "Insufficient pods",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appWrapper := countTestAddItem(countTestAddItem(dispatchTestAppWrapper("aw", 0, "1"), countTestService), countTestClaim)
+			quota := &v1.ResourceQuota{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "quota"},
+				Spec:       v1.ResourceQuotaSpec{Hard: tt.hard},
+				Status:     v1.ResourceQuotaStatus{Hard: tt.hard, Used: tt.used},
+			}
+			r := dispatchTestDispatcher(t, dispatchTestCluster("4"), appWrapper, quota)
+			selected := dispatchTestSelect(t, r)
+			if (len(selected) == 1) != tt.selected {
+				t.Errorf("selected %v, want selected %v", selected, tt.selected)
+			}
+			if tt.selected {
+				return
+			}
+			decision := r.Decisions["aw"]
+			if decision == nil || decision.reason != mcadv1beta1.QueuedInsufficientQuota || !strings.Contains(decision.message, tt.message) {
+				t.Errorf("decision = %+v, want %v with message containing %q", decision, mcadv1beta1.QueuedInsufficientQuota, tt.message)
+			}
+		})
+	}
+}
@@ -17,30 +17,41 @@ limitations under the License.
 package controller
 
 import (
+	"slices"
 	"strings"
 
 	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // Should be defined in api/core/v1/types.go
 const DefaultResourceLimitsPrefix = "limits."
 
-// A tracker of allocated quota, mapped by namespace
+// A tracker of allocated quota, mapped by ResourceQuota
 type QuotaTracker struct {
-	// state of quotas, used, and allocated amounts
+	// state of quotas, used, and allocated amounts, mapped by ResourceQuota namespace/name
 	state map[string]*QuotaState
 
-	// used amounts by dispatched AppWrappers partially unaccounted by the ResourceQuota,
+	// dispatched AppWrappers partially unaccounted by the ResourceQuota objects, mapped by namespace,
 	// as some pods may have not passed the ResourceQuota admission controller
-	unAdmittedWeightsMap map[string]*WeightsPair
+	inFlight map[string][]*inFlightAppWrapper
+}
+
+// A dispatched AppWrapper with resource demand possibly not yet reflected in the Used status of the ResourceQuota objects
+type inFlightAppWrapper struct {
+	appWrapper *mcadv1beta1.AppWrapper
+	// counts of the wrapped objects not created yet, if any
+	objectCounts Weights
+	// pods already created, hence admitted by the ResourceQuota admission controller
+	pods []v1.Pod
 }
 
 // Create a new QuotaTracker
 func NewQuotaTracker() *QuotaTracker {
 	return &QuotaTracker{
-		state:                map[string]*QuotaState{},
-		unAdmittedWeightsMap: map[string]*WeightsPair{},
+		state:    map[string]*QuotaState{},
+		inFlight: map[string][]*inFlightAppWrapper{},
 	}
 }
 
@@ -52,56 +63,86 @@ type QuotaState struct {
 	used *WeightsPair
 	// allocated amount by dispatched AppWrappers in the current dispatching cycle
 	allocated *WeightsPair
+	// amount requested by in-flight AppWrappers not yet admitted, restricted to the pods matching the quota scopes
+	unAdmitted *WeightsPair
 }
 
 // Create a QuotaState from a ResourceQuota object
 func NewQuotaStateFromResourceQuota(resourceQuota *v1.ResourceQuota) *QuotaState {
 	quotaWeights, usedWeights := getQuotaAndUsedWeightsPairsForResourceQuota(resourceQuota)
 	return &QuotaState{
-		quota:     quotaWeights,
-		used:      usedWeights,
-		allocated: NewWeightsPair(Weights{}, Weights{}),
+		quota:      quotaWeights,
+		used:       usedWeights,
+		allocated:  NewWeightsPair(Weights{}, Weights{}),
+		unAdmitted: NewWeightsPair(Weights{}, Weights{}),
 	}
 }
 
 // Account for all in-flight AppWrappers with their resource demand not yet reflected in
 // the Used status of any ResourceQuota object in their corresponding namespace
-func (tracker *QuotaTracker) Init(weightsPairMap map[string]*WeightsPair) {
-	tracker.unAdmittedWeightsMap = weightsPairMap
+func (tracker *QuotaTracker) Init(inFlight map[string][]*inFlightAppWrapper) {
+	tracker.inFlight = inFlight
+}
+
+// Compute the demand of the in-flight AppWrappers in the namespace of a ResourceQuota not yet admitted,
+// only accounting for the generic items and pods matching the scopes of the ResourceQuota
+func (tracker *QuotaTracker) unAdmittedWeights(resourceQuota *v1.ResourceQuota) *WeightsPair {
+	unAdmitted := NewWeightsPair(Weights{}, Weights{})
+	for _, inFlight := range tracker.inFlight[resourceQuota.GetNamespace()] {
+		weightsPair := getWeightsPairForAppWrapperInQuota(inFlight.appWrapper, resourceQuota, inFlight.objectCounts)
+		// subtract weights for admitted (created) pods matching the scopes
+		// (already accounted for in the used status of the resourceQuota)
+		admitted := NewWeightsPair(Weights{}, Weights{})
+		for i := range inFlight.pods {
+			pod := &inFlight.pods[i]
+			if quotaMatchesPodSpec(resourceQuota, &pod.Spec) {
+				admitted.Add(NewWeightsPairForPod(pod))
+				admitted.requests.Add(podCounts())
+			}
+		}
+		weightsPair.QuotaSub(admitted)
+		nonNegativeWeightsPair := NewWeightsPair(Weights{}, Weights{})
+		nonNegativeWeightsPair.Max(weightsPair)
+		unAdmitted.Add(nonNegativeWeightsPair)
+	}
+	return unAdmitted
+}
+
+// Key of a ResourceQuota in the QuotaTracker
+func quotaKey(resourceQuota *v1.ResourceQuota) string {
+	return resourceQuota.GetNamespace() + "/" + resourceQuota.GetName()
 }
 
 // Check if the resource demand of an AppWrapper satisfies a ResourceQuota,
 // without changing the current quota allocation, returning resource names with insufficient quota
+// In-flight demand not yet admitted is only accounted for if matching the scopes of the ResourceQuota
 func (tracker *QuotaTracker) Satisfies(appWrapperAskWeights *WeightsPair, resourceQuota *v1.ResourceQuota) (bool, []v1.ResourceName) {
-	namespace := resourceQuota.GetNamespace()
 	var quotaState *QuotaState
 	var exists bool
-	if quotaState, exists = tracker.state[namespace]; !exists {
+	if quotaState, exists = tracker.state[quotaKey(resourceQuota)]; !exists {
 		quotaState = NewQuotaStateFromResourceQuota(resourceQuota)
-		tracker.state[namespace] = quotaState
+		quotaState.unAdmitted = tracker.unAdmittedWeights(resourceQuota)
+		tracker.state[quotaKey(resourceQuota)] = quotaState
 	}
 	// check if both appwrapper requests and limits fit available resource quota
 	quotaWeights := quotaState.quota.Clone()
 	quotaWeights.QuotaSub(quotaState.used)
 	quotaWeights.QuotaSub(quotaState.allocated)
-	var unAdmittedWeights *WeightsPair
-	if unAdmittedWeights, exists = tracker.unAdmittedWeightsMap[namespace]; exists {
-		quotaWeights.QuotaSub(unAdmittedWeights)
-	}
+	quotaWeights.QuotaSub(quotaState.unAdmitted)
 	quotaFits, insufficientResources := appWrapperAskWeights.Fits(quotaWeights)
 
-	// mcadLog.Info("QuotaTracker.Satisfies():", "namespace", namespace,
+	// mcadLog.Info("QuotaTracker.Satisfies():", "namespace", resourceQuota.GetNamespace(),
 	// 	"QuotaWeights", quotaState.quota, "UsedWeights", quotaState.used,
-	// 	"AllocatedWeights", quotaState.allocated, "unAdmittedWeights", unAdmittedWeights,
+	// 	"AllocatedWeights", quotaState.allocated, "unAdmittedWeights", quotaState.unAdmitted,
 	// 	"AvailableWeights", quotaWeights, "appWrapperAskWeights", appWrapperAskWeights,
 	// 	"quotaFits", quotaFits)
 	return quotaFits, insufficientResources
 }
 
-// Update the QuotaState by the allocated weights of an AppWrapper in a namespace,
+// Update the QuotaState of a ResourceQuota by the allocated weights of an AppWrapper,
 // fails if QuotaState does not exist in the QuotaTracker
-func (tracker *QuotaTracker) Allocate(namespace string, appWrapperAskWeights *WeightsPair) bool {
-	if state, exists := tracker.state[namespace]; exists && appWrapperAskWeights != nil {
+func (tracker *QuotaTracker) Allocate(resourceQuota *v1.ResourceQuota, appWrapperAskWeights *WeightsPair) bool {
+	if state, exists := tracker.state[quotaKey(resourceQuota)]; exists && appWrapperAskWeights != nil {
 		state.allocated.Add(appWrapperAskWeights)
 		return true
 	}
@@ -115,6 +156,151 @@ func getWeightsPairForAppWrapper(appWrapper *mcadv1beta1.AppWrapper) *WeightsPai
 	return NewWeightsPair(requests, limits)
 }
 
+// Get requests and limits from the specs of the AppWrapper items with pods matching the scopes of a ResourceQuota
+// Requests include the pod counts and, if the ResourceQuota has no scopes, the given object counts
+func getWeightsPairForAppWrapperInQuota(appWrapper *mcadv1beta1.AppWrapper, resourceQuota *v1.ResourceQuota, objectCounts Weights) *WeightsPair {
+	if len(resourceQuota.Spec.Scopes) == 0 && resourceQuota.Spec.ScopeSelector == nil {
+		weightsPair := getWeightsPairForAppWrapper(appWrapper)
+		weightsPair.requests.Add(getPodCountsForAppWrapper(appWrapper))
+		weightsPair.requests.Add(objectCounts)
+		return weightsPair
+	}
+	requests := Weights{}
+	limits := Weights{}
+	for i, item := range appWrapper.Spec.Resources.GenericItems {
+		matches := false
+		for _, spec := range podSpecsForItem(appWrapper, i) {
+			if quotaMatchesPodSpec(resourceQuota, spec) {
+				matches = true
+				break
+			}
+		}
+		if !matches {
+			continue
+		}
+		for _, cpr := range item.CustomPodResources {
+			requests.AddProd(cpr.Replicas, NewWeights(cpr.Requests))
+			requests.AddProd(cpr.Replicas, podCounts())
+			limits.AddProd(cpr.Replicas, NewWeights(cpr.Limits))
+		}
+	}
+	return NewWeightsPair(requests, limits)
+}
+
+// Extract the pod specs from the template of a generic item
+func podSpecsForItem(appWrapper *mcadv1beta1.AppWrapper, item int) []*v1.PodSpec {
+	obj, err := parseResource(appWrapper, item, appWrapper.Spec.Resources.GenericItems[item].GenericTemplate.Raw)
+	if err != nil {
+		return nil
+	}
+	specs := []*v1.PodSpec{}
+	collectPodSpecs(obj.UnstructuredContent(), &specs)
+	return specs
+}
+
+// Collect pod specs in maps, i.e., specs with containers
+func collectPodSpecs(v interface{}, specs *[]*v1.PodSpec) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if spec, ok := v["spec"].(map[string]interface{}); ok {
+			if _, ok := spec["containers"]; ok {
+				podSpec := &v1.PodSpec{}
+				if err := runtime.DefaultUnstructuredConverter.FromUnstructured(spec, podSpec); err == nil {
+					*specs = append(*specs, podSpec)
+				}
+			}
+		}
+		for _, w := range v {
+			collectPodSpecs(w, specs)
+		}
+	case []interface{}:
+		for _, w := range v {
+			collectPodSpecs(w, specs)
+		}
+	}
+}
+
+// Check if a pod spec matches all the scopes of a ResourceQuota
+func quotaMatchesPodSpec(resourceQuota *v1.ResourceQuota, spec *v1.PodSpec) bool {
+	requirements := []v1.ScopedResourceSelectorRequirement{}
+	for _, scope := range resourceQuota.Spec.Scopes {
+		requirements = append(requirements, v1.ScopedResourceSelectorRequirement{ScopeName: scope, Operator: v1.ScopeSelectorOpExists})
+	}
+	if resourceQuota.Spec.ScopeSelector != nil {
+		requirements = append(requirements, resourceQuota.Spec.ScopeSelector.MatchExpressions...)
+	}
+	for _, requirement := range requirements {
+		if !podSpecMatchesScope(requirement, spec) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check if a pod spec matches a scope selector requirement
+func podSpecMatchesScope(requirement v1.ScopedResourceSelectorRequirement, spec *v1.PodSpec) bool {
+	switch requirement.ScopeName {
+	case v1.ResourceQuotaScopeTerminating:
+		return spec.ActiveDeadlineSeconds != nil && *spec.ActiveDeadlineSeconds >= 0
+	case v1.ResourceQuotaScopeNotTerminating:
+		return spec.ActiveDeadlineSeconds == nil || *spec.ActiveDeadlineSeconds < 0
+	case v1.ResourceQuotaScopeBestEffort:
+		return isBestEffort(spec)
+	case v1.ResourceQuotaScopeNotBestEffort:
+		return !isBestEffort(spec)
+	case v1.ResourceQuotaScopePriorityClass:
+		switch requirement.Operator {
+		case v1.ScopeSelectorOpExists:
+			return spec.PriorityClassName != ""
+		case v1.ScopeSelectorOpDoesNotExist:
+			return spec.PriorityClassName == ""
+		case v1.ScopeSelectorOpIn:
+			return slices.Contains(requirement.Values, spec.PriorityClassName)
+		case v1.ScopeSelectorOpNotIn:
+			return !slices.Contains(requirement.Values, spec.PriorityClassName)
+		}
+	case v1.ResourceQuotaScopeCrossNamespacePodAffinity:
+		return usesCrossNamespacePodAffinity(spec)
+	}
+	return false
+}
+
+// Check if no container of a pod spec has requests or limits
+func isBestEffort(spec *v1.PodSpec) bool {
+	for _, container := range append(spec.InitContainers, spec.Containers...) {
+		if len(container.Resources.Requests) > 0 || len(container.Resources.Limits) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Check if a pod spec has pod affinity or anti-affinity terms selecting other namespaces
+func usesCrossNamespacePodAffinity(spec *v1.PodSpec) bool {
+	if spec.Affinity == nil {
+		return false
+	}
+	terms := []v1.PodAffinityTerm{}
+	if a := spec.Affinity.PodAffinity; a != nil {
+		terms = append(terms, a.RequiredDuringSchedulingIgnoredDuringExecution...)
+		for _, t := range a.PreferredDuringSchedulingIgnoredDuringExecution {
+			terms = append(terms, t.PodAffinityTerm)
+		}
+	}
+	if a := spec.Affinity.PodAntiAffinity; a != nil {
+		terms = append(terms, a.RequiredDuringSchedulingIgnoredDuringExecution...)
+		for _, t := range a.PreferredDuringSchedulingIgnoredDuringExecution {
+			terms = append(terms, t.PodAffinityTerm)
+		}
+	}
+	for _, term := range terms {
+		if len(term.Namespaces) > 0 || term.NamespaceSelector != nil {
+			return true
+		}
+	}
+	return false
+}
+
 // Get requests and limits for both quota and used from ResourceQuota object
 func getQuotaAndUsedWeightsPairsForResourceQuota(resourceQuota *v1.ResourceQuota) (quotaWeights *WeightsPair,
 	usedWeights *WeightsPair) {
@@ -0,0 +1,121 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+// Build an AppWrapper with one pod item with the given replicas, with cpu requests unless best effort
+func quotaTestAppWrapper(name string, replicas int32, bestEffort bool) *mcadv1beta1.AppWrapper {
+	resources := ""
+	requests := v1.ResourceList{}
+	if !bestEffort {
+		resources = `, "resources": {"requests": {"cpu": "1"}}`
+		requests[v1.ResourceCPU] = resource.MustParse("1")
+	}
+	raw := fmt.Sprintf(`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "%s"},
+		"spec": {"containers": [{"name": "busybox", "image": "busybox"%s}]}}`, name, resources)
+	return &mcadv1beta1.AppWrapper{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec: mcadv1beta1.AppWrapperSpec{
+			Resources: mcadv1beta1.AppWrapperResources{
+				GenericItems: []mcadv1beta1.GenericItem{{
+					GenericTemplate:    runtime.RawExtension{Raw: []byte(raw)},
+					CustomPodResources: []mcadv1beta1.CustomPodResource{{Replicas: replicas, Requests: requests}},
+				}},
+			},
+		},
+	}
+}
+
+// Build a ResourceQuota on pod counts with the given scopes
+func quotaTestResourceQuota(name string, pods string, scopes ...v1.ResourceQuotaScope) *v1.ResourceQuota {
+	hard := v1.ResourceList{v1.ResourcePods: resource.MustParse(pods)}
+	return &v1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec:       v1.ResourceQuotaSpec{Hard: hard, Scopes: scopes},
+		Status:     v1.ResourceQuotaStatus{Hard: hard, Used: v1.ResourceList{}},
+	}
+}
+
+func TestQuotaTrackerSatisfiesInFlightScopes(t *testing.T) {
+	inFlight := quotaTestAppWrapper("in-flight", 2, false)
+	admittedPod := v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{{Name: "busybox", Resources: v1.ResourceRequirements{
+		Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}}}}}}
+
+	tests := []struct {
+		name     string
+		quota    *v1.ResourceQuota
+		admitted []v1.Pod // pods of the in-flight AppWrapper already created
+		ask      *mcadv1beta1.AppWrapper
+		fits     bool
+	}{
+		{
+			name:  "unscoped quota counts in-flight pods",
+			quota: quotaTestResourceQuota("all", "2"),
+			ask:   quotaTestAppWrapper("best-effort", 1, true),
+			fits:  false,
+		},
+		{
+			name:     "unscoped quota ignores admitted pods",
+			quota:    quotaTestResourceQuota("all", "2"),
+			admitted: []v1.Pod{admittedPod},
+			ask:      quotaTestAppWrapper("best-effort", 1, true),
+			fits:     true,
+		},
+		{
+			name:  "best effort quota ignores in-flight pods with requests",
+			quota: quotaTestResourceQuota("best-effort", "1", v1.ResourceQuotaScopeBestEffort),
+			ask:   quotaTestAppWrapper("best-effort", 1, true),
+			fits:  true,
+		},
+		{
+			name:  "not best effort quota counts in-flight pods with requests",
+			quota: quotaTestResourceQuota("not-best-effort", "2", v1.ResourceQuotaScopeNotBestEffort),
+			ask:   quotaTestAppWrapper("burstable", 1, false),
+			fits:  false,
+		},
+		{
+			name:     "not best effort quota ignores admitted pods",
+			quota:    quotaTestResourceQuota("not-best-effort", "2", v1.ResourceQuotaScopeNotBestEffort),
+			admitted: []v1.Pod{admittedPod},
+			ask:      quotaTestAppWrapper("burstable", 1, false),
+			fits:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewQuotaTracker()
+			tracker.Init(map[string][]*inFlightAppWrapper{
+				"default": {{appWrapper: inFlight, pods: tt.admitted}},
+			})
+			ask := getWeightsPairForAppWrapperInQuota(tt.ask, tt.quota, nil)
+			if fits, _ := tracker.Satisfies(ask, tt.quota); fits != tt.fits {
+				t.Errorf("Satisfies() = %v, want %v", fits, tt.fits)
+			}
+		})
+	}
+}
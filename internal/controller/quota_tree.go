@@ -0,0 +1,344 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"gopkg.in/inf.v0"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+// A tracker of the quota tree defined by QuotaNode objects
+// Every quota node on the path from the leaf quota node of an AppWrapper namespace to the root must have enough quota
+// A quota node may use its guaranteed resources plus the resources it may borrow from the idle quota of its siblings
+// A root quota node has nothing to borrow from
+type QuotaTree struct {
+	// quota nodes by name
+	nodes map[string]*quotaTreeNode
+
+	// leaf quota nodes by namespace
+	leaves map[string]*quotaTreeNode
+}
+
+// A quota node in a quota tree
+type quotaTreeNode struct {
+	// QuotaNode object
+	quotaNode *mcadv1beta1.QuotaNode
+
+	// parent quota node, nil for a root quota node
+	parent *quotaTreeNode
+
+	// guaranteed resources
+	guaranteed Weights
+
+	// max usage, i.e., guaranteed resources plus borrowable resources except for a root quota node
+	limit Weights
+
+	// resources used by the dispatched AppWrappers of this quota node and its descendants
+	used Weights
+
+	// dispatched AppWrappers of this leaf quota node
+	allocations []*quotaAllocation
+
+	// Misconfigured condition of the quota node
+	condition metav1.Condition
+}
+
+// Quota used by a dispatched AppWrapper
+type quotaAllocation struct {
+	// dispatched AppWrapper (shallow copy, must be cloned if mutated)
+	appWrapper *mcadv1beta1.AppWrapper
+
+	// requested resources
+	request Weights
+
+	// is the AppWrapper being requeued or deleted?
+	releasing bool
+
+	// may the AppWrapper be requeued to reclaim its quota?
+	reclaimable bool
+}
+
+// Create a QuotaTree from QuotaNode objects
+func NewQuotaTree(quotaNodes []mcadv1beta1.QuotaNode) *QuotaTree {
+	tree := &QuotaTree{nodes: map[string]*quotaTreeNode{}, leaves: map[string]*quotaTreeNode{}}
+	for i := range quotaNodes {
+		quotaNode := &quotaNodes[i]
+		tree.nodes[quotaNode.Name] = &quotaTreeNode{
+			quotaNode:  quotaNode,
+			guaranteed: NewWeights(quotaNode.Spec.Guaranteed),
+			used:       Weights{},
+		}
+	}
+	for _, node := range tree.nodes {
+		node.parent = tree.nodes[node.quotaNode.Spec.Parent] // nil if no parent or unknown parent
+		node.limit = node.guaranteed.Clone()
+		if node.parent != nil {
+			node.limit.Add(NewWeights(node.quotaNode.Spec.Borrowable))
+		}
+		for _, namespace := range node.quotaNode.Spec.Namespaces {
+			tree.leaves[namespace] = node
+		}
+	}
+	tree.validate()
+	return tree
+}
+
+// Compute the Misconfigured condition of every quota node
+// Misconfigured quota nodes are still accounted for: a quota node with an unknown parent is a root quota node,
+// paths to the root stop before repeating a quota node, and the namespaces of inner quota nodes are still mapped
+func (tree *QuotaTree) validate() {
+	children := map[*quotaTreeNode]int{}
+	for _, node := range tree.nodes {
+		if node.parent != nil {
+			children[node.parent]++
+		}
+	}
+	for _, node := range tree.nodes {
+		node.condition = metav1.Condition{
+			Type:    mcadv1beta1.QuotaNodeMisconfigured,
+			Status:  metav1.ConditionTrue,
+			Reason:  mcadv1beta1.QuotaNodeWellFormed,
+			Message: "Quota node is part of a valid quota tree",
+		}
+		switch {
+		case node.quotaNode.Spec.Parent != "" && node.parent == nil:
+			node.condition.Reason = mcadv1beta1.QuotaNodeUnknownParent
+			node.condition.Message = fmt.Sprintf("Unknown parent quota node %s, quota node treated as a root quota node", node.quotaNode.Spec.Parent)
+		case node.ancestor(node):
+			node.condition.Reason = mcadv1beta1.QuotaNodeParentCycle
+			node.condition.Message = "Quota node is its own ancestor"
+		case children[node] > 0 && len(node.quotaNode.Spec.Namespaces) > 0:
+			node.condition.Reason = mcadv1beta1.QuotaNodeInnerNamespaces
+			node.condition.Message = "Quota node has children and namespaces, namespaces are only permitted for leaf quota nodes"
+		default:
+			node.condition.Status = metav1.ConditionFalse
+		}
+	}
+}
+
+// Is the given quota node an ancestor of this quota node?
+func (node *quotaTreeNode) ancestor(other *quotaTreeNode) bool {
+	visited := map[*quotaTreeNode]bool{}
+	for n := node.parent; n != nil && !visited[n]; n = n.parent {
+		if n == other {
+			return true
+		}
+		visited[n] = true
+	}
+	return false
+}
+
+// Return the path from the leaf quota node of an AppWrapper to the root, nil if AppWrapper has no quota node
+func (tree *QuotaTree) path(appWrapper *mcadv1beta1.AppWrapper) []*quotaTreeNode {
+	path := []*quotaTreeNode{}
+	visited := map[*quotaTreeNode]bool{}
+	for node := tree.leaves[appWrapper.Namespace]; node != nil && !visited[node]; node = node.parent {
+		visited[node] = true // stop on cycles
+		path = append(path, node)
+	}
+	if len(path) == 0 {
+		return nil
+	}
+	return path
+}
+
+// Record the quota used by a dispatched AppWrapper in the given state and step
+func (tree *QuotaTree) AddAppWrapper(appWrapper *mcadv1beta1.AppWrapper, state mcadv1beta1.AppWrapperState, step mcadv1beta1.AppWrapperStep) {
+	path := tree.path(appWrapper)
+	if path == nil {
+		return
+	}
+	releasing := step == mcadv1beta1.Deleting || step == mcadv1beta1.Deleted
+	allocation := &quotaAllocation{
+		appWrapper:  appWrapper,
+		request:     aggregateRequests(appWrapper),
+		releasing:   releasing,
+		reclaimable: state == mcadv1beta1.Running && step == mcadv1beta1.Created,
+	}
+	path[0].allocations = append(path[0].allocations, allocation)
+	if !releasing {
+		for _, node := range path {
+			node.used.Add(allocation.request)
+		}
+	}
+}
+
+// Check if the requests of an AppWrapper fit its quota
+// Return the first quota node with insufficient quota if any and the resource names with insufficient quota
+func (tree *QuotaTree) Fits(appWrapper *mcadv1beta1.AppWrapper) (bool, string, []v1.ResourceName) {
+	request := aggregateRequests(appWrapper)
+	for _, node := range tree.path(appWrapper) {
+		available := node.limit.Clone()
+		available.QuotaSub(node.used)
+		if fits, insufficient := request.QuotaFits(available); !fits {
+			return false, node.quotaNode.Name, insufficient
+		}
+	}
+	return true, "", nil
+}
+
+// Record the quota allocated to an AppWrapper selected for dispatch
+func (tree *QuotaTree) Allocate(appWrapper *mcadv1beta1.AppWrapper) {
+	tree.AddAppWrapper(appWrapper, mcadv1beta1.Running, mcadv1beta1.Dispatching)
+}
+
+// Select AppWrappers borrowing quota to requeue so that the requests of an AppWrapper fit its quota
+// Quota is only reclaimed if the AppWrapper requests fit the guaranteed resources of its quota nodes except for the root
+// AppWrappers are only reclaimed from quota nodes using more than their guaranteed resources outside of the AppWrapper path
+// Most recently dispatched AppWrappers are selected first
+// Return no AppWrappers if reclaiming borrowed quota cannot make room for the AppWrapper
+func (tree *QuotaTree) Reclaim(appWrapper *mcadv1beta1.AppWrapper) []*mcadv1beta1.AppWrapper {
+	path := tree.path(appWrapper)
+	if path == nil {
+		return nil
+	}
+	request := aggregateRequests(appWrapper)
+	onPath := map[*quotaTreeNode]bool{}
+	for i, node := range path {
+		onPath[node] = true
+		if i == len(path)-1 {
+			break // root quota node has no guarantee to honor beyond its limit
+		}
+		available := node.guaranteed.Clone()
+		available.QuotaSub(node.used)
+		if fits, _ := request.QuotaFits(available); !fits {
+			return nil // AppWrapper would borrow quota itself
+		}
+	}
+	// collect AppWrappers sharing the root quota node with the AppWrapper
+	root := path[len(path)-1]
+	candidates := []*quotaAllocation{}
+	for _, node := range tree.nodes {
+		for _, allocation := range node.allocations {
+			if allocation.reclaimable && !allocation.releasing {
+				if p := tree.path(allocation.appWrapper); p != nil && p[len(p)-1] == root {
+					candidates = append(candidates, allocation)
+				}
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		ti := candidates[i].appWrapper.Status.DispatchTimestamp
+		tj := candidates[j].appWrapper.Status.DispatchTimestamp
+		if !ti.Equal(&tj) {
+			return tj.Before(&ti)
+		}
+		return candidates[i].appWrapper.UID < candidates[j].appWrapper.UID
+	})
+	victims := []*quotaAllocation{}
+	for _, candidate := range candidates {
+		if fits, _, _ := tree.Fits(appWrapper); fits {
+			break
+		}
+		// only reclaim from a quota node borrowing outside of the AppWrapper path
+		borrowing := false
+		for _, node := range tree.path(candidate.appWrapper) {
+			if !onPath[node] && node.borrowing() {
+				borrowing = true
+				break
+			}
+		}
+		if !borrowing {
+			continue
+		}
+		tree.release(candidate)
+		victims = append(victims, candidate)
+	}
+	if fits, _, _ := tree.Fits(appWrapper); !fits {
+		// undo
+		for _, victim := range victims {
+			victim.releasing = false
+			for _, node := range tree.path(victim.appWrapper) {
+				node.used.Add(victim.request)
+			}
+		}
+		return nil
+	}
+	result := make([]*mcadv1beta1.AppWrapper, len(victims))
+	for i, victim := range victims {
+		result[i] = victim.appWrapper
+	}
+	return result
+}
+
+// Stop accounting for the quota used by a dispatched AppWrapper selected for preemption
+func (tree *QuotaTree) Release(appWrapper *mcadv1beta1.AppWrapper) {
+	if path := tree.path(appWrapper); path != nil {
+		for _, allocation := range path[0].allocations {
+			if allocation.appWrapper.UID == appWrapper.UID && !allocation.releasing {
+				tree.release(allocation)
+			}
+		}
+	}
+}
+
+// Stop accounting for the quota used by an AppWrapper being requeued
+func (tree *QuotaTree) release(allocation *quotaAllocation) {
+	allocation.releasing = true
+	for _, node := range tree.path(allocation.appWrapper) {
+		node.used.Sub(allocation.request)
+	}
+}
+
+// Is a quota node using more than its guaranteed resources?
+func (node *quotaTreeNode) borrowing() bool {
+	fits, _ := node.used.QuotaFits(node.guaranteed)
+	return !fits
+}
+
+// Return the resources borrowed by a quota node
+func (node *quotaTreeNode) borrowed() Weights {
+	borrowed := Weights{}
+	for k, v := range node.used {
+		if g, ok := node.guaranteed[k]; ok && v.Cmp(g) > 0 {
+			borrowed[k] = new(inf.Dec).Sub(v, g)
+		}
+	}
+	return borrowed
+}
+
+// Update the status of the quota nodes with changed used or borrowed resources or Misconfigured condition
+// The tree must only account for dispatched AppWrappers, not for AppWrappers selected in the current dispatch cycle
+func (r *Dispatcher) updateQuotaNodes(ctx context.Context, tree *QuotaTree) {
+	for _, node := range tree.nodes {
+		used := node.used.AsResources()
+		borrowed := node.borrowed().AsResources()
+		quotaNode := node.quotaNode.DeepCopy()
+		changed := meta.SetStatusCondition(&quotaNode.Status.Conditions, node.condition)
+		if changed && node.condition.Status == metav1.ConditionTrue {
+			mcadLog.Info("Misconfigured quota node", "quotaNode", quotaNode.Name, "reason", node.condition.Reason, "message", node.condition.Message)
+		}
+		if !changed && equality.Semantic.DeepEqual(used, quotaNode.Status.Used) && equality.Semantic.DeepEqual(borrowed, quotaNode.Status.Borrowed) {
+			continue
+		}
+		quotaNode.Status.Used = used
+		quotaNode.Status.Borrowed = borrowed
+		quotaNode.Status.Time = metav1.Now()
+		if err := r.Status().Update(ctx, quotaNode); err != nil {
+			mcadLog.Error(err, "Quota node status update error", "quotaNode", quotaNode.Name)
+		}
+	}
+}
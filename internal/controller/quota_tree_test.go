@@ -0,0 +1,361 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+// Build a quota node guaranteeing and permitting to borrow the given CPUs
+func quotaTreeTestNode(name string, parent string, guaranteed string, borrowable string, namespaces ...string) mcadv1beta1.QuotaNode {
+	quotaNode := mcadv1beta1.QuotaNode{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: mcadv1beta1.QuotaNodeSpec{
+			Parent:     parent,
+			Namespaces: namespaces,
+			Guaranteed: v1.ResourceList{v1.ResourceCPU: resource.MustParse(guaranteed)},
+		},
+	}
+	if borrowable != "" {
+		quotaNode.Spec.Borrowable = v1.ResourceList{v1.ResourceCPU: resource.MustParse(borrowable)}
+	}
+	return quotaNode
+}
+
+// Build a quota tree with a root quota node guaranteeing the given CPUs
+// Quota node team-a guarantees 4 CPUs to namespace a and may borrow 4 more
+// Quota node team-b guarantees 6 CPUs to namespace b and may borrow 2 more
+func quotaTreeTestTree(root string) *QuotaTree {
+	return NewQuotaTree([]mcadv1beta1.QuotaNode{
+		quotaTreeTestNode("root", "", root, ""),
+		quotaTreeTestNode("team-a", "root", "4", "4", "a"),
+		quotaTreeTestNode("team-b", "root", "6", "2", "b"),
+	})
+}
+
+// An AppWrapper of a test quota tree, dispatched AppWrappers are dispatched index minutes ago
+type quotaTreeTestAppWrapper struct {
+	namespace string
+	cpu       string
+	step      mcadv1beta1.AppWrapperStep // not dispatched if empty
+	index     int
+}
+
+// Build the AppWrapper
+func (aw quotaTreeTestAppWrapper) build(name string) *mcadv1beta1.AppWrapper {
+	appWrapper := dispatchTestAppWrapper(name, aw.index, aw.cpu)
+	appWrapper.Namespace = aw.namespace
+	if aw.step != "" {
+		dispatchTestRunning(appWrapper, time.Now().Add(-time.Duration(aw.index)*time.Minute))
+		appWrapper.Status.Step = aw.step
+	}
+	return appWrapper
+}
+
+// Build a quota tree and add the given dispatched AppWrappers named aw-0, aw-1, etc.
+func quotaTreeTestAddAll(root string, dispatched []quotaTreeTestAppWrapper) *QuotaTree {
+	tree := quotaTreeTestTree(root)
+	for i, aw := range dispatched {
+		appWrapper := aw.build(fmt.Sprintf("aw-%d", i))
+		tree.AddAppWrapper(appWrapper, appWrapper.Status.State, appWrapper.Status.Step)
+	}
+	return tree
+}
+
+func TestQuotaTreeFits(t *testing.T) {
+	tests := []struct {
+		name       string
+		root       string
+		dispatched []quotaTreeTestAppWrapper
+		request    quotaTreeTestAppWrapper
+		quotaNode  string // quota node with insufficient quota, fits if empty
+	}{
+		{
+			name:    "within guaranteed quota",
+			root:    "10",
+			request: quotaTreeTestAppWrapper{namespace: "a", cpu: "4"},
+		},
+		{
+			name:    "borrowing",
+			root:    "10",
+			request: quotaTreeTestAppWrapper{namespace: "a", cpu: "8"},
+		},
+		{
+			name:      "exceeding borrowable quota",
+			root:      "10",
+			request:   quotaTreeTestAppWrapper{namespace: "a", cpu: "9"},
+			quotaNode: "team-a",
+		},
+		{
+			name:       "exceeding root quota",
+			root:       "10",
+			dispatched: []quotaTreeTestAppWrapper{{namespace: "b", cpu: "6", step: mcadv1beta1.Created}},
+			request:    quotaTreeTestAppWrapper{namespace: "a", cpu: "5"},
+			quotaNode:  "root",
+		},
+		{
+			name: "quota of releasing AppWrappers",
+			root: "10",
+			dispatched: []quotaTreeTestAppWrapper{
+				{namespace: "a", cpu: "4", step: mcadv1beta1.Created},
+				{namespace: "a", cpu: "4", step: mcadv1beta1.Deleting},
+			},
+			request: quotaTreeTestAppWrapper{namespace: "a", cpu: "4"},
+		},
+		{
+			name:    "namespace without quota node",
+			root:    "1",
+			request: quotaTreeTestAppWrapper{namespace: "c", cpu: "100"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := quotaTreeTestAddAll(tt.root, tt.dispatched)
+			fits, quotaNode, insufficient := tree.Fits(tt.request.build("aw"))
+			if fits != (tt.quotaNode == "") || quotaNode != tt.quotaNode {
+				t.Fatalf("Fits() = %v, %q, want quota node %q", fits, quotaNode, tt.quotaNode)
+			}
+			if !fits && (len(insufficient) != 1 || insufficient[0] != v1.ResourceCPU) {
+				t.Errorf("insufficient resources = %v, want cpu", insufficient)
+			}
+		})
+	}
+}
+
+func TestQuotaTreeBorrowed(t *testing.T) {
+	tree := quotaTreeTestAddAll("10", []quotaTreeTestAppWrapper{
+		{namespace: "a", cpu: "3", step: mcadv1beta1.Created},
+		{namespace: "a", cpu: "3", step: mcadv1beta1.Created},
+		{namespace: "b", cpu: "2", step: mcadv1beta1.Created},
+	})
+	tree.Allocate(quotaTreeTestAppWrapper{namespace: "b", cpu: "1"}.build("selected"))
+	for name, expected := range map[string]struct{ used, borrowed string }{
+		"root":   {used: "9"},
+		"team-a": {used: "6", borrowed: "2"},
+		"team-b": {used: "3"},
+	} {
+		node := tree.nodes[name]
+		if used := node.used.AsResources()[v1.ResourceCPU]; used.Cmp(resource.MustParse(expected.used)) != 0 {
+			t.Errorf("cpu used by %s = %s, want %s", name, used.String(), expected.used)
+		}
+		borrowed, ok := node.borrowed().AsResources()[v1.ResourceCPU]
+		if expected.borrowed == "" && ok || expected.borrowed != "" && borrowed.Cmp(resource.MustParse(expected.borrowed)) != 0 {
+			t.Errorf("cpu borrowed by %s = %s, want %q", name, borrowed.String(), expected.borrowed)
+		}
+		if borrowing := expected.borrowed != ""; node.borrowing() != borrowing {
+			t.Errorf("%s borrowing = %v, want %v", name, node.borrowing(), borrowing)
+		}
+	}
+}
+
+func TestQuotaTreeReclaim(t *testing.T) {
+	tests := []struct {
+		name       string
+		root       string
+		dispatched []quotaTreeTestAppWrapper
+		request    quotaTreeTestAppWrapper
+		victims    []string
+	}{
+		{
+			name: "most recently dispatched borrower first",
+			root: "10",
+			dispatched: []quotaTreeTestAppWrapper{
+				{namespace: "a", cpu: "4", step: mcadv1beta1.Created, index: 2},
+				{namespace: "a", cpu: "3", step: mcadv1beta1.Created, index: 1},
+			},
+			request: quotaTreeTestAppWrapper{namespace: "b", cpu: "6"},
+			victims: []string{"aw-1"},
+		},
+		{
+			name: "AppWrappers not running are not reclaimed",
+			root: "10",
+			dispatched: []quotaTreeTestAppWrapper{
+				{namespace: "a", cpu: "4", step: mcadv1beta1.Created, index: 2},
+				{namespace: "a", cpu: "3", step: mcadv1beta1.Creating, index: 1},
+			},
+			request: quotaTreeTestAppWrapper{namespace: "b", cpu: "6"},
+			victims: []string{"aw-0"},
+		},
+		{
+			name: "AppWrapper borrowing itself",
+			root: "10",
+			dispatched: []quotaTreeTestAppWrapper{
+				{namespace: "a", cpu: "4", step: mcadv1beta1.Created, index: 2},
+				{namespace: "a", cpu: "3", step: mcadv1beta1.Created, index: 1},
+			},
+			request: quotaTreeTestAppWrapper{namespace: "b", cpu: "7"},
+		},
+		{
+			name: "quota nodes within their guaranteed quota",
+			root: "8",
+			dispatched: []quotaTreeTestAppWrapper{
+				{namespace: "a", cpu: "4", step: mcadv1beta1.Created, index: 2},
+			},
+			request: quotaTreeTestAppWrapper{namespace: "b", cpu: "6"},
+		},
+		{
+			name: "reclaiming borrowed quota is not enough",
+			root: "8",
+			dispatched: []quotaTreeTestAppWrapper{
+				{namespace: "a", cpu: "3", step: mcadv1beta1.Created, index: 2},
+				{namespace: "a", cpu: "2", step: mcadv1beta1.Created, index: 1},
+			},
+			request: quotaTreeTestAppWrapper{namespace: "b", cpu: "6"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := quotaTreeTestAddAll(tt.root, tt.dispatched)
+			appWrapper := tt.request.build("aw")
+			victims := []string{}
+			for _, victim := range tree.Reclaim(appWrapper) {
+				victims = append(victims, victim.Name)
+			}
+			if fmt.Sprint(victims) != fmt.Sprint(tt.victims) {
+				t.Fatalf("Reclaim() = %v, want %v", victims, tt.victims)
+			}
+			// reclaimed quota is released, quota is unchanged otherwise
+			if fits, _, _ := tree.Fits(appWrapper); fits != (len(tt.victims) > 0) {
+				t.Errorf("Fits() = %v after Reclaim(), want %v", fits, len(tt.victims) > 0)
+			}
+		})
+	}
+}
+
+func TestQuotaTreeRelease(t *testing.T) {
+	tree := quotaTreeTestAddAll("10", []quotaTreeTestAppWrapper{
+		{namespace: "a", cpu: "4", step: mcadv1beta1.Created},
+		{namespace: "a", cpu: "4", step: mcadv1beta1.Created},
+	})
+	small := quotaTreeTestAppWrapper{namespace: "a", cpu: "4"}.build("small")
+	large := quotaTreeTestAppWrapper{namespace: "a", cpu: "5"}.build("large")
+	if fits, _, _ := tree.Fits(small); fits {
+		t.Fatal("Fits() = true before Release()")
+	}
+	released := quotaTreeTestAppWrapper{namespace: "a", cpu: "4", step: mcadv1beta1.Created}.build("aw-1")
+	for i := 0; i < 2; i++ { // releasing twice has no effect
+		tree.Release(released)
+		if fits, _, _ := tree.Fits(small); !fits {
+			t.Errorf("Fits() = false for 4 CPUs after Release()")
+		}
+		if fits, _, _ := tree.Fits(large); fits {
+			t.Errorf("Fits() = true for 5 CPUs after Release()")
+		}
+	}
+}
+
+func TestQuotaTreeValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		nodes   []mcadv1beta1.QuotaNode
+		reasons map[string]string // reason of the Misconfigured condition of misconfigured quota nodes
+	}{
+		{
+			name: "valid tree",
+			nodes: []mcadv1beta1.QuotaNode{
+				quotaTreeTestNode("root", "", "10", ""),
+				quotaTreeTestNode("team-a", "root", "4", "", "a"),
+			},
+		},
+		{
+			name: "unknown parent",
+			nodes: []mcadv1beta1.QuotaNode{
+				quotaTreeTestNode("root", "", "10", ""),
+				quotaTreeTestNode("team-a", "org", "4", "", "a"),
+			},
+			reasons: map[string]string{"team-a": mcadv1beta1.QuotaNodeUnknownParent},
+		},
+		{
+			name: "parent cycle",
+			nodes: []mcadv1beta1.QuotaNode{
+				quotaTreeTestNode("org-a", "org-b", "10", ""),
+				quotaTreeTestNode("org-b", "org-a", "10", ""),
+				quotaTreeTestNode("team-a", "org-a", "4", "", "a"),
+			},
+			reasons: map[string]string{"org-a": mcadv1beta1.QuotaNodeParentCycle, "org-b": mcadv1beta1.QuotaNodeParentCycle},
+		},
+		{
+			name:    "own parent",
+			nodes:   []mcadv1beta1.QuotaNode{quotaTreeTestNode("root", "root", "10", "")},
+			reasons: map[string]string{"root": mcadv1beta1.QuotaNodeParentCycle},
+		},
+		{
+			name: "namespaces on inner quota node",
+			nodes: []mcadv1beta1.QuotaNode{
+				quotaTreeTestNode("root", "", "10", "", "b"),
+				quotaTreeTestNode("team-a", "root", "4", "", "a"),
+			},
+			reasons: map[string]string{"root": mcadv1beta1.QuotaNodeInnerNamespaces},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := NewQuotaTree(tt.nodes)
+			for name, node := range tree.nodes {
+				reason, misconfigured := tt.reasons[name]
+				if !misconfigured {
+					reason = mcadv1beta1.QuotaNodeWellFormed
+				}
+				if node.condition.Reason != reason || (node.condition.Status == metav1.ConditionTrue) != misconfigured {
+					t.Errorf("condition of %s = %s/%s, want reason %s", name, node.condition.Status, node.condition.Reason, reason)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateQuotaNodes(t *testing.T) {
+	ctx := context.Background()
+	objs := []client.Object{dispatchTestCluster("10")}
+	for _, quotaNode := range quotaTreeTestTree("10").nodes {
+		objs = append(objs, quotaNode.quotaNode)
+	}
+	dispatched := quotaTreeTestAppWrapper{namespace: "a", cpu: "3", step: mcadv1beta1.Created}.build("dispatched")
+	queued := quotaTreeTestAppWrapper{namespace: "a", cpu: "2", index: 1}.build("queued")
+	r := dispatchTestDispatcher(t, append(objs, dispatched, queued)...)
+	var resourceVersion string
+	for i := 0; i < 2; i++ { // the second cycle does not change the status
+		if selected := dispatchTestSelect(t, r); fmt.Sprint(selected) != "[queued]" {
+			t.Fatalf("selected = %v, want [queued]", selected)
+		}
+		quotaNode := &mcadv1beta1.QuotaNode{}
+		if err := r.Get(ctx, client.ObjectKey{Name: "team-a"}, quotaNode); err != nil {
+			t.Fatal(err)
+		}
+		if used := quotaNode.Status.Used[v1.ResourceCPU]; used.Cmp(resource.MustParse("3")) != 0 {
+			t.Errorf("cpu used by team-a = %s, want 3 used by dispatched AppWrappers only", used.String())
+		}
+		if !meta.IsStatusConditionFalse(quotaNode.Status.Conditions, mcadv1beta1.QuotaNodeMisconfigured) {
+			t.Errorf("conditions = %v, want team-a not misconfigured", quotaNode.Status.Conditions)
+		}
+		if i > 0 && quotaNode.ResourceVersion != resourceVersion {
+			t.Errorf("resource version = %s, want unchanged %s", quotaNode.ResourceVersion, resourceVersion)
+		}
+		resourceVersion = quotaNode.ResourceVersion
+	}
+}
@@ -19,6 +19,8 @@ package controller
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -27,6 +29,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
@@ -38,10 +41,23 @@ type PodCounts struct {
 	Other     int
 	Running   int
 	Succeeded int
+
+	// counts for the pods of each generic item, indexed like the generic items
+	Items []ItemPodCounts
+
+	// number of pods without a valid item label, e.g., pods created before upgrading MCAD
+	Unlabeled int
+}
+
+// ItemPodCounts summarize the status of the pods created by one generic item
+type ItemPodCounts struct {
+	Other     int
+	Running   int
+	Succeeded int
 }
 
-// Fix labels in maps
-func fixMap(appWrapper *mcadv1beta1.AppWrapper, m map[string]interface{}) {
+// Fix labels in maps, adding the given extra labels to pod specs if any
+func fixMap(appWrapper *mcadv1beta1.AppWrapper, item int, podLabels map[string]string, m map[string]interface{}) {
 	// inject placeholder in pod specs
 	if spec, ok := m["spec"].(map[string]interface{}); ok {
 		if _, ok := spec["containers"]; ok {
@@ -56,6 +72,9 @@ func fixMap(appWrapper *mcadv1beta1.AppWrapper, m map[string]interface{}) {
 				metadata["labels"] = labels
 			}
 			labels[nameLabel] = "placeholder"
+			for k, v := range podLabels {
+				labels[k] = v
+			}
 		}
 	}
 	// replace placeholder with actual labels
@@ -63,39 +82,122 @@ func fixMap(appWrapper *mcadv1beta1.AppWrapper, m map[string]interface{}) {
 		if _, ok := labels[nameLabel]; ok {
 			labels[namespaceLabel] = appWrapper.Namespace
 			labels[nameLabel] = appWrapper.Name
+			labels[itemLabel] = strconv.Itoa(item)
 		}
 	}
 	// visit submaps and arrays
 	for _, v := range m {
 		switch v := v.(type) {
 		case map[string]interface{}:
-			fixMap(appWrapper, v)
+			fixMap(appWrapper, item, podLabels, v)
 		case []interface{}:
-			fixArray(appWrapper, v)
+			fixArray(appWrapper, item, podLabels, v)
 		}
 	}
 }
 
 // Fix labels in arrays
-func fixArray(appWrapper *mcadv1beta1.AppWrapper, a []interface{}) {
+func fixArray(appWrapper *mcadv1beta1.AppWrapper, item int, podLabels map[string]string, a []interface{}) {
 	// visit submaps and arrays
 	for _, v := range a {
 		switch v := v.(type) {
 		case map[string]interface{}:
-			fixMap(appWrapper, v)
+			fixMap(appWrapper, item, podLabels, v)
+		case []interface{}:
+			fixArray(appWrapper, item, podLabels, v)
+		}
+	}
+}
+
+// Pod template found in a generic item with the number of pods created from it
+type podTemplate struct {
+	replicas int32
+	spec     *v1.PodSpec
+}
+
+// Find pod templates and their replica counts in maps
+// A map with a spec with containers is a pod template
+// The replica count of a pod template is read from the replicas or parallelism field of the map holding the template,
+// as with Deployments, StatefulSets, Jobs, and PyTorchJob replica specs, and defaults to one
+// Map keys are visited in increasing order so that pod templates are always found in the same order
+func findPodTemplates(m map[string]interface{}, replicas int32, templates *[]podTemplate) {
+	if spec, ok := m["spec"].(map[string]interface{}); ok {
+		if _, ok := spec["containers"]; ok {
+			podSpec := &v1.PodSpec{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(spec, podSpec); err == nil {
+				*templates = append(*templates, podTemplate{replicas: replicas, spec: podSpec})
+			}
+			return
+		}
+	}
+	// replica count for the pod templates directly under this map
+	count := int32(1)
+	for _, key := range []string{"replicas", "parallelism"} {
+		if n, ok, err := unstructured.NestedInt64(m, key); ok && err == nil {
+			count = int32(n)
+			break
+		}
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		switch v := m[k].(type) {
+		case map[string]interface{}:
+			findPodTemplates(v, count, templates)
 		case []interface{}:
-			fixArray(appWrapper, v)
+			for _, w := range v {
+				if w, ok := w.(map[string]interface{}); ok {
+					findPodTemplates(w, count, templates)
+				}
+			}
+		}
+	}
+}
+
+// Infer the custom pod resources of the generic items with empty custom pod resources from their pod templates
+// Requests and limits per replica are computed like NewWeightsPairForPod
+// Generic items that cannot be parsed are left unchanged
+func InferCustomPodResources(appWrapper *mcadv1beta1.AppWrapper) {
+	for i := range appWrapper.Spec.Resources.GenericItems {
+		item := &appWrapper.Spec.Resources.GenericItems[i]
+		if len(item.CustomPodResources) > 0 {
+			continue
+		}
+		obj, err := parseResource(appWrapper, i, item.GenericTemplate.Raw)
+		if err != nil {
+			continue
+		}
+		templates := []podTemplate{}
+		findPodTemplates(obj.UnstructuredContent(), 1, &templates)
+		for _, template := range templates {
+			weightsPair := NewWeightsPairForPod(&v1.Pod{Spec: *template.spec})
+			cpr := mcadv1beta1.CustomPodResource{
+				Replicas: template.replicas,
+				Requests: weightsPair.requests.AsResources(),
+			}
+			if len(weightsPair.limits) > 0 {
+				cpr.Limits = weightsPair.limits.AsResources()
+			}
+			item.CustomPodResources = append(item.CustomPodResources, cpr)
 		}
 	}
 }
 
-// Parse raw resource into unstructured object
-func parseResource(appWrapper *mcadv1beta1.AppWrapper, raw []byte) (*unstructured.Unstructured, error) {
+// Parse raw resource of generic item with the given index into unstructured object
+func parseResource(appWrapper *mcadv1beta1.AppWrapper, item int, raw []byte) (*unstructured.Unstructured, error) {
+	return parseResourceWithPodLabels(appWrapper, item, raw, nil)
+}
+
+// Parse raw resource of generic item with the given index into unstructured object adding extra labels to pod specs
+func parseResourceWithPodLabels(appWrapper *mcadv1beta1.AppWrapper, item int, raw []byte, podLabels map[string]string) (*unstructured.Unstructured, error) {
 	obj := &unstructured.Unstructured{}
 	if _, _, err := unstructured.UnstructuredJSONScheme.Decode(raw, nil, obj); err != nil {
 		return nil, err
 	}
-	fixMap(appWrapper, obj.UnstructuredContent())
+	fixMap(appWrapper, item, podLabels, obj.UnstructuredContent())
 	namespace := obj.GetNamespace()
 	if namespace == "" {
 		obj.SetNamespace(appWrapper.Namespace)
@@ -105,11 +207,11 @@ func parseResource(appWrapper *mcadv1beta1.AppWrapper, raw []byte) (*unstructure
 	return obj, nil
 }
 
-// Parse raw resources
-func parseResources(appWrapper *mcadv1beta1.AppWrapper) ([]client.Object, error) {
+// Parse raw resources adding extra labels to pod specs if any
+func parseResources(appWrapper *mcadv1beta1.AppWrapper, podLabels map[string]string) ([]client.Object, error) {
 	objects := make([]client.Object, len(appWrapper.Spec.Resources.GenericItems))
 	for i, resource := range appWrapper.Spec.Resources.GenericItems {
-		obj, err := parseResource(appWrapper, resource.GenericTemplate.Raw)
+		obj, err := parseResourceWithPodLabels(appWrapper, i, resource.GenericTemplate.Raw, podLabels)
 		if err != nil {
 			return nil, err
 		}
@@ -118,15 +220,95 @@ func parseResources(appWrapper *mcadv1beta1.AppWrapper) ([]client.Object, error)
 	return objects, nil
 }
 
+// Build PodGroup for the AppWrapper pods if PodGroups of the given API group are enabled
+// The PodGroup is named after the AppWrapper and its min member count is the AppWrapper min available pod count
+func podGroupForAppWrapper(appWrapper *mcadv1beta1.AppWrapper, group string) *unstructured.Unstructured {
+	if group == "" {
+		return nil
+	}
+	minMember := int64(appWrapper.Spec.Scheduling.MinAvailable)
+	if minMember < 1 {
+		minMember = 1
+	}
+	podGroup := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"minMember": minMember},
+	}}
+	podGroup.SetAPIVersion(group + "/v1alpha1")
+	podGroup.SetKind("PodGroup")
+	podGroup.SetName(appWrapper.Name)
+	podGroup.SetNamespace(appWrapper.Namespace)
+	podGroup.SetLabels(map[string]string{nameLabel: appWrapper.Name, namespaceLabel: appWrapper.Namespace})
+	return podGroup
+}
+
+// Labels assigning the AppWrapper pods to the PodGroup of the AppWrapper if PodGroups of the given API group are enabled
+func podGroupLabels(appWrapper *mcadv1beta1.AppWrapper, group string) map[string]string {
+	switch group {
+	case PodGroupXK8s:
+		return map[string]string{"scheduling.x-k8s.io/pod-group": appWrapper.Name}
+	case PodGroupSigsK8s:
+		return map[string]string{"pod-group.scheduling.sigs.k8s.io": appWrapper.Name}
+	}
+	return nil
+}
+
+// Build Service from AppWrapper service spec if any
+// The Service is named after the AppWrapper and selects the AppWrapper pods using the labels injected by fixMap
+func serviceForAppWrapper(appWrapper *mcadv1beta1.AppWrapper) *v1.Service {
+	if appWrapper.Spec.Service == nil {
+		return nil
+	}
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      appWrapper.Name,
+			Namespace: appWrapper.Namespace,
+			Labels:    map[string]string{nameLabel: appWrapper.Name, namespaceLabel: appWrapper.Namespace},
+		},
+		Spec: *appWrapper.Spec.Service.Spec.DeepCopy(),
+	}
+	selector := map[string]string{}
+	for k, v := range service.Spec.Selector {
+		selector[k] = v
+	}
+	selector[nameLabel] = appWrapper.Name
+	selector[namespaceLabel] = appWrapper.Namespace
+	service.Spec.Selector = selector
+	return service
+}
+
 // Create wrapped resources, give up on first error, decide if error is fatal
 func (r *AppWrapperReconciler) createResources(ctx context.Context, appWrapper *mcadv1beta1.AppWrapper) (error, bool) {
-	objects, err := parseResources(appWrapper)
+	podGroup := podGroupForAppWrapper(appWrapper, r.PodGroupAPIGroup)
+	objects, err := parseResources(appWrapper, podGroupLabels(appWrapper, r.PodGroupAPIGroup))
 	if err != nil {
 		return err, true // fatal
 	}
+	service := serviceForAppWrapper(appWrapper)
+	if service != nil {
+		objects = append(objects, service)
+	}
+	if podGroup != nil {
+		objects = append([]client.Object{podGroup}, objects...) // create PodGroup before pods
+	}
 	for _, obj := range objects {
 		if err := r.Create(ctx, obj); err != nil {
 			if apierrors.IsAlreadyExists(err) {
+				if service != nil && obj == client.Object(service) {
+					// only adopt an existing service created for this AppWrapper
+					if owned, err := r.isOwned(ctx, appWrapper, service.DeepCopy()); err != nil {
+						return err, false
+					} else if !owned {
+						return fmt.Errorf("service %s/%s already exists and does not belong to the AppWrapper", service.Namespace, service.Name), false
+					}
+				}
+				if podGroup != nil && obj == client.Object(podGroup) {
+					// only adopt an existing PodGroup created for this AppWrapper
+					if owned, err := r.isOwned(ctx, appWrapper, podGroup.DeepCopy()); err != nil {
+						return err, false
+					} else if !owned {
+						return fmt.Errorf("PodGroup %s/%s already exists and does not belong to the AppWrapper", podGroup.GetNamespace(), podGroup.GetName()), false
+					}
+				}
 				continue // ignore existing resources
 			}
 			return err, meta.IsNoMatchError(err) || apierrors.IsInvalid(err) // fatal
@@ -135,6 +317,16 @@ func (r *AppWrapperReconciler) createResources(ctx context.Context, appWrapper *
 	return nil, false
 }
 
+// Check if an existing object carries the name and namespace labels of the AppWrapper, i.e., was created for the AppWrapper
+// The object is overwritten with the existing object if any, a missing object is not owned
+func (r *AppWrapperReconciler) isOwned(ctx context.Context, appWrapper *mcadv1beta1.AppWrapper, obj client.Object) (bool, error) {
+	if err := r.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	labels := obj.GetLabels()
+	return labels[nameLabel] == appWrapper.Name && labels[namespaceLabel] == appWrapper.Namespace, nil
+}
+
 // Assess successful completion of AppWrapper by looking at pods and wrapped resources
 func (r *AppWrapperReconciler) isSuccessful(ctx context.Context, appWrapper *mcadv1beta1.AppWrapper, counts *PodCounts) (bool, error) {
 	// To succeed we need at least one successful pods and no running, failed, and other pods
@@ -142,11 +334,11 @@ func (r *AppWrapperReconciler) isSuccessful(ctx context.Context, appWrapper *mca
 		return false, nil
 	}
 	custom := false // at least one resource with completionstatus spec?
-	for _, resource := range appWrapper.Spec.Resources.GenericItems {
+	for i, resource := range appWrapper.Spec.Resources.GenericItems {
 		// skip resources without a completionstatus spec
 		if resource.CompletionStatus != "" {
 			custom = true
-			obj, err := parseResource(appWrapper, resource.GenericTemplate.Raw)
+			obj, err := parseResource(appWrapper, i, resource.GenericTemplate.Raw)
 			if err != nil {
 				return false, err
 			}
@@ -190,8 +382,8 @@ func (r *AppWrapperReconciler) isSuccessful(ctx context.Context, appWrapper *mca
 func (r *AppWrapperReconciler) deleteResources(ctx context.Context, appWrapper *mcadv1beta1.AppWrapper, timestamp metav1.Time) bool {
 	log := log.FromContext(ctx)
 	remaining := 0
-	for _, resource := range appWrapper.Spec.Resources.GenericItems {
-		obj, err := parseResource(appWrapper, resource.GenericTemplate.Raw)
+	for i, resource := range appWrapper.Spec.Resources.GenericItems {
+		obj, err := parseResource(appWrapper, i, resource.GenericTemplate.Raw)
 		if err != nil {
 			log.Error(err, "Parsing error")
 			continue
@@ -204,6 +396,41 @@ func (r *AppWrapperReconciler) deleteResources(ctx context.Context, appWrapper *
 		}
 		remaining++ // no error deleting resource, resource therefore still exists
 	}
+	service := serviceForAppWrapper(appWrapper)
+	if service != nil {
+		// never delete a service that was not created for this AppWrapper
+		if owned, err := r.isOwned(ctx, appWrapper, service.DeepCopy()); err != nil {
+			log.Error(err, "Service lookup error")
+			remaining++ // service may still exist
+		} else if !owned {
+			service = nil
+		} else if err := r.Delete(ctx, service, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+			if !apierrors.IsNotFound(err) {
+				log.Error(err, "Deletion error")
+			}
+		} else {
+			remaining++ // no error deleting service, service therefore still exists
+		}
+	}
+	podGroup := podGroupForAppWrapper(appWrapper, r.PodGroupAPIGroup)
+	if podGroup != nil {
+		// never delete a PodGroup that was not created for this AppWrapper
+		if owned, err := r.isOwned(ctx, appWrapper, podGroup.DeepCopy()); err != nil {
+			if !meta.IsNoMatchError(err) {
+				log.Error(err, "PodGroup lookup error")
+				remaining++ // PodGroup may still exist
+			}
+			podGroup = nil
+		} else if !owned {
+			podGroup = nil
+		} else if err := r.Delete(ctx, podGroup, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+			if !apierrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+				log.Error(err, "Deletion error")
+			}
+		} else {
+			remaining++ // no error deleting PodGroup, PodGroup therefore still exists
+		}
+	}
 	if appWrapper.Spec.Scheduling.Requeuing.ForceDeletionTimeInSeconds <= 0 {
 		// force deletion is not enabled, return true iff no resources were found
 		return remaining == 0
@@ -230,8 +457,8 @@ func (r *AppWrapperReconciler) deleteResources(ctx context.Context, appWrapper *
 		}
 	} else {
 		// force deletion of wrapped resources once pods are gone
-		for _, resource := range appWrapper.Spec.Resources.GenericItems {
-			obj, err := parseResource(appWrapper, resource.GenericTemplate.Raw)
+		for i, resource := range appWrapper.Spec.Resources.GenericItems {
+			obj, err := parseResource(appWrapper, i, resource.GenericTemplate.Raw)
 			if err != nil {
 				log.Error(err, "Parsing error")
 				continue
@@ -240,6 +467,16 @@ func (r *AppWrapperReconciler) deleteResources(ctx context.Context, appWrapper *
 				log.Error(err, "Forceful deletion error")
 			}
 		}
+		if service != nil {
+			if err := r.Delete(ctx, service, client.GracePeriodSeconds(0)); err != nil && !apierrors.IsNotFound(err) {
+				log.Error(err, "Forceful deletion error")
+			}
+		}
+		if podGroup != nil {
+			if err := r.Delete(ctx, podGroup, client.GracePeriodSeconds(0)); err != nil && !apierrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+				log.Error(err, "Forceful deletion error")
+			}
+		}
 	}
 	// requeue deletion
 	return false
@@ -253,27 +490,38 @@ func (r *AppWrapperReconciler) countPods(ctx context.Context, appWrapper *mcadv1
 		client.MatchingLabels{nameLabel: appWrapper.Name}); err != nil {
 		return nil, err
 	}
-	counts := &PodCounts{}
+	counts := &PodCounts{Items: make([]ItemPodCounts, len(appWrapper.Spec.Resources.GenericItems))}
 	for _, pod := range pods.Items {
 		namespace := pod.Labels[namespaceLabel]
+		// attribute pod to generic item if labelled with a valid item index
+		item := &ItemPodCounts{} // discarded if no valid item index
+		if i, err := strconv.Atoi(pod.Labels[itemLabel]); err == nil && i >= 0 && i < len(counts.Items) {
+			item = &counts.Items[i]
+		} else if namespace == appWrapper.Namespace || namespace == "" {
+			counts.Unlabeled += 1
+		}
 		switch pod.Status.Phase {
 		case v1.PodSucceeded:
 			if namespace == appWrapper.Namespace || namespace == "" {
 				counts.Succeeded += 1 // for backward compatibility count pods missing namespace label
+				item.Succeeded += 1
 			}
 		case v1.PodRunning:
 			if pod.DeletionTimestamp.IsZero() {
 				if namespace == appWrapper.Namespace || namespace == "" {
 					counts.Running += 1 // for backward compatibility count pods missing namespace label
+					item.Running += 1
 				}
 			} else {
 				if namespace == appWrapper.Namespace {
 					counts.Other += 1
+					item.Other += 1
 				}
 			}
 		default:
 			if namespace == appWrapper.Namespace {
 				counts.Other += 1
+				item.Other += 1
 			}
 		}
 	}
@@ -0,0 +1,217 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+// Expected custom pod resource, quantities are CPU requests and memory limits
+type inferTestCPR struct {
+	replicas int32
+	cpu      string
+	memory   string // no limits if empty
+}
+
+func TestInferCustomPodResources(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		cprs []inferTestCPR
+	}{
+		{
+			name: "pod with containers",
+			raw: `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "pod"}, "spec": {"containers": [
+				{"name": "a", "image": "busybox", "resources": {"requests": {"cpu": "1"}, "limits": {"memory": "1Gi"}}},
+				{"name": "b", "image": "busybox", "resources": {"requests": {"cpu": "2"}, "limits": {"memory": "2Gi"}}}]}}`,
+			cprs: []inferTestCPR{{replicas: 1, cpu: "3", memory: "3Gi"}},
+		},
+		{
+			name: "pod with larger init container and overhead",
+			raw: `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "pod"}, "spec": {"overhead": {"cpu": "500m"},
+				"initContainers": [{"name": "init", "image": "busybox", "resources": {"requests": {"cpu": "4"}}}],
+				"containers": [{"name": "a", "image": "busybox", "resources": {"requests": {"cpu": "1"}}}]}}`,
+			cprs: []inferTestCPR{{replicas: 1, cpu: "4500m"}},
+		},
+		{
+			name: "deployment replicas",
+			raw: `{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "deployment"}, "spec": {"replicas": 3,
+				"template": {"spec": {"containers": [{"name": "a", "image": "busybox", "resources": {"requests": {"cpu": "1"}}}]}}}}`,
+			cprs: []inferTestCPR{{replicas: 3, cpu: "1"}},
+		},
+		{
+			name: "job parallelism",
+			raw: `{"apiVersion": "batch/v1", "kind": "Job", "metadata": {"name": "job"}, "spec": {"parallelism": 4, "completions": 8,
+				"template": {"spec": {"containers": [{"name": "a", "image": "busybox", "resources": {"requests": {"cpu": "1"}}}]}}}}`,
+			cprs: []inferTestCPR{{replicas: 4, cpu: "1"}},
+		},
+		{
+			name: "replica specs in key order",
+			raw: `{"apiVersion": "kubeflow.org/v1", "kind": "PyTorchJob", "metadata": {"name": "job"}, "spec": {"pytorchReplicaSpecs": {
+				"Worker": {"replicas": 2, "template": {"spec": {"containers": [{"name": "a", "image": "i", "resources": {"requests": {"cpu": "2"}}}]}}},
+				"Master": {"replicas": 1, "template": {"spec": {"containers": [{"name": "a", "image": "i", "resources": {"requests": {"cpu": "1"}}}]}}}}}}`,
+			cprs: []inferTestCPR{{replicas: 1, cpu: "1"}, {replicas: 2, cpu: "2"}},
+		},
+		{
+			name: "object without pods",
+			raw:  `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "config"}}`,
+		},
+		{
+			name: "undecodable template",
+			raw:  `{"kind": "Pod"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appWrapper := webhookTestAppWrapper(webhookTestItem(tt.raw))
+			InferCustomPodResources(appWrapper)
+			cprs := appWrapper.Spec.Resources.GenericItems[0].CustomPodResources
+			if len(cprs) != len(tt.cprs) {
+				t.Fatalf("inferred %v, want %d custom pod resources", cprs, len(tt.cprs))
+			}
+			for i, expected := range tt.cprs {
+				if cprs[i].Replicas != expected.replicas {
+					t.Errorf("replicas of custom pod resource %d = %d, want %d", i, cprs[i].Replicas, expected.replicas)
+				}
+				if cpu := cprs[i].Requests[v1.ResourceCPU]; cpu.Cmp(resource.MustParse(expected.cpu)) != 0 {
+					t.Errorf("cpu request of custom pod resource %d = %s, want %s", i, cpu.String(), expected.cpu)
+				}
+				if expected.memory == "" {
+					if len(cprs[i].Limits) != 0 {
+						t.Errorf("limits of custom pod resource %d = %v, want none", i, cprs[i].Limits)
+					}
+				} else if memory := cprs[i].Limits[v1.ResourceMemory]; memory.Cmp(resource.MustParse(expected.memory)) != 0 {
+					t.Errorf("memory limit of custom pod resource %d = %s, want %s", i, memory.String(), expected.memory)
+				}
+			}
+		})
+	}
+}
+
+func TestInferCustomPodResourcesKeepsDeclared(t *testing.T) {
+	declared := mcadv1beta1.CustomPodResource{Replicas: 5, Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("7")}}
+	appWrapper := webhookTestAppWrapper(webhookTestItem(webhookTestDeployment, declared))
+	InferCustomPodResources(appWrapper)
+	cprs := appWrapper.Spec.Resources.GenericItems[0].CustomPodResources
+	if len(cprs) != 1 || cprs[0].Replicas != 5 {
+		t.Errorf("custom pod resources = %v, want declared %v", cprs, declared)
+	}
+}
+
+func TestPodGroupForAppWrapper(t *testing.T) {
+	tests := []struct {
+		group        string
+		minAvailable int32
+		minMember    int64  // expected min member count, no PodGroup if zero
+		label        string // expected pod label key
+	}{
+		{group: ""},
+		{group: PodGroupXK8s, minAvailable: 3, minMember: 3, label: "scheduling.x-k8s.io/pod-group"},
+		{group: PodGroupSigsK8s, minAvailable: 0, minMember: 1, label: "pod-group.scheduling.sigs.k8s.io"},
+	}
+	for _, tt := range tests {
+		appWrapper := dispatchTestAppWrapper("aw", 0, "1")
+		appWrapper.Spec.Scheduling.MinAvailable = tt.minAvailable
+		podGroup := podGroupForAppWrapper(appWrapper, tt.group)
+		labels := podGroupLabels(appWrapper, tt.group)
+		if tt.minMember == 0 {
+			if podGroup != nil || labels != nil {
+				t.Errorf("podGroupForAppWrapper() = %v with labels %v for group %q, want none", podGroup, labels, tt.group)
+			}
+			continue
+		}
+		if podGroup.GetAPIVersion() != tt.group+"/v1alpha1" || podGroup.GetKind() != "PodGroup" || podGroup.GetName() != "aw" || podGroup.GetNamespace() != "default" {
+			t.Errorf("PodGroup = %v, want %s PodGroup default/aw", podGroup.Object, tt.group)
+		}
+		if minMember, _, _ := unstructured.NestedInt64(podGroup.Object, "spec", "minMember"); minMember != tt.minMember {
+			t.Errorf("min member = %d, want %d", minMember, tt.minMember)
+		}
+		if len(labels) != 1 || labels[tt.label] != "aw" {
+			t.Errorf("pod labels = %v, want %s=aw", labels, tt.label)
+		}
+	}
+}
+
+// Build an empty PodGroup for a test AppWrapper with the given labels
+func podGroupTestPodGroup(labels map[string]string) *unstructured.Unstructured {
+	podGroup := &unstructured.Unstructured{}
+	podGroup.SetAPIVersion(PodGroupXK8s + "/v1alpha1")
+	podGroup.SetKind("PodGroup")
+	podGroup.SetNamespace("default")
+	podGroup.SetName("aw")
+	podGroup.SetLabels(labels)
+	return podGroup
+}
+
+func TestCreateAndDeleteResourcesPodGroup(t *testing.T) {
+	ctx := context.Background()
+	appWrapper := dispatchTestAppWrapper("aw", 0, "1")
+	r := &dispatchTestDispatcher(t).AppWrapperReconciler
+	r.PodGroupAPIGroup = PodGroupXK8s
+
+	if err, fatal := r.createResources(ctx, appWrapper); err != nil {
+		t.Fatalf("createResources() = %v, %v", err, fatal)
+	}
+	podGroup := podGroupTestPodGroup(nil)
+	if err := r.Get(ctx, client.ObjectKeyFromObject(podGroup), podGroup); err != nil {
+		t.Fatalf("PodGroup not created: %v", err)
+	}
+	pod := &v1.Pod{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: "default", Name: "aw"}, pod); err != nil {
+		t.Fatalf("pod not created: %v", err)
+	}
+	if pod.Labels["scheduling.x-k8s.io/pod-group"] != "aw" {
+		t.Errorf("pod labels = %v, want PodGroup label", pod.Labels)
+	}
+
+	// resources are deleted in a first pass and found gone in a second pass
+	if r.deleteResources(ctx, appWrapper, metav1.Now()) {
+		t.Error("deleteResources() = true with existing resources")
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(podGroup), podGroup); !apierrors.IsNotFound(err) {
+		t.Errorf("PodGroup lookup = %v, want not found", err)
+	}
+	if !r.deleteResources(ctx, appWrapper, metav1.Now()) {
+		t.Error("deleteResources() = false without remaining resources")
+	}
+}
+
+func TestCreateAndDeleteResourcesForeignPodGroup(t *testing.T) {
+	ctx := context.Background()
+	appWrapper := dispatchTestAppWrapper("aw", 0, "1")
+	r := &dispatchTestDispatcher(t, podGroupTestPodGroup(map[string]string{"team": "a"})).AppWrapperReconciler
+	r.PodGroupAPIGroup = PodGroupXK8s
+
+	if err, fatal := r.createResources(ctx, appWrapper); err == nil || fatal {
+		t.Errorf("createResources() = %v, %v, want non-fatal error", err, fatal)
+	}
+	r.deleteResources(ctx, appWrapper, metav1.Now())
+	podGroup := podGroupTestPodGroup(nil)
+	if err := r.Get(ctx, client.ObjectKeyFromObject(podGroup), podGroup); err != nil {
+		t.Errorf("PodGroup lookup = %v, want PodGroup not created for the AppWrapper kept", err)
+	}
+}
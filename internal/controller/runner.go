@@ -20,6 +20,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
 	"time"
 
@@ -139,6 +140,8 @@ func (r *Runner) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result,
 			}
 			// set dispatching time
 			appWrapper.Status.DispatchTimestamp = metav1.Now()
+			// record waiting time before checking requeuing conditions for this dispatch
+			appWrapper.Status.RequeuingTimeInSeconds = requeuingTimeInSeconds(appWrapper)
 			return r.updateStatus(ctx, appWrapper, mcadv1beta1.Running, mcadv1beta1.Creating)
 
 		case mcadv1beta1.Creating:
@@ -164,19 +167,53 @@ func (r *Runner) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result,
 			if success {
 				return r.updateStatus(ctx, appWrapper, mcadv1beta1.Succeeded, mcadv1beta1.Idle)
 			}
+			// enforce dispatch duration limit if any
+			delay := healthCheckDelay
+			if limit := appWrapper.Spec.Scheduling.DispatchDuration.Limit; limit > 0 {
+				deadline := appWrapper.Status.DispatchTimestamp.Add(time.Duration(limit) * time.Second)
+				if metav1.Now().After(deadline) {
+					customMessage := "exceeded dispatch duration limit of " + strconv.Itoa(int(limit)) + "s"
+					// requeue if overrun is permitted, fail otherwise, release resources in both cases
+					return r.requeueOrFailAndDelete(ctx, appWrapper, !appWrapper.Spec.Scheduling.DispatchDuration.Overrun, customMessage)
+				}
+				if untilDeadline := time.Until(deadline); untilDeadline < delay {
+					delay = untilDeadline
+				}
+			}
 			// check pod count if dispatched for a while
 			minAvailable := appWrapper.Spec.Scheduling.MinAvailable
 			if minAvailable == 0 {
 				minAvailable = 1 // default to expecting 1 running pod
 			}
-			if metav1.Now().After(appWrapper.Status.DispatchTimestamp.Add(time.Duration(appWrapper.Spec.Scheduling.Requeuing.TimeInSeconds)*time.Second)) &&
-				counts.Running+counts.Succeeded < int(minAvailable) {
-				customMessage := "expected pods " + strconv.Itoa(int(minAvailable)) + " but found pods " + strconv.Itoa(counts.Running+counts.Succeeded)
-				// requeue or fail if max retries exhausted with custom error message
-				return r.requeueOrFail(ctx, appWrapper, false, customMessage)
+			if metav1.Now().After(appWrapper.Status.DispatchTimestamp.Add(time.Duration(requeuingTimeInSeconds(appWrapper)) * time.Second)) {
+				if counts.Running+counts.Succeeded < int(minAvailable) {
+					customMessage := "expected pods " + strconv.Itoa(int(minAvailable)) + " but found pods " + strconv.Itoa(counts.Running+counts.Succeeded)
+					// requeue or fail if max retries exhausted with custom error message
+					return r.requeueOrFail(ctx, appWrapper, false, customMessage)
+				}
+				// check pod count of each generic item with a min available spec
+				// pods cannot be attributed to items reliably if some pods are not labelled with their item
+				for i, resource := range appWrapper.Spec.Resources.GenericItems {
+					if counts.Unlabeled > 0 || resource.MinAvailable == nil || *resource.MinAvailable <= 0 {
+						continue
+					}
+					expected := *resource.MinAvailable
+					if resource.Replicas > 0 && expected > resource.Replicas {
+						expected = resource.Replicas // cannot expect more pods than replicas
+					}
+					if found := counts.Items[i].Running + counts.Items[i].Succeeded; found < int(expected) {
+						customMessage := "expected pods " + strconv.Itoa(int(expected))
+						if resource.Replicas > 0 {
+							customMessage += " of " + strconv.Itoa(int(resource.Replicas)) + " replicas"
+						}
+						customMessage += " but found pods " + strconv.Itoa(found) + " for generic item " + strconv.Itoa(i)
+						// requeue or fail if max retries exhausted with custom error message
+						return r.requeueOrFail(ctx, appWrapper, false, customMessage)
+					}
+				}
 			}
 			// AppWrapper is healthy, requeue reconciliation after delay
-			return ctrl.Result{RequeueAfter: healthCheckDelay}, nil
+			return ctrl.Result{RequeueAfter: delay}, nil
 
 		case mcadv1beta1.Deleting:
 			// delete wrapped resources
@@ -208,7 +245,14 @@ func (r *AppWrapperReconciler) requeueOrFail(ctx context.Context, appWrapper *mc
 	if appWrapper.Spec.Scheduling.MinAvailable < 0 {
 		// set failed status and leave resources as is
 		return r.updateStatus(ctx, appWrapper, mcadv1beta1.Failed, appWrapper.Status.Step, reason)
-	} else if fatal || appWrapper.Spec.Scheduling.Requeuing.MaxNumRequeuings > 0 && appWrapper.Status.Restarts >= appWrapper.Spec.Scheduling.Requeuing.MaxNumRequeuings {
+	}
+	return r.requeueOrFailAndDelete(ctx, appWrapper, fatal, reason)
+}
+
+// Set requeuing or failed status depending on error, configuration, and restarts count
+// Request the deletion of the wrapped resources in both cases
+func (r *AppWrapperReconciler) requeueOrFailAndDelete(ctx context.Context, appWrapper *mcadv1beta1.AppWrapper, fatal bool, reason string) (ctrl.Result, error) {
+	if fatal || appWrapper.Spec.Scheduling.Requeuing.MaxNumRequeuings > 0 && appWrapper.Status.Restarts >= appWrapper.Spec.Scheduling.Requeuing.MaxNumRequeuings {
 		// set failed/deleting status (request deletion of wrapped resources)
 		appWrapper.Status.RequeueTimestamp = metav1.Now()
 		return r.updateStatus(ctx, appWrapper, mcadv1beta1.Failed, mcadv1beta1.Deleting, reason)
@@ -218,6 +262,43 @@ func (r *AppWrapperReconciler) requeueOrFail(ctx context.Context, appWrapper *mc
 	return r.updateStatus(ctx, appWrapper, mcadv1beta1.Running, mcadv1beta1.Deleting, reason)
 }
 
+// Compute waiting time before checking requeuing conditions
+// The initial waiting time grows with the number of restarts according to the growth type up to the max waiting time
+func requeuingTimeInSeconds(appWrapper *mcadv1beta1.AppWrapper) int64 {
+	const maxSeconds = math.MaxInt64 / int64(time.Second) // largest waiting time representable as a time.Duration
+	spec := appWrapper.Spec.Scheduling.Requeuing
+	seconds := spec.TimeInSeconds
+	if spec.InitialTimeInSeconds > 0 {
+		seconds = spec.InitialTimeInSeconds
+	}
+	restarts := int64(appWrapper.Status.Restarts)
+	if seconds > 0 && restarts > 0 {
+		switch spec.GrowthType {
+		case mcadv1beta1.NoGrowth:
+			// keep the initial waiting time
+		case mcadv1beta1.LinearGrowth:
+			if seconds < maxSeconds/(restarts+1) {
+				seconds *= restarts + 1
+			} else {
+				seconds = maxSeconds
+			}
+		default:
+			// exponential growth, also applies to AppWrappers without a growth type such as AppWrappers
+			// created before the CRD defaulted the growth type to exponential
+			for i := int64(0); i < restarts && seconds < maxSeconds; i++ {
+				seconds *= 2
+			}
+		}
+	}
+	if spec.MaxTimeInSeconds > 0 && seconds > spec.MaxTimeInSeconds {
+		seconds = spec.MaxTimeInSeconds
+	}
+	if seconds > maxSeconds {
+		seconds = maxSeconds
+	}
+	return seconds
+}
+
 // Map labelled pods to corresponding AppWrappers
 func (r *Runner) podMapFunc(ctx context.Context, obj client.Object) []reconcile.Request {
 	pod := obj.(*v1.Pod)
@@ -0,0 +1,205 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+// Reconcile an AppWrapper once with a runner backed by a fake client holding the AppWrapper and the given objects
+// Return the reconciled AppWrapper and the reconciliation result
+func runnerTestReconcile(t *testing.T, appWrapper *mcadv1beta1.AppWrapper, objs ...client.Object) (*mcadv1beta1.AppWrapper, ctrl.Result) {
+	ctx := context.Background()
+	r := &Runner{AppWrapperReconciler: dispatchTestDispatcher(t, append(objs, appWrapper)...).AppWrapperReconciler}
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(appWrapper)})
+	if err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+	reconciled := &mcadv1beta1.AppWrapper{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(appWrapper), reconciled); err != nil {
+		t.Fatal(err)
+	}
+	return reconciled, result
+}
+
+func TestRequeuingTimeInSeconds(t *testing.T) {
+	maxSeconds := int64(math.MaxInt64 / int64(time.Second))
+	tests := []struct {
+		name     string
+		spec     mcadv1beta1.RequeuingSpec
+		restarts int32
+		seconds  int64
+	}{
+		{name: "first dispatch", spec: mcadv1beta1.RequeuingSpec{TimeInSeconds: 300, GrowthType: mcadv1beta1.ExponentialGrowth}, seconds: 300},
+		{name: "exponential", spec: mcadv1beta1.RequeuingSpec{TimeInSeconds: 300, GrowthType: mcadv1beta1.ExponentialGrowth}, restarts: 3, seconds: 2400},
+		{name: "linear", spec: mcadv1beta1.RequeuingSpec{TimeInSeconds: 300, GrowthType: mcadv1beta1.LinearGrowth}, restarts: 3, seconds: 1200},
+		{name: "none", spec: mcadv1beta1.RequeuingSpec{TimeInSeconds: 300, GrowthType: mcadv1beta1.NoGrowth}, restarts: 3, seconds: 300},
+		{name: "unset growth type", spec: mcadv1beta1.RequeuingSpec{TimeInSeconds: 300}, restarts: 3, seconds: 2400},
+		{
+			name:     "initial time overrides time",
+			spec:     mcadv1beta1.RequeuingSpec{InitialTimeInSeconds: 60, TimeInSeconds: 300, GrowthType: mcadv1beta1.LinearGrowth},
+			restarts: 1,
+			seconds:  120,
+		},
+		{
+			name:     "max time",
+			spec:     mcadv1beta1.RequeuingSpec{TimeInSeconds: 300, MaxTimeInSeconds: 1000, GrowthType: mcadv1beta1.ExponentialGrowth},
+			restarts: 3,
+			seconds:  1000,
+		},
+		{
+			name:     "exponential overflow",
+			spec:     mcadv1beta1.RequeuingSpec{TimeInSeconds: 300, GrowthType: mcadv1beta1.ExponentialGrowth},
+			restarts: 100,
+			seconds:  maxSeconds,
+		},
+		{
+			name:     "linear overflow",
+			spec:     mcadv1beta1.RequeuingSpec{TimeInSeconds: maxSeconds / 2, GrowthType: mcadv1beta1.LinearGrowth},
+			restarts: 2,
+			seconds:  maxSeconds,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appWrapper := &mcadv1beta1.AppWrapper{
+				Spec:   mcadv1beta1.AppWrapperSpec{Scheduling: mcadv1beta1.SchedulingSpec{Requeuing: tt.spec}},
+				Status: mcadv1beta1.AppWrapperStatus{Restarts: tt.restarts},
+			}
+			if seconds := requeuingTimeInSeconds(appWrapper); seconds != tt.seconds {
+				t.Errorf("requeuingTimeInSeconds() = %d, want %d", seconds, tt.seconds)
+			}
+		})
+	}
+}
+
+func TestRunnerDispatchDurationLimit(t *testing.T) {
+	tests := []struct {
+		name         string
+		limit        int32
+		overrun      bool
+		minAvailable int32
+		restarts     int32
+		maxRequeuing int32
+		state        mcadv1beta1.AppWrapperState
+		step         mcadv1beta1.AppWrapperStep
+	}{
+		{name: "no limit", state: mcadv1beta1.Running, step: mcadv1beta1.Created},
+		{name: "limit not reached", limit: 3600, state: mcadv1beta1.Running, step: mcadv1beta1.Created},
+		{name: "limit exceeded", limit: 60, state: mcadv1beta1.Failed, step: mcadv1beta1.Deleting},
+		{name: "limit exceeded with overrun", limit: 60, overrun: true, state: mcadv1beta1.Running, step: mcadv1beta1.Deleting},
+		{
+			name: "limit exceeded with overrun and no requeuing left", limit: 60, overrun: true, restarts: 2, maxRequeuing: 2,
+			state: mcadv1beta1.Failed, step: mcadv1beta1.Deleting,
+		},
+		{name: "limit exceeded without pod checks", limit: 60, minAvailable: -1, state: mcadv1beta1.Failed, step: mcadv1beta1.Deleting},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appWrapper := dispatchTestRunning(dispatchTestAppWrapper("aw", 0, "1"), time.Now().Add(-2*time.Minute))
+			appWrapper.Spec.Scheduling.Requeuing.TimeInSeconds = 300 // do not check pod counts yet
+			appWrapper.Spec.Scheduling.Requeuing.MaxNumRequeuings = tt.maxRequeuing
+			appWrapper.Spec.Scheduling.DispatchDuration.Limit = tt.limit
+			appWrapper.Spec.Scheduling.DispatchDuration.Overrun = tt.overrun
+			appWrapper.Spec.Scheduling.MinAvailable = tt.minAvailable
+			appWrapper.Status.Restarts = tt.restarts
+			reconciled, result := runnerTestReconcile(t, appWrapper)
+			if reconciled.Status.State != tt.state || reconciled.Status.Step != tt.step {
+				t.Fatalf("status = %s/%s, want %s/%s", reconciled.Status.State, reconciled.Status.Step, tt.state, tt.step)
+			}
+			if tt.step == mcadv1beta1.Deleting {
+				transition := reconciled.Status.Transitions[len(reconciled.Status.Transitions)-1]
+				if !strings.Contains(transition.Reason, "exceeded dispatch duration limit of 60s") {
+					t.Errorf("transition reason = %q, want exceeded dispatch duration limit", transition.Reason)
+				}
+			} else if tt.limit > 0 && result.RequeueAfter > time.Duration(tt.limit)*time.Second {
+				t.Errorf("requeue after %v, want at most the time until the limit", result.RequeueAfter)
+			}
+		})
+	}
+}
+
+// Pod of a test AppWrapper, not labeled with a generic item if item is empty
+type runnerTestPod struct {
+	item  string
+	phase v1.PodPhase
+}
+
+func TestRunnerItemMinAvailable(t *testing.T) {
+	running := runnerTestPod{item: "1", phase: v1.PodRunning}
+	tests := []struct {
+		name     string
+		pods     []runnerTestPod // pods in addition to one running pod of item 0
+		replicas int32           // replicas of item 1
+		healthy  bool
+	}{
+		{name: "item min available met", pods: []runnerTestPod{running, running}, healthy: true},
+		{name: "item min available met with succeeded pod", pods: []runnerTestPod{running, {item: "1", phase: v1.PodSucceeded}}, healthy: true},
+		{name: "item min available not met", pods: []runnerTestPod{running, {item: "1", phase: v1.PodPending}}},
+		{name: "pods of other items", pods: []runnerTestPod{running, {item: "0", phase: v1.PodRunning}}},
+		{name: "item min available capped by replicas", pods: []runnerTestPod{running}, replicas: 1, healthy: true},
+		{name: "item min available not capped by more replicas", pods: []runnerTestPod{running}, replicas: 3},
+		{name: "unlabeled pods", pods: []runnerTestPod{running, {phase: v1.PodRunning}}, healthy: true},
+		{name: "unlabeled pending pods", pods: []runnerTestPod{running, {phase: v1.PodPending}}, healthy: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appWrapper := dispatchTestRunning(dispatchTestAppWrapper("aw", 0, "1"), time.Now().Add(-2*time.Minute))
+			appWrapper.Spec.Scheduling.Requeuing.TimeInSeconds = 60
+			minAvailable := int32(2)
+			appWrapper.Spec.Resources.GenericItems = append(appWrapper.Spec.Resources.GenericItems, mcadv1beta1.GenericItem{
+				GenericTemplate: runtime.RawExtension{Raw: []byte(`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "pod"}}`)},
+				Replicas:        tt.replicas,
+				MinAvailable:    &minAvailable,
+			})
+			objs := []client.Object{}
+			for i, pod := range append([]runnerTestPod{{item: "0", phase: v1.PodRunning}}, tt.pods...) {
+				labels := map[string]string{nameLabel: "aw", namespaceLabel: "default"}
+				if pod.item != "" {
+					labels[itemLabel] = pod.item
+				}
+				objs = append(objs, &v1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: fmt.Sprintf("pod-%d", i), Labels: labels},
+					Status:     v1.PodStatus{Phase: pod.phase},
+				})
+			}
+			reconciled, _ := runnerTestReconcile(t, appWrapper, objs...)
+			if healthy := reconciled.Status.Step == mcadv1beta1.Created; healthy != tt.healthy {
+				t.Fatalf("status = %s/%s, want healthy %v", reconciled.Status.State, reconciled.Status.Step, tt.healthy)
+			}
+			if !tt.healthy {
+				transition := reconciled.Status.Transitions[len(reconciled.Status.Transitions)-1]
+				if reconciled.Status.State != mcadv1beta1.Running || !strings.Contains(transition.Reason, "for generic item 1") {
+					t.Errorf("status = %s/%s with reason %q, want requeuing for generic item 1", reconciled.Status.State, reconciled.Status.Step, transition.Reason)
+				}
+			}
+		})
+	}
+}
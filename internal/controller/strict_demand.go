@@ -0,0 +1,169 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/inf.v0"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+// Infer the pod templates of a generic item and the requests of a single pod of each template like NewWeightsForPod
+// Return nil if the generic item cannot be parsed
+func inferItemPodRequests(appWrapper *mcadv1beta1.AppWrapper, item int) ([]podTemplate, []Weights) {
+	obj, err := parseResource(appWrapper, item, appWrapper.Spec.Resources.GenericItems[item].GenericTemplate.Raw)
+	if err != nil {
+		return nil, nil
+	}
+	templates := []podTemplate{}
+	findPodTemplates(obj.UnstructuredContent(), 1, &templates)
+	requests := make([]Weights, len(templates))
+	for i, template := range templates {
+		requests[i] = NewWeightsForPod(&v1.Pod{Spec: *template.spec})
+	}
+	return templates, requests
+}
+
+// Compute the demand of an AppWrapper in strict demand mode, i.e., the max of declared and inferred requests per generic item
+// Return the AppWrapper itself if declared requests cover inferred requests,
+// otherwise a copy with an extra custom pod resource per pod template of each generic item with understated requests
+// The extra custom pod resources have the replica counts of the pod templates and share the understated requests
+// of the generic item in proportion to the requests of the pod templates
+// Also return a message describing the discrepancies if any
+// The copy must not be persisted
+func strictDemand(appWrapper *mcadv1beta1.AppWrapper) (*mcadv1beta1.AppWrapper, string) {
+	result := appWrapper
+	var msgBuilder strings.Builder
+	for i, item := range appWrapper.Spec.Resources.GenericItems {
+		templates, podRequests := inferItemPodRequests(appWrapper, i)
+		if templates == nil {
+			continue
+		}
+		inferred := Weights{}
+		for j, template := range templates {
+			inferred.AddProd(template.replicas, podRequests[j])
+		}
+		declared := Weights{}
+		for _, cpr := range item.CustomPodResources {
+			declared.AddProd(cpr.Replicas, NewWeights(cpr.Requests))
+		}
+		surplus := Weights{}
+		for k, v := range inferred {
+			diff := new(inf.Dec).Set(v)
+			if d, ok := declared[k]; ok {
+				diff.Sub(diff, d)
+			}
+			if diff.Sign() > 0 {
+				surplus[k] = diff
+			}
+		}
+		if len(surplus) == 0 {
+			continue
+		}
+		if result == appWrapper {
+			result = appWrapper.DeepCopy()
+		}
+		for j, template := range templates {
+			if template.replicas <= 0 {
+				continue
+			}
+			extra := Weights{} // share of the surplus of a single pod of the template, rounded up
+			for k, v := range surplus {
+				if q, ok := podRequests[j][k]; ok && q.Sign() > 0 {
+					share := new(inf.Dec).Mul(q, v)
+					extra[k] = share.QuoRound(share, inferred[k], 3, inf.RoundUp)
+				}
+			}
+			if len(extra) > 0 {
+				result.Spec.Resources.GenericItems[i].CustomPodResources = append(result.Spec.Resources.GenericItems[i].CustomPodResources,
+					mcadv1beta1.CustomPodResource{Replicas: template.replicas, Requests: extra.AsResources()})
+			}
+		}
+		resources := surplus.AsResources()
+		names := make([]string, 0, len(resources))
+		for k := range resources {
+			names = append(names, string(k))
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			q := resources[v1.ResourceName(k)]
+			msgBuilder.WriteString(fmt.Sprintf("Generic item %d understates %v requests by %v. ", i, k, q.String()))
+		}
+	}
+	return result, msgBuilder.String()
+}
+
+// Strict demand of an AppWrapper generation
+type cachedDemand struct {
+	// generation of the AppWrapper
+	generation int64
+
+	// adjusted spec, nil if declared requests cover inferred requests
+	spec *mcadv1beta1.AppWrapperSpec
+
+	// discrepancies between declared and inferred requests if any
+	message string
+}
+
+// Compute the demand of an AppWrapper in strict demand mode like strictDemand
+// Pod templates are only parsed once per AppWrapper generation
+func (r *Dispatcher) cachedStrictDemand(appWrapper *mcadv1beta1.AppWrapper) (*mcadv1beta1.AppWrapper, string) {
+	if r.demands == nil {
+		r.demands = map[types.UID]*cachedDemand{}
+	}
+	cached, ok := r.demands[appWrapper.UID]
+	if !ok || cached.generation != appWrapper.Generation {
+		adjusted, message := strictDemand(appWrapper)
+		cached = &cachedDemand{generation: appWrapper.Generation, message: message}
+		if adjusted != appWrapper {
+			cached.spec = &adjusted.Spec
+		}
+		r.demands[appWrapper.UID] = cached
+	}
+	if cached.spec == nil {
+		return appWrapper, cached.message
+	}
+	result := *appWrapper // shallow copy ok, spec is replaced not mutated
+	result.Spec = *cached.spec.DeepCopy()
+	return &result, cached.message
+}
+
+// Set the DemandDiscrepancy condition of an AppWrapper in strict demand mode
+// Return true if the condition changed
+func (r *Dispatcher) setDemandDiscrepancy(appWrapper *mcadv1beta1.AppWrapper) bool {
+	_, message := r.cachedStrictDemand(appWrapper)
+	condition := metav1.Condition{
+		Type:    mcadv1beta1.DemandDiscrepancy,
+		Status:  metav1.ConditionFalse,
+		Reason:  mcadv1beta1.DemandConsistent,
+		Message: "Declared requests cover the requests of the pod templates",
+	}
+	if message != "" {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = mcadv1beta1.DemandUnderstated
+		condition.Message = message
+	}
+	return meta.SetStatusCondition(&appWrapper.Status.Conditions, condition)
+}
@@ -0,0 +1,235 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+// Build a queued AppWrapper wrapping a pod requesting template CPUs but declaring declared CPUs
+func strictTestAppWrapper(template string, declared string) *mcadv1beta1.AppWrapper {
+	appWrapper := dispatchTestAppWrapper("aw", 0, template)
+	appWrapper.Spec.Resources.GenericItems[0].CustomPodResources[0].Requests[v1.ResourceCPU] = resource.MustParse(declared)
+	return appWrapper
+}
+
+func TestStrictDemand(t *testing.T) {
+	tests := []struct {
+		name       string
+		appWrapper *mcadv1beta1.AppWrapper
+		extra      []inferTestCPR // expected extra custom pod resources
+		message    string
+	}{
+		{
+			name:       "declared requests match pod template",
+			appWrapper: strictTestAppWrapper("2", "2"),
+		},
+		{
+			name:       "declared requests exceed pod template",
+			appWrapper: strictTestAppWrapper("1", "2"),
+		},
+		{
+			name:       "declared requests understate pod template",
+			appWrapper: strictTestAppWrapper("3", "1"),
+			extra:      []inferTestCPR{{replicas: 1, cpu: "2"}},
+			message:    "Generic item 0 understates cpu requests by 2. ",
+		},
+		{
+			name: "undeclared resource",
+			appWrapper: func() *mcadv1beta1.AppWrapper {
+				appWrapper := strictTestAppWrapper("1", "1")
+				appWrapper.Spec.Resources.GenericItems[0].CustomPodResources[0].Requests = v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Gi")}
+				return appWrapper
+			}(),
+			extra:   []inferTestCPR{{replicas: 1, cpu: "1"}},
+			message: "Generic item 0 understates cpu requests by 1. ",
+		},
+		{
+			name: "surplus shared by the pods of the template",
+			appWrapper: func() *mcadv1beta1.AppWrapper {
+				appWrapper := strictTestAppWrapper("1", "1")
+				appWrapper.Spec.Resources.GenericItems[0].GenericTemplate.Raw = []byte(`{"apiVersion": "batch/v1", "kind": "Job", "metadata": {"name": "job"},
+					"spec": {"parallelism": 2, "template": {"spec": {"containers": [{"name": "a", "image": "i", "resources": {"requests": {"cpu": "2"}}}]}}}}`)
+				return appWrapper
+			}(),
+			extra:   []inferTestCPR{{replicas: 2, cpu: "1500m"}},
+			message: "Generic item 0 understates cpu requests by 3. ",
+		},
+		{
+			name: "surplus shared by the pod templates",
+			appWrapper: func() *mcadv1beta1.AppWrapper {
+				appWrapper := strictTestAppWrapper("1", "1")
+				appWrapper.Spec.Resources.GenericItems[0].GenericTemplate.Raw = []byte(`{"apiVersion": "kubeflow.org/v1", "kind": "PyTorchJob", "metadata": {"name": "job"}, "spec": {"pytorchReplicaSpecs": {
+					"Worker": {"replicas": 3, "template": {"spec": {"containers": [{"name": "a", "image": "i", "resources": {"requests": {"cpu": "2"}}}]}}},
+					"Master": {"replicas": 1, "template": {"spec": {"containers": [{"name": "a", "image": "i", "resources": {"requests": {"cpu": "4"}}}]}}}}}}`)
+				appWrapper.Spec.Resources.GenericItems[0].CustomPodResources = nil
+				return appWrapper
+			}(),
+			extra:   []inferTestCPR{{replicas: 1, cpu: "4"}, {replicas: 3, cpu: "2"}},
+			message: "Generic item 0 understates cpu requests by 10. ",
+		},
+		{
+			name: "undecodable template",
+			appWrapper: func() *mcadv1beta1.AppWrapper {
+				appWrapper := strictTestAppWrapper("3", "1")
+				appWrapper.Spec.Resources.GenericItems[0].GenericTemplate.Raw = []byte(`{"kind": "Pod"`)
+				return appWrapper
+			}(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := tt.appWrapper.DeepCopy()
+			adjusted, message := strictDemand(tt.appWrapper)
+			if message != tt.message {
+				t.Errorf("message = %q, want %q", message, tt.message)
+			}
+			if tt.extra == nil {
+				if adjusted != tt.appWrapper {
+					t.Errorf("strictDemand() returned a copy, want the AppWrapper itself")
+				}
+				return
+			}
+			declared := len(original.Spec.Resources.GenericItems[0].CustomPodResources)
+			cprs := adjusted.Spec.Resources.GenericItems[0].CustomPodResources
+			if len(cprs) != declared+len(tt.extra) {
+				t.Fatalf("custom pod resources = %v, want %d extra custom pod resources", cprs, len(tt.extra))
+			}
+			for i, expected := range tt.extra {
+				cpr := cprs[declared+i]
+				if cpu := cpr.Requests[v1.ResourceCPU]; cpr.Replicas != expected.replicas || cpu.Cmp(resource.MustParse(expected.cpu)) != 0 {
+					t.Errorf("extra custom pod resource %d = %d replicas requesting %s cpu, want %d replicas requesting %s cpu",
+						i, cpr.Replicas, cpu.String(), expected.replicas, expected.cpu)
+				}
+			}
+			if len(tt.appWrapper.Spec.Resources.GenericItems[0].CustomPodResources) != declared {
+				t.Error("strictDemand() modified the AppWrapper")
+			}
+		})
+	}
+}
+
+func TestCachedStrictDemand(t *testing.T) {
+	r := dispatchTestDispatcher(t)
+	appWrapper := strictTestAppWrapper("3", "1")
+	if _, message := r.cachedStrictDemand(appWrapper); message == "" {
+		t.Fatal("cachedStrictDemand() = no discrepancy, want understated requests")
+	}
+	// the pod templates are not parsed again for the same generation
+	appWrapper.Spec.Resources.GenericItems[0].CustomPodResources[0].Requests[v1.ResourceCPU] = resource.MustParse("3")
+	adjusted, message := r.cachedStrictDemand(appWrapper)
+	if message == "" || adjusted == appWrapper {
+		t.Errorf("cachedStrictDemand() = %q, want cached understated requests", message)
+	}
+	if cprs := adjusted.Spec.Resources.GenericItems[0].CustomPodResources; len(cprs) != 2 {
+		t.Errorf("custom pod resources = %v, want cached adjusted demand", cprs)
+	} else {
+		cprs[1].Replicas = 5 // mutating the result does not alter the cache
+	}
+	if again, _ := r.cachedStrictDemand(appWrapper); again.Spec.Resources.GenericItems[0].CustomPodResources[1].Replicas != 1 {
+		t.Error("cachedStrictDemand() returned the cached spec, want a copy")
+	}
+	appWrapper.Generation++
+	if adjusted, message := r.cachedStrictDemand(appWrapper); message != "" || adjusted != appWrapper {
+		t.Errorf("cachedStrictDemand() = %q for new generation, want consistent requests", message)
+	}
+}
+
+func TestSetDemandDiscrepancy(t *testing.T) {
+	r := dispatchTestDispatcher(t)
+	appWrapper := strictTestAppWrapper("3", "1")
+	if !r.setDemandDiscrepancy(appWrapper) {
+		t.Error("setDemandDiscrepancy() = false for new condition")
+	}
+	condition := meta.FindStatusCondition(appWrapper.Status.Conditions, mcadv1beta1.DemandDiscrepancy)
+	if condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != mcadv1beta1.DemandUnderstated {
+		t.Errorf("condition = %+v, want understated demand", condition)
+	}
+	if r.setDemandDiscrepancy(appWrapper) {
+		t.Error("setDemandDiscrepancy() = true for unchanged condition")
+	}
+	appWrapper.Spec.Resources.GenericItems[0].CustomPodResources[0].Requests[v1.ResourceCPU] = resource.MustParse("3")
+	appWrapper.Generation++
+	if !r.setDemandDiscrepancy(appWrapper) {
+		t.Error("setDemandDiscrepancy() = false for resolved discrepancy")
+	}
+	condition = meta.FindStatusCondition(appWrapper.Status.Conditions, mcadv1beta1.DemandDiscrepancy)
+	if condition == nil || condition.Status != metav1.ConditionFalse || condition.Reason != mcadv1beta1.DemandConsistent {
+		t.Errorf("condition = %+v, want consistent demand", condition)
+	}
+}
+
+func TestSelectForDispatchStrictDemand(t *testing.T) {
+	tests := []struct {
+		name     string
+		strict   bool
+		capacity string
+		selected bool
+	}{
+		{name: "declared demand fits", capacity: "2", selected: true},
+		{name: "inferred demand does not fit", strict: true, capacity: "2"},
+		{name: "inferred demand fits", strict: true, capacity: "4", selected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := dispatchTestDispatcher(t, dispatchTestCluster(tt.capacity), strictTestAppWrapper("3", "1"))
+			r.StrictDemand = tt.strict
+			selected, _, err := r.selectForDispatch(context.Background(), NewQuotaTracker())
+			if err != nil {
+				t.Fatalf("selectForDispatch() = %v", err)
+			}
+			if (len(selected) == 1) != tt.selected {
+				t.Fatalf("selected %d AppWrappers, want selected %v", len(selected), tt.selected)
+			}
+			if !tt.selected {
+				if decision := r.Decisions["aw"]; decision == nil || !strings.Contains(decision.message, "Insufficient cpu") {
+					t.Errorf("decision = %+v, want insufficient cpu", decision)
+				}
+				return
+			}
+			// adjusted demand is not persisted
+			if cprs := selected[0].Spec.Resources.GenericItems[0].CustomPodResources; len(cprs) != 1 {
+				t.Errorf("custom pod resources of selected AppWrapper = %v, want declared custom pod resources", cprs)
+			}
+		})
+	}
+}
+
+func TestSelectForDispatchStrictDemandPlacement(t *testing.T) {
+	// a Job of two pods requesting 2 CPUs each declaring a single pod requesting 1 CPU
+	appWrapper := strictTestAppWrapper("1", "1")
+	appWrapper.Spec.Resources.GenericItems[0].GenericTemplate.Raw = []byte(`{"apiVersion": "batch/v1", "kind": "Job", "metadata": {"name": "job"},
+		"spec": {"parallelism": 2, "template": {"spec": {"containers": [{"name": "a", "image": "i", "resources": {"requests": {"cpu": "2"}}}]}}}}`)
+	cluster := dispatchTestNodeCluster(nil, clusterTestNode("node-0", "2", nil), clusterTestNode("node-1", "2", nil))
+	r := dispatchTestDispatcher(t, cluster, appWrapper)
+	r.StrictDemand = true
+	if selected := dispatchTestSelect(t, r); len(selected) != 0 {
+		t.Fatalf("selected %v, want the extra pods of the adjusted demand placed on the nodes", selected)
+	}
+	if decision := r.Decisions["aw"]; decision == nil || decision.reason != mcadv1beta1.QueuedFragmented {
+		t.Errorf("decision = %+v, want %v", decision, mcadv1beta1.QueuedFragmented)
+	}
+}
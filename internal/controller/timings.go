@@ -24,8 +24,10 @@ import (
 
 const (
 	// Timeouts
-	cacheConflictTimeout = 5 * time.Minute // minimum wait before invalidating the cache
-	clusterInfoTimeout   = time.Minute     // how often to refresh cluster capacity
+	cacheConflictTimeout   = 5 * time.Minute  // minimum wait before invalidating the cache
+	clusterInfoTimeout     = time.Minute      // how often to log cluster capacity
+	clusterInfoMinInterval = 5 * time.Second  // minimum wait between cluster info updates
+	clusterInfoResync      = 10 * time.Minute // how often to rebuild the capacity model from all nodes and pods
 
 	// RequeueAfter delays
 	healthCheckDelay = time.Minute     // how often to force check running AppWrapper health
@@ -0,0 +1,116 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+// The tolerations of the pod templates of an AppWrapper, one list per pod template
+// A nil value tolerates no taint
+type podTolerations [][]v1.Toleration
+
+// Collect the tolerations of the pod templates of the generic items of an AppWrapper
+func getPodTolerationsForAppWrapper(appWrapper *mcadv1beta1.AppWrapper) podTolerations {
+	tolerations := podTolerations{}
+	for i, item := range appWrapper.Spec.Resources.GenericItems {
+		obj, err := parseResource(appWrapper, i, item.GenericTemplate.Raw)
+		if err != nil {
+			return nil // AppWrapper will fail in createResources
+		}
+		specs := []*v1.PodSpec{}
+		collectPodSpecs(obj.UnstructuredContent(), &specs)
+		for _, spec := range specs {
+			tolerations = append(tolerations, spec.Tolerations)
+		}
+	}
+	if len(tolerations) == 0 {
+		return nil
+	}
+	return tolerations
+}
+
+// Check if every pod template tolerates every taint
+func (tolerations podTolerations) Tolerate(taints []v1.Taint) bool {
+	if len(taints) == 0 {
+		return true
+	}
+	if len(tolerations) == 0 {
+		return false
+	}
+	for _, list := range tolerations {
+		for i := range taints {
+			if !tolerated(list, &taints[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Check if a list of tolerations tolerates a taint
+func tolerated(tolerations []v1.Toleration, taint *v1.Taint) bool {
+	for i := range tolerations {
+		if tolerations[i].ToleratesTaint(taint) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the taints of a node preventing pods from being scheduled on the node in a canonical order
+// PreferNoSchedule taints are ignored
+func schedulingTaints(node *v1.Node) []v1.Taint {
+	taints := []v1.Taint{}
+	for _, taint := range node.Spec.Taints {
+		if taint.Effect == v1.TaintEffectNoSchedule || taint.Effect == v1.TaintEffectNoExecute {
+			taints = append(taints, v1.Taint{Key: taint.Key, Value: taint.Value, Effect: taint.Effect})
+		}
+	}
+	sort.Slice(taints, func(i, j int) bool { return taintKey(taints[i]) < taintKey(taints[j]) })
+	return taints
+}
+
+// Encode a taint as a string
+func taintKey(taint v1.Taint) string {
+	return taint.Key + "=" + taint.Value + ":" + string(taint.Effect)
+}
+
+// Encode a canonically ordered set of taints as a string
+func taintSetKey(taints []v1.Taint) string {
+	keys := make([]string, len(taints))
+	for i, taint := range taints {
+		keys[i] = taintKey(taint)
+	}
+	return strings.Join(keys, ",")
+}
+
+// Compute the capacity of the tainted nodes of a cluster an AppWrapper with the given tolerations can count on
+func toleratedCapacity(cluster *mcadv1beta1.ClusterInfo, tolerations podTolerations) Weights {
+	capacity := Weights{}
+	for _, tainted := range cluster.Status.TaintedCapacity {
+		if tolerations.Tolerate(tainted.Taints) {
+			capacity.Add(NewWeights(tainted.Capacity))
+		}
+	}
+	return capacity
+}
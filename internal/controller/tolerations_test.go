@@ -0,0 +1,173 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	mcadv1beta1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+var (
+	tolerationTestGPUTaint       = v1.Taint{Key: "nvidia.com/gpu", Value: "present", Effect: v1.TaintEffectNoSchedule}
+	tolerationTestDedicatedTaint = v1.Taint{Key: "dedicated", Value: "team-a", Effect: v1.TaintEffectNoExecute}
+	tolerationTestGPU            = v1.Toleration{Key: "nvidia.com/gpu", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule}
+	tolerationTestDedicated      = v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "team-a"}
+)
+
+// Build a queued AppWrapper wrapping a pod requesting the given CPUs with the given tolerations as raw JSON
+func tolerationTestAppWrapper(cpu string, tolerations string) *mcadv1beta1.AppWrapper {
+	appWrapper := dispatchTestAppWrapper("aw", 0, cpu)
+	appWrapper.Spec.Resources.GenericItems[0].GenericTemplate.Raw = []byte(`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "aw"},
+		"spec": {"tolerations": ` + tolerations + `, "containers": [{"name": "busybox", "image": "busybox", "resources": {"requests": {"cpu": "` + cpu + `"}}}]}}`)
+	return appWrapper
+}
+
+func TestPodTolerationsTolerate(t *testing.T) {
+	tests := []struct {
+		name        string
+		tolerations podTolerations
+		taints      []v1.Taint
+		tolerated   bool
+	}{
+		{name: "untainted node", tolerations: nil, taints: nil, tolerated: true},
+		{name: "no pod template", tolerations: nil, taints: []v1.Taint{tolerationTestGPUTaint}, tolerated: false},
+		{name: "template without tolerations", tolerations: podTolerations{nil}, taints: []v1.Taint{tolerationTestGPUTaint}, tolerated: false},
+		{name: "exists operator", tolerations: podTolerations{{tolerationTestGPU}}, taints: []v1.Taint{tolerationTestGPUTaint}, tolerated: true},
+		{name: "equal operator", tolerations: podTolerations{{tolerationTestDedicated}}, taints: []v1.Taint{tolerationTestDedicatedTaint}, tolerated: true},
+		{
+			name:        "equal operator with other value",
+			tolerations: podTolerations{{tolerationTestDedicated}},
+			taints:      []v1.Taint{{Key: "dedicated", Value: "team-b", Effect: v1.TaintEffectNoSchedule}},
+			tolerated:   false,
+		},
+		{
+			name:        "other effect",
+			tolerations: podTolerations{{tolerationTestGPU}},
+			taints:      []v1.Taint{{Key: "nvidia.com/gpu", Value: "present", Effect: v1.TaintEffectNoExecute}},
+			tolerated:   false,
+		},
+		{
+			name:        "all taints of the set",
+			tolerations: podTolerations{{tolerationTestGPU}},
+			taints:      []v1.Taint{tolerationTestDedicatedTaint, tolerationTestGPUTaint},
+			tolerated:   false,
+		},
+		{
+			name:        "all pod templates",
+			tolerations: podTolerations{{tolerationTestGPU}, {tolerationTestDedicated}},
+			taints:      []v1.Taint{tolerationTestGPUTaint},
+			tolerated:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tolerated := tt.tolerations.Tolerate(tt.taints); tolerated != tt.tolerated {
+				t.Errorf("Tolerate() = %v, want %v", tolerated, tt.tolerated)
+			}
+		})
+	}
+}
+
+func TestGetPodTolerationsForAppWrapper(t *testing.T) {
+	appWrapper := tolerationTestAppWrapper("1", `[{"key": "nvidia.com/gpu", "operator": "Exists", "effect": "NoSchedule"}]`)
+	appWrapper.Spec.Resources.GenericItems = append(appWrapper.Spec.Resources.GenericItems, webhookTestItem(webhookTestConfigMap))
+	tolerations := getPodTolerationsForAppWrapper(appWrapper)
+	if len(tolerations) != 1 || len(tolerations[0]) != 1 || tolerations[0][0] != tolerationTestGPU {
+		t.Errorf("getPodTolerationsForAppWrapper() = %v, want the toleration of the pod template", tolerations)
+	}
+	if tolerations := getPodTolerationsForAppWrapper(webhookTestAppWrapper(webhookTestItem(webhookTestConfigMap))); tolerations != nil {
+		t.Errorf("getPodTolerationsForAppWrapper() = %v without pod template, want nil", tolerations)
+	}
+}
+
+func TestSchedulingTaints(t *testing.T) {
+	now := metav1.Now()
+	node := &v1.Node{Spec: v1.NodeSpec{Taints: []v1.Taint{
+		tolerationTestGPUTaint,
+		{Key: "soft", Effect: v1.TaintEffectPreferNoSchedule},
+		{Key: "dedicated", Value: "team-a", Effect: v1.TaintEffectNoExecute, TimeAdded: &now},
+	}}}
+	taints := schedulingTaints(node)
+	if len(taints) != 2 || taints[0] != tolerationTestDedicatedTaint || taints[1] != tolerationTestGPUTaint {
+		t.Errorf("schedulingTaints() = %v, want sorted NoSchedule and NoExecute taints without time added", taints)
+	}
+}
+
+func TestToleratedCapacity(t *testing.T) {
+	nodes := []mcadv1beta1.NodeInfo{
+		clusterTestNode("node-0", "2", nil),
+		clusterTestNode("node-1", "8", nil, tolerationTestGPUTaint),
+		clusterTestNode("node-2", "4", nil, tolerationTestDedicatedTaint),
+		clusterTestNode("node-3", "8", nil, tolerationTestGPUTaint),
+	}
+	cluster := dispatchTestNodeCluster(nil, nodes...)
+	if len(cluster.Status.TaintedCapacity) != 2 || cluster.Status.TaintedCapacity[0].NodeCount != 2 {
+		t.Fatalf("taintedCapacity() = %v, want two taint sets in node order", cluster.Status.TaintedCapacity)
+	}
+	tests := []struct {
+		tolerations podTolerations
+		cpu         string
+	}{
+		{tolerations: nil, cpu: "0"},
+		{tolerations: podTolerations{{tolerationTestGPU}}, cpu: "16"},
+		{tolerations: podTolerations{{tolerationTestGPU, tolerationTestDedicated}}, cpu: "20"},
+	}
+	for _, tt := range tests {
+		capacity := toleratedCapacity(cluster, tt.tolerations).AsResources()
+		if cpu := capacity[v1.ResourceCPU]; cpu.Cmp(resource.MustParse(tt.cpu)) != 0 {
+			t.Errorf("toleratedCapacity() = %s cpus for tolerations %v, want %s", cpu.String(), tt.tolerations, tt.cpu)
+		}
+	}
+}
+
+func TestSelectForDispatchTolerations(t *testing.T) {
+	tests := []struct {
+		name        string
+		tolerations string
+		perNode     bool // report per-node capacity
+		selected    bool
+	}{
+		{name: "untolerated taint", tolerations: `[]`},
+		{name: "tolerated taint", tolerations: `[{"key": "nvidia.com/gpu", "operator": "Exists"}]`, selected: true},
+		{name: "untolerated taint with per-node capacity", tolerations: `[]`, perNode: true},
+		{name: "tolerated taint with per-node capacity", tolerations: `[{"key": "nvidia.com/gpu", "operator": "Exists"}]`, perNode: true, selected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster := dispatchTestNodeCluster(nil, clusterTestNode("node-0", "2", nil), clusterTestNode("node-1", "8", nil, tolerationTestGPUTaint))
+			if !tt.perNode {
+				cluster.Status.Nodes = nil
+			}
+			r := dispatchTestDispatcher(t, cluster, tolerationTestAppWrapper("4", tt.tolerations))
+			selected := dispatchTestSelect(t, r)
+			if (len(selected) == 1) != tt.selected {
+				t.Fatalf("selected %v, want selected %v (decision %+v)", selected, tt.selected, r.Decisions["aw"])
+			}
+			if !tt.selected {
+				if decision := r.Decisions["aw"]; decision == nil || !strings.Contains(decision.message, "Insufficient cpu") {
+					t.Errorf("decision = %+v, want insufficient cpu", decision)
+				}
+			}
+		})
+	}
+}
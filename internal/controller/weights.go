@@ -99,6 +99,15 @@ func (w Weights) AddProd(coefficient int32, r Weights) {
 	}
 }
 
+// Multiply receiver by numerator / denominator in each dimension rounding down to milli units
+func (w Weights) Scale(numerator int64, denominator int64) {
+	for _, v := range w {
+		tmp := inf.NewDec(numerator, 0)
+		tmp.Mul(tmp, v)
+		v.QuoRound(tmp, inf.NewDec(denominator, 0), 3, inf.RoundDown)
+	}
+}
+
 // Update receiver to max of receiver and argument in each dimension
 func (w Weights) Max(r Weights) {
 	for k, v := range r {
@@ -155,6 +164,27 @@ func (w Weights) QuotaFits(r Weights) (bool, []v1.ResourceName) {
 	}
 }
 
+// Compare receiver to argument
+// True if receiver and argument are equal in every dimension, missing dimensions are zero
+func (w Weights) Equal(r Weights) bool {
+	zero := &inf.Dec{} // shared zero, never mutated
+	for k, v := range w {
+		if r[k] == nil {
+			if v.Cmp(zero) != 0 {
+				return false
+			}
+		} else if v.Cmp(r[k]) != 0 {
+			return false
+		}
+	}
+	for k, v := range r {
+		if w[k] == nil && v.Cmp(zero) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
 // Converts Weights to a ResourceList
 func (w Weights) AsResources() v1.ResourceList {
 	resources := v1.ResourceList{}
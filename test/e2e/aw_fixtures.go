@@ -27,6 +27,7 @@ import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 
 	arbv1 "github.com/project-codeflare/mcad/api/v1beta1"
 )
@@ -183,6 +184,86 @@ func createJobAWWithStuckInitContainer(ctx context.Context, name string, requeui
 	return aw
 }
 
+func createJobAWWithInitContainer(ctx context.Context, name string, initSeconds int, requeuingSpec arbv1.RequeuingSpec) *arbv1.AppWrapper {
+	rb := []byte(`{"apiVersion": "batch/v1",
+		"kind": "Job",
+	"metadata": {
+		"name": "` + name + `",
+		"namespace": "test",
+		"labels": {
+			"app": "` + name + `"
+		}
+	},
+	"spec": {
+		"parallelism": 1,
+		"template": {
+			"metadata": {
+				"labels": {
+					"app": "` + name + `"
+				}
+			},
+			"spec": {
+				"terminationGracePeriodSeconds": 1,
+				"restartPolicy": "Never",
+				"initContainers": [
+					{
+						"name": "job-init-container",
+						"image": "quay.io/project-codeflare/busybox:latest",
+						"command": ["sleep", "` + strconv.Itoa(initSeconds) + `"],
+						"resources": {
+							"requests": {
+								"cpu": "500m"
+							}
+						}
+					}
+				],
+				"containers": [
+					{
+						"name": "job-container",
+						"image": "quay.io/project-codeflare/busybox:latest",
+						"command": ["sleep", "10"],
+						"resources": {
+							"requests": {
+								"cpu": "500m"
+							}
+						}
+					}
+				]
+			}
+		}
+	}} `)
+
+	var minAvailable int32 = 1
+
+	aw := &arbv1.AppWrapper{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: testNamespace,
+		},
+		Spec: arbv1.AppWrapperSpec{
+			Scheduling: arbv1.SchedulingSpec{
+				MinAvailable: minAvailable,
+				Requeuing:    requeuingSpec,
+			},
+			Resources: arbv1.AppWrapperResources{
+				GenericItems: []arbv1.GenericItem{
+					{
+						GenericTemplate: runtime.RawExtension{
+							Raw: rb,
+						},
+						CompletionStatus: "Complete",
+					},
+				},
+			},
+		},
+	}
+
+	err := getClient(ctx).Create(ctx, aw)
+	Expect(err).NotTo(HaveOccurred())
+
+	return aw
+}
+
 func createDeploymentAW(ctx context.Context, name string) *arbv1.AppWrapper {
 	rb := []byte(`{"apiVersion": "apps/v1",
 		"kind": "Deployment",
@@ -820,12 +901,22 @@ func createGenericDeploymentAWWithMultipleItems(ctx context.Context, name string
 }
 
 func createGenericDeploymentWithCPUAW(ctx context.Context, name string, cpuDemand *resource.Quantity, replicas int) *arbv1.AppWrapper {
+	aw := genericDeploymentWithCPUAW(testNamespace, name, cpuDemand, replicas)
+
+	err := getClient(ctx).Create(ctx, aw)
+	Expect(err).NotTo(HaveOccurred())
+
+	return aw
+}
+
+// Build an AppWrapper wrapping a deployment with the given number of replicas each requesting the given CPU in the given namespace
+func genericDeploymentWithCPUAW(namespace string, name string, cpuDemand *resource.Quantity, replicas int) *arbv1.AppWrapper {
 	rb := []byte(`{
 		"apiVersion": "apps/v1",
 		"kind": "Deployment",
 		"metadata": {
 			"name": "` + name + `",
-			"namespace": "test",
+			"namespace": "` + namespace + `",
 			"labels": {
 				"app": "` + name + `"
 			}
@@ -868,7 +959,7 @@ func createGenericDeploymentWithCPUAW(ctx context.Context, name string, cpuDeman
 	aw := &arbv1.AppWrapper{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
-			Namespace: testNamespace,
+			Namespace: namespace,
 		},
 		Spec: arbv1.AppWrapperSpec{
 			Scheduling: arbv1.SchedulingSpec{
@@ -891,9 +982,6 @@ func createGenericDeploymentWithCPUAW(ctx context.Context, name string, cpuDeman
 		},
 	}
 
-	err := getClient(ctx).Create(ctx, aw)
-	Expect(err).NotTo(HaveOccurred())
-
 	return aw
 }
 
@@ -1452,6 +1540,79 @@ func createGenericPodAW(ctx context.Context, name string) *arbv1.AppWrapper {
 	return aw
 }
 
+func createGenericPodAWWithService(ctx context.Context, name string) *arbv1.AppWrapper {
+	rb := []byte(`{
+		"apiVersion": "v1",
+		"kind": "Pod",
+		"metadata": {
+			"name": "aw-generic-pod-service-1",
+			"namespace": "test",
+			"labels": {
+				"app": "aw-generic-pod-service-1"
+			}
+		},
+		"spec": {
+			"containers": [
+				{
+					"name": "aw-generic-pod-service-1",
+					"image": "quay.io/project-codeflare/echo-server:1.0",
+					"resources": {
+						"limits": {
+							"memory": "150Mi"
+						},
+						"requests": {
+							"memory": "150Mi"
+						}
+					},
+					"ports": [
+						{
+							"containerPort": 80
+						}
+					]
+				}
+			]
+		}
+	} `)
+
+	var schedSpecMin int32 = 1
+
+	aw := &arbv1.AppWrapper{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: testNamespace,
+		},
+		Spec: arbv1.AppWrapperSpec{
+			Service: &arbv1.AppWrapperService{
+				Spec: v1.ServiceSpec{
+					Ports: []v1.ServicePort{
+						{
+							Port:       80,
+							TargetPort: intstr.FromInt(80),
+						},
+					},
+				},
+			},
+			Scheduling: arbv1.SchedulingSpec{
+				MinAvailable: schedSpecMin,
+			},
+			Resources: arbv1.AppWrapperResources{
+				GenericItems: []arbv1.GenericItem{
+					{
+						GenericTemplate: runtime.RawExtension{
+							Raw: rb,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	err := getClient(ctx).Create(ctx, aw)
+	Expect(err).NotTo(HaveOccurred())
+
+	return aw
+}
+
 func createGenericPodTooBigAW(ctx context.Context, name string) *arbv1.AppWrapper {
 	rb := []byte(`{
 		"apiVersion": "v1",
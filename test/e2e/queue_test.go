@@ -25,7 +25,9 @@ import (
 	. "github.com/onsi/gomega"
 
 	arbv1 "github.com/project-codeflare/mcad/api/v1beta1"
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 var _ = Describe("AppWrapper E2E Tests", func() {
@@ -65,6 +67,15 @@ var _ = Describe("AppWrapper E2E Tests", func() {
 			Expect(waitAWPodsReady(ctx, aw)).Should(Succeed())
 		})
 
+		It("Pod With Service", func() {
+			aw := createGenericPodAWWithService(ctx, "aw-pod-service-1")
+			appwrappers = append(appwrappers, aw)
+			Expect(waitAWPodsReady(ctx, aw)).Should(Succeed())
+			service := &v1.Service{}
+			Expect(getClient(ctx).Get(ctx, client.ObjectKey{Namespace: aw.Namespace, Name: aw.Name}, service)).Should(Succeed())
+			Expect(service.Spec.Selector).Should(HaveKeyWithValue("appwrapper.mcad.ibm.com", aw.Name))
+		})
+
 	})
 
 	Describe("Error Handling for Invalid Resources", func() {
@@ -130,23 +141,21 @@ var _ = Describe("AppWrapper E2E Tests", func() {
 			Expect(waitAWPodsReady(ctx, aw2)).Should(Succeed(), "Ready pods are expected for app wrapper: aw-deployment-50-percent-cpu")
 		})
 
-		/*
-			 * TODO: Dave DISABLED because V2 doesn't support exponential backoff of requeuing time
-			It("MCAD CPU Requeuing - Completion After Enough Requeuing Times Test", func() {
-				fmt.Fprintf(os.Stdout, "[e2e] Completion After Enough Requeuing Times Test - Started.\n")
-
-				// Create a job with init containers that need 200 seconds to be ready before the container starts.
-				// The requeuing mechanism is set to start at 1 minute, which is not enough time for the PODs to be completed.
-				// The job should be requeued 3 times before it finishes since the wait time is doubled each time the job is requeued (i.e., initially it waits
-				// for 1 minutes before requeuing, then 2 minutes, and then 4 minutes). Since the init containers take 3 minutes
-				// and 20 seconds to finish, a 4 minute wait should be long enough to finish the job successfully
-				aw := createJobAWWithInitContainer(ctx, "aw-job-3-init-container-1", 60, "exponential", 0)
-				appwrappers = append(appwrappers, aw)
-
-				err := waitAWPodsCompleted(ctx, aw, 8*time.Minute) // This test waits for 14 minutes to make sure all PODs complete
-				Expect(err).NotTo(HaveOccurred(), "Waiting for the pods to be completed")
-			})
-		*/
+		It("MCAD CPU Requeuing - Completion After Enough Requeuing Times Test", Label("slow"), func() {
+			// Create a job with an init container that needs 150 seconds to complete before the container starts.
+			// The requeuing mechanism is set to start at 1 minute, which is not enough time for the pod to be completed.
+			// The job should be requeued twice before it finishes since the wait time is doubled each time the job is requeued
+			// (i.e., initially it waits for 1 minute before requeuing, then 2 minutes, and then 4 minutes).
+			// Since the init container takes 2.5 minutes to finish, a 4 minute wait is long enough to finish the job successfully.
+			rq := arbv1.RequeuingSpec{TimeInSeconds: 60, GrowthType: arbv1.ExponentialGrowth, PauseTimeInSeconds: 1}
+			aw := createJobAWWithInitContainer(ctx, "aw-job-3-init-container-1", 150, rq)
+			appwrappers = append(appwrappers, aw)
+			By("Unready pods will trigger requeuing")
+			Eventually(AppWrapperQueuedReason(ctx, aw.Namespace, aw.Name), 2*time.Minute).Should(Equal(string(arbv1.QueuedRequeue)))
+			By("After enough requeuing the job completes")
+			Eventually(AppWrapperState(ctx, aw.Namespace, aw.Name), 12*time.Minute).Should(Equal(arbv1.Succeeded))
+			Expect(AppWrapper(ctx, aw.Namespace, aw.Name)(Default).Status.RequeuingTimeInSeconds).Should(Equal(int64(240)))
+		})
 
 		It("MCAD CPU Requeuing - Deletion After Maximum Requeuing Times Test", Label("slow"), func() {
 			// Create a job with init containers that will never complete.
@@ -220,7 +229,11 @@ var _ = Describe("AppWrapper E2E Tests", func() {
 			Expect(waitAWPodsReady(ctx, aw2)).Should(Succeed(), "Ready pods are expected for app wrapper: aw-high-priority")
 
 			By("Validate that the normal priority AppWrapper is requeued")
-			Eventually(AppWrapperQueuedReason(ctx, aw.Namespace, aw.Name), 2*time.Minute).Should(Equal(string(arbv1.QueuedRequeue)))
+			requeued := string(arbv1.QueuedRequeue)
+			if value, ok := controllerFlag(ctx, "--preemption"); ok && value == "true" {
+				requeued = string(arbv1.QueuedPreempted) // MCAD preempts the AppWrapper before the pod is evicted
+			}
+			Eventually(AppWrapperQueuedReason(ctx, aw.Namespace, aw.Name), 2*time.Minute).Should(Equal(requeued))
 
 			By("Validate that the normal priority AppWrapper's queued reason becomes insufficient resource")
 			Eventually(AppWrapperQueuedReason(ctx, aw.Namespace, aw.Name), 3*time.Minute).Should(Equal(string(arbv1.QueuedInsufficientResources)))
@@ -233,6 +246,35 @@ var _ = Describe("AppWrapper E2E Tests", func() {
 			Expect(waitAWPodsReady(ctx, aw)).Should(Succeed(), "Ready pods are expected for app wrapper: aw-normal-priority")
 		})
 
+		It("MCAD Preemption Test", Label("slow"), func() {
+			requireControllerFlag(ctx, "--preemption")
+
+			By("Request 80% of cluster CPU in 4 pods")
+			aw := createGenericDeploymentWithCPUAW(ctx, appendRandomString("aw-normal-priority"), cpuDemand(0.2), 4)
+			appwrappers = append(appwrappers, aw)
+			Expect(waitAWPodsReady(ctx, aw)).Should(Succeed(), "Ready pods are expected for app wrapper: aw-normal-priority")
+
+			By("Request 30% of cluster CPU in one high priority pod")
+			aw2 := createGenericHighPriorityDeploymentWithCPUAW(ctx, appendRandomString("aw-high-priority"), cpuDemand(0.30), 1)
+			appwrappers = append(appwrappers, aw2)
+
+			By("Validate that the normal priority AppWrapper is preempted")
+			Eventually(AppWrapperQueuedReason(ctx, aw.Namespace, aw.Name), 2*time.Minute).Should(Equal(string(arbv1.QueuedPreempted)))
+
+			By("Validate that the preemption is not counted as a restart")
+			Expect(AppWrapper(ctx, aw.Namespace, aw.Name)(Default).Status.Restarts).Should(Equal(int32(0)))
+
+			By("Validate that the high priority AppWrapper has ready pods")
+			Expect(waitAWPodsReady(ctx, aw2)).Should(Succeed(), "Ready pods are expected for app wrapper: aw-high-priority")
+
+			By("Delete high priority app wrapper")
+			Expect(deleteAppWrapper(ctx, aw2.Name, aw2.Namespace)).Should(Succeed())
+			appwrappers = []*arbv1.AppWrapper{aw}
+
+			By("Validate that the normal priority AppWrapper is dispatched again")
+			Expect(waitAWPodsReady(ctx, aw)).Should(Succeed(), "Ready pods are expected for app wrapper: aw-normal-priority")
+		})
+
 		/*
 			  TODO: DAVE -- test depends on extracting resoure requirements from generic items
 			It("MCAD Job Large Compute Requirement Test", Label("slow"), func() {
@@ -0,0 +1,112 @@
+/*
+Copyright 2023 IBM Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	arbv1 "github.com/project-codeflare/mcad/api/v1beta1"
+)
+
+const (
+	teamANamespace = "test-team-a"
+	teamBNamespace = "test-team-b"
+)
+
+var _ = Describe("Quota Tree E2E Test", func() {
+	var appwrappers []*arbv1.AppWrapper
+	var quotaNodes []*arbv1.QuotaNode
+
+	BeforeEach(func() {
+		appwrappers = []*arbv1.AppWrapper{}
+		quotaNodes = []*arbv1.QuotaNode{}
+	})
+
+	AfterEach(func() {
+		By("Cleaning up test objects")
+		cleanupTestObjects(ctx, appwrappers)
+		for _, quotaNode := range quotaNodes {
+			Expect(client.IgnoreNotFound(getClient(ctx).Delete(ctx, quotaNode))).To(Succeed())
+		}
+	})
+
+	// Create a quota node with the given guaranteed and borrowable CPU
+	createQuotaNode := func(name string, parent string, namespaces []string, guaranteed float64, borrowable float64) *arbv1.QuotaNode {
+		quotaNode := &arbv1.QuotaNode{
+			ObjectMeta: metav1.ObjectMeta{Name: appendRandomString(name)},
+			Spec: arbv1.QuotaNodeSpec{
+				Parent:     parent,
+				Namespaces: namespaces,
+				Guaranteed: v1.ResourceList{v1.ResourceCPU: *cpuDemand(guaranteed)},
+			},
+		}
+		if borrowable > 0 {
+			quotaNode.Spec.Borrowable = v1.ResourceList{v1.ResourceCPU: *cpuDemand(borrowable)}
+		}
+		Expect(getClient(ctx).Create(ctx, quotaNode)).To(Succeed())
+		quotaNodes = append(quotaNodes, quotaNode)
+		return quotaNode
+	}
+
+	// Return the CPU borrowed by a quota node
+	borrowedCPU := func(name string) func(g Gomega) int64 {
+		return func(g Gomega) int64 {
+			quotaNode := &arbv1.QuotaNode{}
+			g.Expect(getClient(ctx).Get(ctx, client.ObjectKey{Name: name}, quotaNode)).To(Succeed())
+			return quotaNode.Status.Borrowed.Cpu().MilliValue()
+		}
+	}
+
+	It("MCAD Quota Borrowing and Reclaiming Test", Label("slow"), func() {
+		requireControllerFlag(ctx, "--preemption")
+		ensureNamedNamespaceExists(ctx, teamANamespace)
+		ensureNamedNamespaceExists(ctx, teamBNamespace)
+
+		By("Create a root quota node with 50% of cluster CPU shared by two teams with 25% each")
+		root := createQuotaNode("e2e-root", "", nil, 0.5, 0)
+		teamA := createQuotaNode("e2e-team-a", root.Name, []string{teamANamespace}, 0.25, 0.25)
+		createQuotaNode("e2e-team-b", root.Name, []string{teamBNamespace}, 0.25, 0.25)
+
+		By("Request 40% of cluster CPU in 2 pods for team A")
+		aw := genericDeploymentWithCPUAW(teamANamespace, appendRandomString("aw-team-a"), cpuDemand(0.2), 2)
+		Expect(getClient(ctx).Create(ctx, aw)).To(Succeed())
+		appwrappers = append(appwrappers, aw)
+		Expect(waitAWPodsReady(ctx, aw)).Should(Succeed(), "Ready pods are expected for app wrapper: aw-team-a")
+
+		By("Validate that team A borrows quota")
+		Eventually(borrowedCPU(teamA.Name), 30*time.Second).Should(BeNumerically(">", 0))
+
+		By("Request 20% of cluster CPU in 1 pod for team B")
+		aw2 := genericDeploymentWithCPUAW(teamBNamespace, appendRandomString("aw-team-b"), cpuDemand(0.2), 1)
+		Expect(getClient(ctx).Create(ctx, aw2)).To(Succeed())
+		appwrappers = append(appwrappers, aw2)
+
+		By("Validate that the borrowed quota is reclaimed")
+		Eventually(AppWrapperQueuedReason(ctx, aw.Namespace, aw.Name), 2*time.Minute).Should(Equal(string(arbv1.QueuedPreempted)))
+		Expect(waitAWPodsReady(ctx, aw2)).Should(Succeed(), "Ready pods are expected for app wrapper: aw-team-b")
+
+		By("Validate that team A waits for quota")
+		Eventually(AppWrapperQueuedReason(ctx, aw.Namespace, aw.Name), 2*time.Minute).Should(Equal(string(arbv1.QueuedInsufficientQuota)))
+	})
+})
@@ -21,6 +21,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"strings"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -34,6 +35,7 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -43,6 +45,8 @@ import (
 
 const testNamespace = "test"
 
+const mcadNamespace = "mcad-system"
+
 const ninetySeconds = 90 * time.Second
 
 var clusterCapacity v1.ResourceList = v1.ResourceList{}
@@ -66,9 +70,13 @@ func extendContextWithClient(ctx context.Context) context.Context {
 }
 
 func ensureNamespaceExists(ctx context.Context) {
+	ensureNamedNamespaceExists(ctx, testNamespace)
+}
+
+func ensureNamedNamespaceExists(ctx context.Context, name string) {
 	err := getClient(ctx).Create(ctx, &v1.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: testNamespace,
+			Name: name,
 		},
 	})
 	Expect(client.IgnoreAlreadyExists(err)).NotTo(HaveOccurred())
@@ -107,6 +115,38 @@ func cleanupTestObjects(ctx context.Context, appwrappers []*arbv1.AppWrapper) {
 	}
 }
 
+// Return the value of a flag of the MCAD controller deployed in the mcad-system namespace, "true" for a boolean flag without value
+// Return false if no container has the flag, e.g., if MCAD is not deployed in the cluster
+func controllerFlag(ctx context.Context, flag string) (string, bool) {
+	deployments := &appsv1.DeploymentList{}
+	if err := getClient(ctx).List(ctx, deployments, client.InNamespace(mcadNamespace)); err != nil {
+		return "", false
+	}
+	for _, deployment := range deployments.Items {
+		for _, container := range deployment.Spec.Template.Spec.Containers {
+			for i, arg := range container.Args {
+				if value, ok := strings.CutPrefix(arg, flag+"="); ok {
+					return value, true
+				}
+				if arg == flag {
+					if i+1 < len(container.Args) && !strings.HasPrefix(container.Args[i+1], "-") {
+						return container.Args[i+1], true
+					}
+					return "true", true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// Skip the current test unless the MCAD controller runs with a boolean flag enabling an opt-in feature
+func requireControllerFlag(ctx context.Context, flag string) {
+	if value, ok := controllerFlag(ctx, flag); !ok || value != "true" {
+		Skip("MCAD controller does not run with " + flag)
+	}
+}
+
 func deleteAppWrapper(ctx context.Context, name string, namespace string) error {
 	foreground := metav1.DeletePropagationForeground
 	aw := &arbv1.AppWrapper{ObjectMeta: metav1.ObjectMeta{